@@ -0,0 +1,399 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Identity modes. In Legacy mode a caller without an alias still acts for the account named by
+// its CN before '@', as before canonical IDs, but only from the MSP that first changed state
+// under that name; in Canonical mode it acts for its canonical ID.
+const (
+	IdentityModeLegacy    = "Legacy"
+	IdentityModeCanonical = "Canonical"
+)
+
+// AccountAlias is a readable account ID registered to one canonical identity. Aliases also
+// carry existing short-name accounts, such as balance_alice, over to canonical identities.
+type AccountAlias struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Alias         string `json:"alias"`
+	CanonicalID   string `json:"canonicalId"` // <MSP ID>:<sha256 of certificate issuer and subject>
+	MSPID         string `json:"mspId"`
+	AssignedBy    string `json:"assignedBy"`
+	AssignedAt    int64  `json:"assignedAt"`
+}
+
+// CallerIdentity describes how the caller's certificate maps to an account
+type CallerIdentity struct {
+	CanonicalID string `json:"canonicalId"`
+	MSPID       string `json:"mspId"`
+	Alias       string `json:"alias"`
+	AccountID   string `json:"accountId"`
+	Mode        string `json:"mode"`
+}
+
+// GetCallerIdentity returns the caller's canonical ID, alias and the account it acts for
+func (s *SmartContract) GetCallerIdentity(ctx contractapi.TransactionContextInterface) (*CallerIdentity, error) {
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	caller := &CallerIdentity{CanonicalID: s.canonicalID(mspID, cert), MSPID: mspID}
+	caller.Alias, err = s.getCanonicalAlias(ctx, caller.CanonicalID)
+	if err != nil {
+		return nil, err
+	}
+	caller.Mode, err = s.getIdentityMode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caller.AccountID, err = s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return caller, nil
+}
+
+// RegisterAlias registers alias as the caller's account ID. The alias must not be in use as an
+// account yet; existing short-name accounts are claimed through AssignAlias.
+func (s *SmartContract) RegisterAlias(ctx contractapi.TransactionContextInterface, alias string) (*AccountAlias, error) {
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	canonicalID := s.canonicalID(mspID, cert)
+
+	existing, err := s.getCanonicalAlias(ctx, canonicalID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" {
+		return nil, fmt.Errorf("caller already has the alias %s", existing)
+	}
+
+	// Funds under the canonical ID would be stranded once the alias takes over
+	balanceBytes, err := ctx.GetStub().GetState(s.getBalanceKey(canonicalID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balance: %v", err)
+	}
+	if balanceBytes != nil {
+		return nil, fmt.Errorf("caller already holds an account under its canonical ID")
+	}
+
+	owner, err := s.getAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		return nil, fmt.Errorf("alias %s is already registered", alias)
+	}
+
+	// A CN short name is not unique across organisations, so only the central bank can hand
+	// an existing short-name account to an identity
+	balanceBytes, err = ctx.GetStub().GetState(s.getBalanceKey(alias))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balance: %v", err)
+	}
+	if balanceBytes != nil {
+		return nil, fmt.Errorf("account %s already exists; the central bank can assign it with AssignAlias", alias)
+	}
+	claimedBy, err := s.getShortNameMSP(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if claimedBy != "" && claimedBy != mspID {
+		return nil, fmt.Errorf("account %s already exists; the central bank can assign it with AssignAlias", alias)
+	}
+
+	return s.assignAlias(ctx, alias, canonicalID)
+}
+
+// AssignAlias registers alias to a canonical ID, replacing any previous owner (Central Bank
+// administrators only). It settles which identity owns a short-name account that several
+// identities could claim.
+func (s *SmartContract) AssignAlias(ctx contractapi.TransactionContextInterface, alias string, canonicalID string) (*AccountAlias, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can assign aliases: %v", err)
+	}
+
+	existing, err := s.getCanonicalAlias(ctx, canonicalID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" && existing != alias {
+		return nil, fmt.Errorf("%s already has the alias %s", canonicalID, existing)
+	}
+
+	// Release the alias from its previous owner
+	previous, err := s.getAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		err = ctx.GetStub().DelState(s.getCanonicalAliasKey(previous.CanonicalID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete canonical alias: %v", err)
+		}
+	}
+
+	return s.assignAlias(ctx, alias, canonicalID)
+}
+
+// SetIdentityMode switches between Legacy and Canonical account IDs (central bank administrators only)
+func (s *SmartContract) SetIdentityMode(ctx contractapi.TransactionContextInterface, mode string) error {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only central bank administrators can set the identity mode: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetIdentityMode")
+	if err != nil {
+		return err
+	}
+
+	if mode != IdentityModeLegacy && mode != IdentityModeCanonical {
+		return fmt.Errorf("mode must be %s or %s", IdentityModeLegacy, IdentityModeCanonical)
+	}
+
+	err = ctx.GetStub().PutState("identity_mode", []byte(mode))
+	if err != nil {
+		return fmt.Errorf("failed to put identity mode: %v", err)
+	}
+	return nil
+}
+
+// GetAlias returns the registration of an alias
+func (s *SmartContract) GetAlias(ctx contractapi.TransactionContextInterface, alias string) (*AccountAlias, error) {
+	accountAlias, err := s.getAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	if accountAlias == nil {
+		return nil, fmt.Errorf("alias %s is not registered", alias)
+	}
+	return accountAlias, nil
+}
+
+// identityAccountID returns the account a certificate acts for when it is not bound to one:
+// its alias, else in Legacy mode the short name from its CN, else its canonical ID
+func (s *SmartContract) identityAccountID(ctx contractapi.TransactionContextInterface, cert *x509.Certificate, mspID string, shortName string) (string, error) {
+	canonicalID := s.canonicalID(mspID, cert)
+
+	alias, err := s.getCanonicalAlias(ctx, canonicalID)
+	if err != nil || alias != "" {
+		return alias, err
+	}
+
+	mode, err := s.getIdentityMode(ctx)
+	if err != nil {
+		return "", err
+	}
+	if mode == IdentityModeCanonical {
+		return canonicalID, nil
+	}
+
+	// A short name registered to another identity is not the caller's
+	owner, err := s.getAlias(ctx, shortName)
+	if err != nil {
+		return "", err
+	}
+	if owner != nil {
+		return "", fmt.Errorf("account %s belongs to another identity", shortName)
+	}
+	err = s.validateAliasMSP(ctx, shortName, mspID)
+	if err != nil {
+		return "", err
+	}
+
+	// CN short names are not unique across organisations
+	claimedBy, err := s.getShortNameMSP(ctx, shortName)
+	if err != nil {
+		return "", err
+	}
+	if claimedBy != "" && claimedBy != mspID {
+		return "", fmt.Errorf("account %s belongs to an identity of %s", shortName, claimedBy)
+	}
+
+	return shortName, nil
+}
+
+// claimShortName records the caller's MSP as the owner of its short name the first time the
+// caller changes state under it in Legacy mode. The BeforeTransaction hook calls it for every
+// state-changing transaction, so queries never write a claim.
+func (s *SmartContract) claimShortName(ctx contractapi.TransactionContextInterface) error {
+	mode, err := s.getIdentityMode(ctx)
+	if err != nil || mode != IdentityModeLegacy {
+		return err
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	// Callers acting through an alias or a bound identity have nothing to claim, nor do callers
+	// that cannot act for an account; the transaction itself reports why
+	shortName := strings.Split(cert.Subject.CommonName, "@")[0]
+	accountID, err := s.getCallerID(ctx)
+	if err != nil || accountID != shortName {
+		return nil
+	}
+
+	claimedBy, err := s.getShortNameMSP(ctx, shortName)
+	if err != nil || claimedBy != "" {
+		return err
+	}
+	err = ctx.GetStub().PutState(s.getShortNameKey(shortName), []byte(mspID))
+	if err != nil {
+		return fmt.Errorf("failed to put short name claim: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) assignAlias(ctx contractapi.TransactionContextInterface, alias string, canonicalID string) (*AccountAlias, error) {
+	parts := strings.SplitN(canonicalID, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || len(parts[1]) != 2*sha256.Size {
+		return nil, fmt.Errorf("invalid canonical ID %s", canonicalID)
+	}
+	if alias == "" || strings.Contains(alias, ":") {
+		return nil, fmt.Errorf("alias must be non-empty and must not contain ':'")
+	}
+	err := s.validateAliasMSP(ctx, alias, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	assignedBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accountAlias := &AccountAlias{
+		DocType:     "accountAlias",
+		Alias:       alias,
+		CanonicalID: canonicalID,
+		MSPID:       parts[0],
+		AssignedBy:  assignedBy,
+		AssignedAt:  now,
+	}
+
+	accountAlias.SchemaVersion = s.currentSchemaVersion("accountAlias")
+	aliasJSON, err := json.Marshal(accountAlias)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal alias: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getAliasKey(alias), aliasJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put alias state: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getCanonicalAliasKey(canonicalID), []byte(alias))
+	if err != nil {
+		return nil, fmt.Errorf("failed to put canonical alias: %v", err)
+	}
+
+	return accountAlias, nil
+}
+
+// validateAliasMSP keeps the names the contract treats as the central bank or a commercial
+// bank to identities of those organisations
+func (s *SmartContract) validateAliasMSP(ctx contractapi.TransactionContextInterface, alias string, mspID string) error {
+	if alias == s.getCentralBankID() && mspID != "Org1MSP" {
+		return fmt.Errorf("%s is reserved for the central bank", alias)
+	}
+	if s.validateCommercialBank(ctx, alias) == nil && mspID != "Org2MSP" {
+		return fmt.Errorf("%s is reserved for commercial banks", alias)
+	}
+	return nil
+}
+
+// canonicalID identifies a certificate holder across organisations and re-enrollment
+func (s *SmartContract) canonicalID(mspID string, cert *x509.Certificate) string {
+	sum := sha256.Sum256([]byte(cert.Issuer.String() + "|" + cert.Subject.String()))
+	return mspID + ":" + hex.EncodeToString(sum[:])
+}
+
+func (s *SmartContract) getAliasKey(alias string) string {
+	return "alias_" + alias
+}
+
+func (s *SmartContract) getCanonicalAliasKey(canonicalID string) string {
+	return "aliasof_" + canonicalID
+}
+
+func (s *SmartContract) getShortNameKey(shortName string) string {
+	return "shortname_" + shortName
+}
+
+// getShortNameMSP returns the MSP that claimed a Legacy short name, or "" if none has
+func (s *SmartContract) getShortNameMSP(ctx contractapi.TransactionContextInterface, shortName string) (string, error) {
+	mspBytes, err := ctx.GetStub().GetState(s.getShortNameKey(shortName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read short name claim: %v", err)
+	}
+	return string(mspBytes), nil
+}
+
+func (s *SmartContract) getIdentityMode(ctx contractapi.TransactionContextInterface) (string, error) {
+	modeBytes, err := ctx.GetStub().GetState("identity_mode")
+	if err != nil {
+		return "", fmt.Errorf("failed to read identity mode: %v", err)
+	}
+	if modeBytes == nil {
+		return IdentityModeLegacy, nil
+	}
+	return string(modeBytes), nil
+}
+
+func (s *SmartContract) getAlias(ctx contractapi.TransactionContextInterface, alias string) (*AccountAlias, error) {
+	aliasBytes, err := ctx.GetStub().GetState(s.getAliasKey(alias))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alias: %v", err)
+	}
+	if aliasBytes == nil {
+		return nil, nil
+	}
+
+	var accountAlias AccountAlias
+	err = s.decodeDocument("accountAlias", aliasBytes, &accountAlias)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal alias: %v", err)
+	}
+
+	return &accountAlias, nil
+}
+
+// getCanonicalAlias returns the alias registered to a canonical ID, or "" if none
+func (s *SmartContract) getCanonicalAlias(ctx contractapi.TransactionContextInterface, canonicalID string) (string, error) {
+	aliasBytes, err := ctx.GetStub().GetState(s.getCanonicalAliasKey(canonicalID))
+	if err != nil {
+		return "", fmt.Errorf("failed to read canonical alias: %v", err)
+	}
+	return string(aliasBytes), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestRegisteredAliasOwnsShortName(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	// An existing short-name account is handed over by the central bank, not claimed
+	var caller CallerIdentity
+	l.asUser("alice").mustFailWith("account alice already exists", "RegisterAlias", "alice")
+	l.mustQuery(&caller, "GetCallerIdentity")
+	l.mustFailWith("only central bank can assign aliases", "AssignAlias", "alice", caller.CanonicalID)
+
+	var alias AccountAlias
+	l.asCentralBank().mustQuery(&alias, "AssignAlias", "alice", caller.CanonicalID)
+	if alias.MSPID != "Org1MSP" || alias.AssignedBy != "admin" {
+		t.Fatalf("unexpected alias %+v", alias)
+	}
+	l.asUser("alice").mustFailWith("caller already has the alias alice", "RegisterAlias", "alice2")
+	l.asUser("erin").mustInvoke("RegisterAlias", "erin-shop")
+
+	// The same short name from another organisation no longer reaches alice's account
+	l.as("Org2MSP", "alice@org2.example.com").mustFailWith("account alice belongs to another identity", "TransferTokens", "alice", "bob", 10.0)
+	l.as("Org2MSP", "carol@org2.example.com").mustFailWith("already registered", "RegisterAlias", "alice")
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 10.0)
+	l.asUser("dave").mustFailWith("account bob already exists", "RegisterAlias", "bob")
+
+	// Bank and central bank names stay with their organisations
+	l.asUser("bank7").mustFailWith("reserved for commercial banks", "TransferTokens", "bank7", "alice", 1.0)
+}
+
+func TestCanonicalIdentityMode(t *testing.T) {
+	l := newTestLedger(t)
+	l.asBank("bank1").mustFailWith("only central bank", "SetIdentityMode", IdentityModeCanonical)
+	l.asUser("mallory").mustFailWith("only central bank administrators", "SetIdentityMode", IdentityModeCanonical)
+	l.asCentralBank().mustFailWith("mode must be", "SetIdentityMode", "Strict")
+	l.mustInvoke("SetIdentityMode", IdentityModeCanonical)
+
+	var caller CallerIdentity
+	l.asUser("carol").mustQuery(&caller, "GetCallerIdentity")
+	if caller.Mode != IdentityModeCanonical || caller.AccountID != caller.CanonicalID || caller.Alias != "" {
+		t.Fatalf("unexpected canonical caller %+v", caller)
+	}
+
+	l.asCentralBank().mustFailWith("invalid canonical ID", "AssignAlias", "carol", "Org1MSP:abc")
+	l.mustInvoke("AssignAlias", "carol", caller.CanonicalID)
+	l.asUser("carol").mustQuery(&caller, "GetCallerIdentity")
+	if caller.AccountID != "carol" || caller.Alias != "carol" {
+		t.Fatalf("assigned alias was not used %+v", caller)
+	}
+}
+
+func TestShortNameStaysWithTheFirstMSP(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "user7", 100)
+
+	// A query does not claim the name
+	l.as("Org2MSP", "user7@org2.example.com").mustQuery(&AccountBalance{}, "GetBalance", "user7")
+	if _, ok := l.stub.State["shortname_user7"]; ok {
+		t.Fatalf("a query claimed user7")
+	}
+
+	l.asUser("user7").mustInvoke("TransferTokens", "user7", "bob", 10.0)
+	if claim := string(l.stub.State["shortname_user7"]); claim != "Org1MSP" {
+		t.Fatalf("user7 is claimed by %q, expected Org1MSP", claim)
+	}
+
+	l.as("Org2MSP", "user7@org2.example.com").mustFailWith("account user7 belongs to an identity of Org1MSP", "TransferTokens", "user7", "bob", 10.0)
+	l.mustFailWith("account user7 already exists", "RegisterAlias", "user7")
+	l.expectBalance("user7", 90)
+	l.asUser("user7").mustInvoke("TransferTokens", "user7", "bob", 10.0)
+}
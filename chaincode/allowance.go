@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AllowanceRecord is the amount an owner has pre-approved a spender, such as a merchant or
+// payment service provider, to pull from its account
+type AllowanceRecord struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	OwnerID       string  `json:"ownerId"`
+	SpenderID     string  `json:"spenderId"`
+	Amount        float64 `json:"amount"`    // Remaining allowance
+	ExpiresAt     int64   `json:"expiresAt"` // 0 means no expiry
+	Spent         float64 `json:"spent"`     // Pulled since the allowance was last set
+	CreatedAt     int64   `json:"createdAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// Approve lets spender pull up to amount from the caller's account until expiresAt (0 for no
+// expiry), replacing any earlier allowance to the same spender
+func (s *SmartContract) Approve(ctx contractapi.TransactionContextInterface, spenderID string, amount float64, expiresAt int64) (*AllowanceRecord, error) {
+	ownerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if spenderID == "" || spenderID == ownerID {
+		return nil, fmt.Errorf("spender must be another account")
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive; use RevokeAllowance to remove an allowance")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt != 0 && expiresAt <= now {
+		return nil, fmt.Errorf("expiry must be in the future")
+	}
+
+	allowance := &AllowanceRecord{
+		DocType:    "allowance",
+		OwnerID:    ownerID,
+		SpenderID:  spenderID,
+		Amount:     amount,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  now,
+		ModifiedAt: now,
+	}
+
+	err = s.putAllowance(ctx, allowance)
+	if err != nil {
+		return nil, err
+	}
+
+	return allowance, nil
+}
+
+// RevokeAllowance removes the caller's allowance to spender
+func (s *SmartContract) RevokeAllowance(ctx contractapi.TransactionContextInterface, spenderID string) error {
+	ownerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowance, err := s.getAllowance(ctx, ownerID, spenderID)
+	if err != nil {
+		return err
+	}
+	if allowance == nil {
+		return fmt.Errorf("%s has no allowance from %s", spenderID, ownerID)
+	}
+
+	err = ctx.GetStub().DelState(s.getAllowanceKey(ownerID, spenderID))
+	if err != nil {
+		return fmt.Errorf("failed to delete allowance: %v", err)
+	}
+	return nil
+}
+
+// Allowance returns how much spender can still pull from owner's account; 0 once expired
+func (s *SmartContract) Allowance(ctx contractapi.TransactionContextInterface, ownerID string, spenderID string) (float64, error) {
+	allowance, err := s.getAllowance(ctx, ownerID, spenderID)
+	if err != nil || allowance == nil {
+		return 0, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if allowance.ExpiresAt != 0 && now > allowance.ExpiresAt {
+		return 0, nil
+	}
+
+	return allowance.Amount, nil
+}
+
+// TransferFrom pulls amount from owner's account to toID against the caller's allowance. The
+// owner's balance is checked as for TransferTokens.
+func (s *SmartContract) TransferFrom(ctx contractapi.TransactionContextInterface, ownerID string, toID string, amount float64) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if toID == "" {
+		return fmt.Errorf("recipient is required")
+	}
+	if toID == ownerID {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+
+	spenderID, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	allowance, err := s.getAllowance(ctx, ownerID, spenderID)
+	if err != nil {
+		return err
+	}
+	if allowance == nil {
+		return fmt.Errorf("%s has no allowance from %s", spenderID, ownerID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if allowance.ExpiresAt != 0 && now > allowance.ExpiresAt {
+		return fmt.Errorf("allowance from %s to %s has expired", ownerID, spenderID)
+	}
+	if amount > allowance.Amount {
+		return fmt.Errorf("amount exceeds the remaining allowance of %.2f", allowance.Amount)
+	}
+
+	err = s.moveFunds(ctx, ownerID, toID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Decrement the allowance
+	allowance.Amount -= amount
+	allowance.Spent += amount
+	allowance.ModifiedAt = now
+	err = s.putAllowance(ctx, allowance)
+	if err != nil {
+		return err
+	}
+
+	// Charge any transfer fee to the owner, as for its own transfers
+	fee, err := s.chargeUserFee(ctx, "Transfer", ownerID, toID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Record transaction, referencing the spender that pulled it
+	transaction := s.newTransaction(ctx, ownerID, toID, amount, "TransferFrom")
+	transaction.Reference = spenderID
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
+	}
+	err = s.putTransaction(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	// Sweep anything above the receiver's holding limit to its bank
+	err = s.applyWaterfall(ctx, toID)
+	if err != nil {
+		return err
+	}
+
+	// Release any payments the receiver has queued
+	return s.releaseQueuedPayments(ctx, toID)
+}
+
+func (s *SmartContract) getAllowanceKey(ownerID string, spenderID string) string {
+	return "allowance_" + ownerID + "|" + spenderID
+}
+
+func (s *SmartContract) getAllowance(ctx contractapi.TransactionContextInterface, ownerID string, spenderID string) (*AllowanceRecord, error) {
+	allowanceBytes, err := ctx.GetStub().GetState(s.getAllowanceKey(ownerID, spenderID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowance: %v", err)
+	}
+	if allowanceBytes == nil {
+		return nil, nil
+	}
+
+	var allowance AllowanceRecord
+	err = s.decodeDocument("allowance", allowanceBytes, &allowance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal allowance: %v", err)
+	}
+
+	return &allowance, nil
+}
+
+func (s *SmartContract) putAllowance(ctx contractapi.TransactionContextInterface, allowance *AllowanceRecord) error {
+	allowance.SchemaVersion = s.currentSchemaVersion("allowance")
+	allowanceJSON, err := json.Marshal(allowance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowance: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getAllowanceKey(allowance.OwnerID, allowance.SpenderID), allowanceJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put allowance state: %v", err)
+	}
+	return nil
+}
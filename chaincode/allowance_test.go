@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestTransferFromAllowance(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustFailWith("spender must be another account", "Approve", "alice", 10.0, int64(0))
+	l.mustFailWith("expiry must be in the future", "Approve", "shop", 10.0, int64(testStartTime))
+	var allowance AllowanceRecord
+	l.mustQuery(&allowance, "Approve", "shop", 30.0, int64(0))
+	if allowance.CreatedAt != l.now || allowance.ModifiedAt != l.now {
+		t.Fatalf("allowance is not stamped with the transaction time %+v", allowance)
+	}
+
+	l.asUser("mallory").mustFailWith("mallory has no allowance from alice", "TransferFrom", "alice", "mallory", 10.0)
+	l.asUser("shop").mustFailWith("exceeds the remaining allowance of 30.00", "TransferFrom", "alice", "shop", 40.0)
+	l.mustFailWith("recipient is required", "TransferFrom", "alice", "", 5.0)
+	l.mustFailWith("cannot transfer to the same account", "TransferFrom", "alice", "alice", 5.0)
+	l.mustInvoke("TransferFrom", "alice", "shop", 20.0)
+	transferTxID := l.lastTxID()
+
+	l.expectBalance("alice", 80)
+	l.expectBalance("shop", 20)
+	if transfer := l.transaction(transferTxID); transfer.Type != "TransferFrom" || transfer.FromID != "alice" || transfer.Reference != "shop" {
+		t.Fatalf("unexpected pull transaction %+v", transfer)
+	}
+
+	var remaining float64
+	l.mustQuery(&remaining, "Allowance", "alice", "shop")
+	if remaining != 10 {
+		t.Fatalf("remaining allowance is %.2f, expected 10", remaining)
+	}
+
+	l.asUser("alice").mustInvoke("RevokeAllowance", "shop")
+	l.asUser("shop").mustFailWith("shop has no allowance from alice", "TransferFrom", "alice", "shop", 5.0)
+}
+
+func TestAllowanceExpires(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asUser("alice").mustInvoke("Approve", "shop", 30.0, int64(testStartTime+3600))
+
+	l.now += 3601
+	l.asUser("shop").mustFailWith("has expired", "TransferFrom", "alice", "shop", 5.0)
+	l.expectBalance("alice", 100)
+}
+
+func TestTransferFromPaysPayeeBankFee(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "shop", 10)
+	l.fund("bank2", "alice", 100)
+	l.link("bank1", "shop")
+	l.asCentralBank().mustInvoke("SetFeeSchedule", "Transfer", "", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "fees")
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "bank1")
+
+	// A pulled payment is charged like the owner's own transfer to shop
+	l.asUser("alice").mustInvoke("Approve", "shop", 30.0, int64(0))
+	l.asUser("shop").mustInvoke("TransferFrom", "alice", "shop", 20.0)
+	l.expectBalance("alice", 79)
+	l.expectBalance("shop", 30)
+	l.expectBalance("bank1", 1)
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// approvalActions are the transactions that go through maker-checker once an approval policy
+// is set. TransferToCB only needs approval above the policy's large transfer limit.
+var approvalActions = map[string]bool{
+	"IssueTokens":            true,
+	"TransferToCB":           true,
+	"SetApprovalPolicy":      true,
+	"SetRateSchedule":        true,
+	"SetReserveRequirement":  true,
+	"SetHoldingLimit":        true,
+	"SetIntradayCreditLimit": true,
+	"SetInterbankLimit":      true,
+	"SetIssuancePolicy":      true,
+	"SetEmergencyOperators":  true,
+	"SetIdentityMode":        true,
+	"RegisterCurrency":       true,
+	"SetCurrencyStatus":      true,
+	"IssueCurrency":          true,
+	"SetFeeSchedule":         true,
+	"RemoveFeeSchedule":      true,
+}
+
+// ApprovalPolicy is the central bank's maker-checker policy: Threshold of the Approvers must
+// sign off on a proposal before its action runs
+type ApprovalPolicy struct {
+	DocType            string   `json:"docType"`
+	SchemaVersion      int      `json:"schemaVersion"`
+	Approvers          []string `json:"approvers"`
+	Threshold          int      `json:"threshold"`
+	LargeTransferLimit float64  `json:"largeTransferLimit"` // TransferToCB above this needs approval; 0 means always
+	ProposalTTL        int64    `json:"proposalTtl"`        // Seconds a proposal stays open
+	SetBy              string   `json:"setBy"`
+	ModifiedAt         int64    `json:"modifiedAt"`
+}
+
+// Proposal is an action awaiting approval
+type Proposal struct {
+	DocType       string              `json:"docType"`
+	SchemaVersion int                 `json:"schemaVersion"`
+	ID            string              `json:"id"`
+	Action        string              `json:"action"`
+	Args          string              `json:"args"` // JSON array of the action's arguments
+	ProposerID    string              `json:"proposerId"`
+	Approvals     []*ProposalApproval `json:"approvals"`
+	Threshold     int                 `json:"threshold"`
+	Status        string              `json:"status"` // Pending, Executed, Rejected, Cancelled
+	ExpiresAt     int64               `json:"expiresAt"`
+	ExecutedBy    string              `json:"executedBy"`
+	ExecutedAt    int64               `json:"executedAt"`
+	ExecutionTx   string              `json:"executionTx"`
+	Reason        string              `json:"reason"`
+	CreatedAt     int64               `json:"createdAt"`
+	ModifiedAt    int64               `json:"modifiedAt"`
+}
+
+// ProposalApproval records one approver's sign-off
+type ProposalApproval struct {
+	ApproverID string `json:"approverId"`
+	MSPID      string `json:"mspId"`
+	Identity   string `json:"identity"` // Full client identity of the approving certificate
+	ApprovedAt int64  `json:"approvedAt"`
+}
+
+// SetApprovalPolicy sets the maker-checker policy (central bank administrators only). The first
+// policy can be set directly; after that, changes are themselves subject to approval.
+func (s *SmartContract) SetApprovalPolicy(ctx contractapi.TransactionContextInterface, approvers []string, threshold int, largeTransferLimit float64, proposalTTL int64) (*ApprovalPolicy, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can set the approval policy: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetApprovalPolicy")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, approver := range approvers {
+		if approver == "" || seen[approver] {
+			return nil, fmt.Errorf("approvers must be distinct and non-empty")
+		}
+		seen[approver] = true
+	}
+	if threshold < 1 || threshold > len(approvers) {
+		return nil, fmt.Errorf("threshold must be between 1 and the number of approvers")
+	}
+	if largeTransferLimit < 0 {
+		return nil, fmt.Errorf("large transfer limit must not be negative")
+	}
+	if proposalTTL <= 0 {
+		return nil, fmt.Errorf("proposal TTL must be positive")
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &ApprovalPolicy{
+		DocType:            "approvalPolicy",
+		Approvers:          approvers,
+		Threshold:          threshold,
+		LargeTransferLimit: largeTransferLimit,
+		ProposalTTL:        proposalTTL,
+		SetBy:              setBy,
+		ModifiedAt:         time.Now().Unix(),
+	}
+
+	policy.SchemaVersion = s.currentSchemaVersion("approvalPolicy")
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal approval policy: %v", err)
+	}
+	err = ctx.GetStub().PutState("approval_policy", policyJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put approval policy state: %v", err)
+	}
+
+	return policy, nil
+}
+
+// GetApprovalPolicy returns the current maker-checker policy
+func (s *SmartContract) GetApprovalPolicy(ctx contractapi.TransactionContextInterface) (*ApprovalPolicy, error) {
+	policy, err := s.getApprovalPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("no approval policy has been set")
+	}
+	return policy, nil
+}
+
+// ProposeAction proposes running action with args, a JSON array of its arguments after the
+// context, e.g. action "IssueTokens" with args "[1000]" (central bank administrators only)
+func (s *SmartContract) ProposeAction(ctx contractapi.TransactionContextInterface, action string, args string) (*Proposal, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can propose actions: %v", err)
+	}
+
+	policy, err := s.GetApprovalPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !approvalActions[action] {
+		return nil, fmt.Errorf("%s is not an action that needs approval", action)
+	}
+	// Check the arguments now rather than when the last approval arrives
+	_, err = s.actionArguments(ctx, action, args)
+	if err != nil {
+		return nil, err
+	}
+
+	proposerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal := &Proposal{
+		DocType:    "proposal",
+		ID:         ctx.GetStub().GetTxID(),
+		Action:     action,
+		Args:       args,
+		ProposerID: proposerID,
+		Approvals:  []*ProposalApproval{},
+		Threshold:  policy.Threshold,
+		Status:     "Pending",
+		ExpiresAt:  now + policy.ProposalTTL,
+		CreatedAt:  time.Now().Unix(),
+		ModifiedAt: time.Now().Unix(),
+	}
+
+	err = s.putProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// ApproveProposal records the caller's approval. The approval that meets the threshold runs
+// the action in the same transaction; if the action fails, so does the approval.
+func (s *SmartContract) ApproveProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*Proposal, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank officers can approve proposals: %v", err)
+	}
+
+	proposal, err := s.getPendingProposal(ctx, proposalID)
+	if err != nil {
+		return nil, err
+	}
+
+	approverID, err := s.validateApprover(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+	if approverID == proposal.ProposerID {
+		return nil, fmt.Errorf("the proposer cannot approve its own proposal")
+	}
+	for _, approval := range proposal.Approvals {
+		if approval.ApproverID == approverID {
+			return nil, fmt.Errorf("%s has already approved proposal %s", approverID, proposalID)
+		}
+	}
+
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	identity, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client identity: %v", err)
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Approvals = append(proposal.Approvals, &ProposalApproval{
+		ApproverID: approverID,
+		MSPID:      mspID,
+		Identity:   identity,
+		ApprovedAt: now,
+	})
+	proposal.ModifiedAt = time.Now().Unix()
+
+	if len(proposal.Approvals) >= proposal.Threshold {
+		err = s.executeProposal(ctx, proposal)
+		if err != nil {
+			return nil, fmt.Errorf("proposal %s reached its threshold but %s failed: %v", proposalID, proposal.Action, err)
+		}
+		proposal.Status = "Executed"
+		proposal.ExecutedBy = approverID
+		proposal.ExecutedAt = now
+		proposal.ExecutionTx = ctx.GetStub().GetTxID()
+	}
+
+	err = s.putProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// RejectProposal closes a pending proposal without running it (any approver)
+func (s *SmartContract) RejectProposal(ctx contractapi.TransactionContextInterface, proposalID string, reason string) (*Proposal, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank officers can reject proposals: %v", err)
+	}
+
+	proposal, err := s.getPendingProposal(ctx, proposalID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.validateApprover(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Status = "Rejected"
+	proposal.Reason = reason
+	proposal.ModifiedAt = time.Now().Unix()
+
+	err = s.putProposal(ctx, proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// CancelProposal withdraws a pending proposal (proposer only)
+func (s *SmartContract) CancelProposal(ctx contractapi.TransactionContextInterface, proposalID string) error {
+	proposal, err := s.getProposal(ctx, proposalID)
+	if err != nil {
+		return err
+	}
+	if proposal.Status != "Pending" {
+		return fmt.Errorf("proposal %s is %s", proposalID, proposal.Status)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != proposal.ProposerID {
+		return fmt.Errorf("caller not authorized to cancel this proposal")
+	}
+
+	proposal.Status = "Cancelled"
+	proposal.ModifiedAt = time.Now().Unix()
+
+	return s.putProposal(ctx, proposal)
+}
+
+// GetProposal returns a proposal and its approvals
+func (s *SmartContract) GetProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*Proposal, error) {
+	return s.getProposal(ctx, proposalID)
+}
+
+// requireApproval fails unless no approval policy is set or the action is running as an
+// approved proposal
+func (s *SmartContract) requireApproval(ctx contractapi.TransactionContextInterface, action string) error {
+	if tc, ok := ctx.(*TransactionContext); ok && tc.approvedAction == action {
+		return nil
+	}
+
+	policy, err := s.getApprovalPolicy(ctx)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	return fmt.Errorf("%s requires %d of %d approvals; submit it with ProposeAction", action, policy.Threshold, len(policy.Approvers))
+}
+
+// requireTransferApproval applies maker-checker to a TransferToCB above the large transfer limit
+func (s *SmartContract) requireTransferApproval(ctx contractapi.TransactionContextInterface, amount float64) error {
+	policy, err := s.getApprovalPolicy(ctx)
+	if err != nil || policy == nil {
+		return err
+	}
+	if policy.LargeTransferLimit > 0 && amount <= policy.LargeTransferLimit {
+		return nil
+	}
+	return s.requireApproval(ctx, "TransferToCB")
+}
+
+// executeProposal calls the proposal's action with its stored arguments
+func (s *SmartContract) executeProposal(ctx contractapi.TransactionContextInterface, proposal *Proposal) error {
+	tc, ok := ctx.(*TransactionContext)
+	if !ok {
+		return fmt.Errorf("proposals need the CBDC transaction context")
+	}
+
+	// The pause hook only saw ApproveProposal, so check the action itself
+	err := s.checkNotPaused(ctx, proposal.Action)
+	if err != nil {
+		return err
+	}
+
+	in, err := s.actionArguments(ctx, proposal.Action, proposal.Args)
+	if err != nil {
+		return err
+	}
+
+	tc.approvedAction = proposal.Action
+	defer func() { tc.approvedAction = "" }()
+
+	out := reflect.ValueOf(s).MethodByName(proposal.Action).Call(in)
+	if errValue := out[len(out)-1]; !errValue.IsNil() {
+		return errValue.Interface().(error)
+	}
+	return nil
+}
+
+// actionArguments decodes args into the parameters of the action's transaction function
+func (s *SmartContract) actionArguments(ctx contractapi.TransactionContextInterface, action string, args string) ([]reflect.Value, error) {
+	method := reflect.ValueOf(s).MethodByName(action)
+	if !method.IsValid() {
+		return nil, fmt.Errorf("unknown action %s", action)
+	}
+
+	var rawArgs []json.RawMessage
+	err := json.Unmarshal([]byte(args), &rawArgs)
+	if err != nil {
+		return nil, fmt.Errorf("args must be a JSON array: %v", err)
+	}
+
+	methodType := method.Type()
+	if len(rawArgs) != methodType.NumIn()-1 {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", action, methodType.NumIn()-1, len(rawArgs))
+	}
+
+	in := []reflect.Value{reflect.ValueOf(ctx)}
+	for i, raw := range rawArgs {
+		arg := reflect.New(methodType.In(i + 1))
+		err = json.Unmarshal(raw, arg.Interface())
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %v", i+1, action, err)
+		}
+		in = append(in, arg.Elem())
+	}
+
+	return in, nil
+}
+
+// validateApprover checks the caller is one of the policy's approvers and returns its ID
+func (s *SmartContract) validateApprover(ctx contractapi.TransactionContextInterface, proposal *Proposal) (string, error) {
+	policy, err := s.GetApprovalPolicy(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	approverID, err := s.getCallerID(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, approver := range policy.Approvers {
+		if approver == approverID {
+			return approverID, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not an approver", approverID)
+}
+
+func (s *SmartContract) getApprovalPolicy(ctx contractapi.TransactionContextInterface) (*ApprovalPolicy, error) {
+	policyBytes, err := ctx.GetStub().GetState("approval_policy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approval policy: %v", err)
+	}
+	if policyBytes == nil {
+		return nil, nil
+	}
+
+	var policy ApprovalPolicy
+	err = s.decodeDocument("approvalPolicy", policyBytes, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal approval policy: %v", err)
+	}
+
+	return &policy, nil
+}
+
+func (s *SmartContract) getProposalKey(proposalID string) string {
+	return "proposal_" + proposalID
+}
+
+func (s *SmartContract) getProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*Proposal, error) {
+	proposalBytes, err := ctx.GetStub().GetState(s.getProposalKey(proposalID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proposal: %v", err)
+	}
+	if proposalBytes == nil {
+		return nil, fmt.Errorf("proposal %s does not exist", proposalID)
+	}
+
+	var proposal Proposal
+	err = s.decodeDocument("proposal", proposalBytes, &proposal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proposal: %v", err)
+	}
+
+	return &proposal, nil
+}
+
+// getPendingProposal returns a proposal that can still be approved or rejected
+func (s *SmartContract) getPendingProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*Proposal, error) {
+	proposal, err := s.getProposal(ctx, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Status != "Pending" {
+		return nil, fmt.Errorf("proposal %s is %s", proposalID, proposal.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now > proposal.ExpiresAt {
+		return nil, fmt.Errorf("proposal %s expired at %d", proposalID, proposal.ExpiresAt)
+	}
+
+	return proposal, nil
+}
+
+func (s *SmartContract) putProposal(ctx contractapi.TransactionContextInterface, proposal *Proposal) error {
+	proposal.SchemaVersion = s.currentSchemaVersion("proposal")
+	proposalJSON, err := json.Marshal(proposal)
+	if err != nil {
+		return fmt.Errorf("failed to marshal proposal: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getProposalKey(proposal.ID), proposalJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put proposal state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+// asOfficer makes later transactions come from a central bank officer with an admin certificate
+func (l *testLedger) asOfficer(officerID string) *testLedger {
+	return l.as("Org1MSP", officerID+"@org1.example.com")
+}
+
+// setTestApprovalPolicy requires two of admin1, admin2 and admin3 to approve, with transfers
+// above 100 needing approval
+func (l *testLedger) setTestApprovalPolicy() {
+	l.t.Helper()
+	l.asCentralBank().mustInvoke("SetApprovalPolicy", []string{"admin1", "admin2", "admin3"}, 2, 100.0, int64(3600))
+}
+
+func TestIssuanceNeedsApproval(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustFailWith("threshold must be between", "SetApprovalPolicy", []string{"admin1"}, 2, 0.0, int64(3600))
+	l.setTestApprovalPolicy()
+
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "IssueTokens", 500.0)
+	l.mustFailWith("requires 2 of 3 approvals", "SetApprovalPolicy", []string{"admin"}, 1, 0.0, int64(3600))
+	l.mustFailWith("not an action that needs approval", "ProposeAction", "TransferTokens", `["a","b",1]`)
+	l.mustFailWith("takes 1 arguments", "ProposeAction", "IssueTokens", `[1, 2]`)
+
+	var proposal Proposal
+	l.mustQuery(&proposal, "ProposeAction", "IssueTokens", `[500]`)
+	l.mustFailWith("is not an approver", "ApproveProposal", proposal.ID)
+
+	l.asOfficer("admin1").mustQuery(&proposal, "ApproveProposal", proposal.ID)
+	if proposal.Status != "Pending" || len(proposal.Approvals) != 1 {
+		t.Fatalf("unexpected proposal after one approval %+v", proposal)
+	}
+	l.mustFailWith("already approved", "ApproveProposal", proposal.ID)
+	l.expectBalance("central-bank", 0)
+
+	l.asOfficer("admin2").mustQuery(&proposal, "ApproveProposal", proposal.ID)
+	if proposal.Status != "Executed" || proposal.ExecutedBy != "admin2" {
+		t.Fatalf("unexpected proposal after the threshold was met %+v", proposal)
+	}
+	l.expectBalance("central-bank", 500)
+	l.asOfficer("admin3").mustFailWith("is Executed", "ApproveProposal", proposal.ID)
+}
+
+func TestLargeTransferNeedsApproval(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 500.0)
+	l.setTestApprovalPolicy()
+
+	l.asCentralBank().mustInvoke("TransferToCB", "bank1", 100.0)
+	l.mustFailWith("requires 2 of 3 approvals", "TransferToCB", "bank1", 150.0)
+
+	var proposal Proposal
+	l.mustQuery(&proposal, "ProposeAction", "TransferToCB", `["bank1", 150]`)
+	l.asOfficer("admin1").mustInvoke("ApproveProposal", proposal.ID)
+	l.asOfficer("admin3").mustInvoke("ApproveProposal", proposal.ID)
+	l.expectBalance("bank1", 250)
+}
+
+func TestRejectAndExpireProposal(t *testing.T) {
+	l := newTestLedger(t)
+	l.setTestApprovalPolicy()
+
+	var rejected, cancelled, expired Proposal
+	l.asCentralBank().mustQuery(&rejected, "ProposeAction", "SetHoldingLimit", `["", 50]`)
+	l.mustQuery(&cancelled, "ProposeAction", "SetHoldingLimit", `["", 60]`)
+	l.mustQuery(&expired, "ProposeAction", "SetHoldingLimit", `["", 70]`)
+
+	l.asBank("bank1").mustFailWith("only central bank officers", "RejectProposal", rejected.ID, "too low")
+	l.asOfficer("admin1").mustInvoke("RejectProposal", rejected.ID, "too low")
+	l.mustFailWith("is Rejected", "ApproveProposal", rejected.ID)
+
+	l.mustFailWith("not authorized", "CancelProposal", cancelled.ID)
+	l.asCentralBank().mustInvoke("CancelProposal", cancelled.ID)
+
+	l.now += 3601
+	l.asOfficer("admin1").mustFailWith("expired", "ApproveProposal", expired.ID)
+
+	var settings AccountSettings
+	l.mustQuery(&settings, "GetAccountSettings", "")
+	if settings.HoldingLimit != 0 {
+		t.Fatalf("holding limit %.2f was set without approval", settings.HoldingLimit)
+	}
+}
+
+func TestGovernanceNeedsAdministrators(t *testing.T) {
+	l := newTestLedger(t)
+
+	// End users enrol in Org1 too, but cannot take over the approval policy
+	l.asUser("alice").mustFailWith("only central bank administrators", "SetApprovalPolicy", []string{"alice"}, 1, 0.0, int64(3600))
+	l.mustFailWith("only central bank administrators", "SetRateSchedule", 100.0, 0.5, -0.125)
+	l.setTestApprovalPolicy()
+	l.asUser("alice").mustFailWith("only central bank administrators", "ProposeAction", "IssueTokens", `[500]`)
+
+	var proposal Proposal
+	l.asCentralBank().mustQuery(&proposal, "ProposeAction", "IssueTokens", `[500]`)
+	l.asUser("mallory").mustFailWith("only central bank officers", "ApproveProposal", proposal.ID)
+	l.as("Org1MSP", "user1@org1.example.com").mustFailWith("only central bank officers", "RejectProposal", proposal.ID, "no")
+}
+
+func TestCurrencyAndFeeAdministrationNeedApproval(t *testing.T) {
+	l := newTestLedger(t)
+	l.setTestApprovalPolicy()
+
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "RegisterCurrency", "WCBDC", "Wholesale CBDC", "Org1MSP", "central-bank", 2, "998")
+	l.mustApprove("RegisterCurrency", `["WCBDC", "Wholesale CBDC", "Org1MSP", "central-bank", 2, "998"]`)
+
+	// A currency the central bank issues itself goes through the same approvals as IssueTokens
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "IssueCurrency", "WCBDC", 100.0)
+	l.mustApprove("IssueCurrency", `["WCBDC", 100]`)
+	var balance AccountBalance
+	l.mustQuery(&balance, "GetCurrencyBalance", "central-bank", "WCBDC")
+	if balance.Balance != 100 {
+		t.Fatalf("central bank holds %.2f WCBDC after the approved issuance, expected 100", balance.Balance)
+	}
+
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "SetCurrencyStatus", "WCBDC", "Suspended")
+	l.mustApprove("SetCurrencyStatus", `["WCBDC", "Suspended"]`)
+
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "SetFeeSchedule", "Transfer", "", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "central-bank")
+	l.mustApprove("SetFeeSchedule", `["Transfer", "", "Flat", 1, 0, [], 0, "Payer", "central-bank"]`)
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "RemoveFeeSchedule", "Transfer", "")
+	l.mustApprove("RemoveFeeSchedule", `["Transfer", ""]`)
+
+	// A commercial bank's own schedule is not central bank governance
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payee", "bank1")
+}
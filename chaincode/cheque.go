@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ChequeKey is an ECDSA public key an account signs cheques with
+type ChequeKey struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	KeyID         string `json:"keyId"` // Hex SHA-256 of the public key
+	AccountID     string `json:"accountId"`
+	PublicKey     string `json:"publicKey"` // PEM encoded
+	Status        string `json:"status"`    // Active, Revoked
+	AddedAt       int64  `json:"addedAt"`
+	RevokedAt     int64  `json:"revokedAt,omitempty" metadata:",optional"`
+}
+
+// Cheque is a payment authorised off-chain by its payer. The signature is an ASN.1 DER ECDSA
+// signature, base64 encoded, over the SHA-256 of
+// "cheque|<channelId>|<chaincodeName>|<payerId>|<payeeId>|<amount>|<nonce>|<expiresAt>", with
+// the amount in shortest decimal form. Each nonce can be used once per payer.
+type Cheque struct {
+	PayerID   string  `json:"payerId"`
+	PayeeID   string  `json:"payeeId"`
+	Amount    float64 `json:"amount"`
+	Nonce     string  `json:"nonce"`
+	ExpiresAt int64   `json:"expiresAt"`
+	KeyID     string  `json:"keyId"`
+	Signature string  `json:"signature"`
+}
+
+// ChequeRecord marks a payer's nonce as used by a settled or cancelled cheque
+type ChequeRecord struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	PayerID       string  `json:"payerId"`
+	Nonce         string  `json:"nonce"`
+	PayeeID       string  `json:"payeeId,omitempty" metadata:",optional"`
+	Amount        float64 `json:"amount,omitempty" metadata:",optional"`
+	Status        string  `json:"status"` // Settled, Cancelled
+	TxID          string  `json:"txId"`
+	SubmittedBy   string  `json:"submittedBy"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// RegisterChequeKey registers a PEM encoded ECDSA public key for signing the caller's cheques
+func (s *SmartContract) RegisterChequeKey(ctx contractapi.TransactionContextInterface, publicKey string) (*ChequeKey, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, keyID, err := s.parseECDSAPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := ctx.GetStub().GetState(s.getChequeKeyKey(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cheque key: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("cheque key %s is already registered", keyID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chequeKey := &ChequeKey{
+		DocType:   "chequeKey",
+		KeyID:     keyID,
+		AccountID: accountID,
+		PublicKey: publicKey,
+		Status:    "Active",
+		AddedAt:   now,
+	}
+
+	err = s.putChequeKey(ctx, chequeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return chequeKey, nil
+}
+
+// RevokeChequeKey stops a key of the caller's from signing cheques, including ones already issued
+func (s *SmartContract) RevokeChequeKey(ctx contractapi.TransactionContextInterface, keyID string) (*ChequeKey, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chequeKey, err := s.getChequeKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if chequeKey.AccountID != accountID {
+		return nil, fmt.Errorf("cheque key %s does not belong to the caller", keyID)
+	}
+	if chequeKey.Status != "Active" {
+		return nil, fmt.Errorf("cheque key %s is %s", keyID, chequeKey.Status)
+	}
+
+	chequeKey.Status = "Revoked"
+	chequeKey.RevokedAt, err = s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.putChequeKey(ctx, chequeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return chequeKey, nil
+}
+
+// RedeemCheque settles a signed cheque. It is submitted by the payee or by a commercial bank,
+// so custodial backends can settle without the payer's certificate.
+func (s *SmartContract) RedeemCheque(ctx contractapi.TransactionContextInterface, cheque Cheque) (*ChequeRecord, error) {
+	// Step 1: Check who is submitting
+	callerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if callerID != cheque.PayeeID && s.validateCallerIsCommercialBank(ctx) != nil {
+		return nil, fmt.Errorf("only the payee or a commercial bank can redeem a cheque")
+	}
+
+	// Step 2: Validate the cheque and its signature
+	err = s.validateCurrencyAmount(s.getDefaultCurrency(), cheque.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if cheque.Nonce == "" {
+		return nil, fmt.Errorf("cheque nonce is required")
+	}
+	if cheque.PayerID == cheque.PayeeID {
+		return nil, fmt.Errorf("cannot transfer to the same account")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cheque.ExpiresAt <= 0 {
+		return nil, fmt.Errorf("cheque expiry is required")
+	}
+	if now > cheque.ExpiresAt {
+		return nil, fmt.Errorf("cheque %s expired at %d", cheque.Nonce, cheque.ExpiresAt)
+	}
+
+	chequeKey, err := s.getChequeKey(ctx, cheque.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if chequeKey.AccountID != cheque.PayerID || chequeKey.Status != "Active" {
+		return nil, fmt.Errorf("cheque key %s is not an active key of %s", cheque.KeyID, cheque.PayerID)
+	}
+	publicKey, _, err := s.parseECDSAPublicKey(chequeKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := s.signingDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.verifyECDSASignature(publicKey, chequePayload(domain, &cheque), cheque.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("cheque %s: %v", cheque.Nonce, err)
+	}
+
+	// Step 3: Use the nonce
+	existing, err := s.getChequeRecord(ctx, cheque.PayerID, cheque.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("cheque %s of %s is already %s", cheque.Nonce, cheque.PayerID, existing.Status)
+	}
+
+	record := &ChequeRecord{
+		DocType:     "cheque",
+		PayerID:     cheque.PayerID,
+		Nonce:       cheque.Nonce,
+		PayeeID:     cheque.PayeeID,
+		Amount:      cheque.Amount,
+		Status:      "Settled",
+		TxID:        ctx.GetStub().GetTxID(),
+		SubmittedBy: callerID,
+		Timestamp:   now,
+	}
+	err = s.putChequeRecord(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 4: Settle as a transfer from the payer
+	err = s.moveFunds(ctx, cheque.PayerID, cheque.PayeeID, cheque.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := s.chargeUserFee(ctx, "Transfer", cheque.PayerID, cheque.PayeeID, cheque.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction := s.newTransaction(ctx, cheque.PayerID, cheque.PayeeID, cheque.Amount, "Cheque")
+	transaction.Reference = cheque.Nonce
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
+	}
+	err = s.putTransaction(ctx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Release any payments the payee has queued
+	err = s.releaseQueuedPayments(ctx, cheque.PayeeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// CancelCheque uses up one of the caller's nonces so a cheque issued with it cannot be redeemed
+func (s *SmartContract) CancelCheque(ctx contractapi.TransactionContextInterface, nonce string) (*ChequeRecord, error) {
+	payerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if nonce == "" {
+		return nil, fmt.Errorf("cheque nonce is required")
+	}
+
+	existing, err := s.getChequeRecord(ctx, payerID, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("cheque %s of %s is already %s", nonce, payerID, existing.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &ChequeRecord{
+		DocType:     "cheque",
+		PayerID:     payerID,
+		Nonce:       nonce,
+		Status:      "Cancelled",
+		TxID:        ctx.GetStub().GetTxID(),
+		SubmittedBy: payerID,
+		Timestamp:   now,
+	}
+	err = s.putChequeRecord(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// GetChequeKey returns a registered cheque key
+func (s *SmartContract) GetChequeKey(ctx contractapi.TransactionContextInterface, keyID string) (*ChequeKey, error) {
+	return s.getChequeKey(ctx, keyID)
+}
+
+// GetCheque returns the settled or cancelled cheque that used a payer's nonce
+func (s *SmartContract) GetCheque(ctx contractapi.TransactionContextInterface, payerID string, nonce string) (*ChequeRecord, error) {
+	record, err := s.getChequeRecord(ctx, payerID, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("no cheque of %s uses nonce %s", payerID, nonce)
+	}
+	return record, nil
+}
+
+// chequePayload is the message a payer signs for a cheque, bound to the deployment named by domain
+func chequePayload(domain string, cheque *Cheque) string {
+	return fmt.Sprintf("cheque|%s|%s|%s|%s|%s|%d", domain, cheque.PayerID, cheque.PayeeID,
+		strconv.FormatFloat(cheque.Amount, 'f', -1, 64), cheque.Nonce, cheque.ExpiresAt)
+}
+
+func (s *SmartContract) getChequeKeyKey(keyID string) string {
+	return "chequekey_" + keyID
+}
+
+func (s *SmartContract) getChequeRecordKey(payerID string, nonce string) string {
+	return "cheque_" + payerID + "|" + nonce
+}
+
+func (s *SmartContract) getChequeKey(ctx contractapi.TransactionContextInterface, keyID string) (*ChequeKey, error) {
+	keyBytes, err := ctx.GetStub().GetState(s.getChequeKeyKey(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cheque key: %v", err)
+	}
+	if keyBytes == nil {
+		return nil, fmt.Errorf("cheque key %s is not registered", keyID)
+	}
+
+	var chequeKey ChequeKey
+	err = s.decodeDocument("chequeKey", keyBytes, &chequeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cheque key: %v", err)
+	}
+
+	return &chequeKey, nil
+}
+
+func (s *SmartContract) putChequeKey(ctx contractapi.TransactionContextInterface, chequeKey *ChequeKey) error {
+	chequeKey.SchemaVersion = s.currentSchemaVersion("chequeKey")
+	keyJSON, err := json.Marshal(chequeKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cheque key: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getChequeKeyKey(chequeKey.KeyID), keyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put cheque key state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getChequeRecord(ctx contractapi.TransactionContextInterface, payerID string, nonce string) (*ChequeRecord, error) {
+	recordBytes, err := ctx.GetStub().GetState(s.getChequeRecordKey(payerID, nonce))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cheque: %v", err)
+	}
+	if recordBytes == nil {
+		return nil, nil
+	}
+
+	var record ChequeRecord
+	err = s.decodeDocument("cheque", recordBytes, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cheque: %v", err)
+	}
+
+	return &record, nil
+}
+
+func (s *SmartContract) putChequeRecord(ctx contractapi.TransactionContextInterface, record *ChequeRecord) error {
+	record.SchemaVersion = s.currentSchemaVersion("cheque")
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cheque: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getChequeRecordKey(record.PayerID, record.Nonce), recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put cheque state: %v", err)
+	}
+	return nil
+}
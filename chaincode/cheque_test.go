@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+// signCheque returns cheque signed by the device's key for the test deployment
+func (d *testDevice) signCheque(cheque Cheque) Cheque {
+	return d.signChequeFor(testSigningDomain, cheque)
+}
+
+// signChequeFor returns cheque signed by the device's key for the deployment named by domain
+func (d *testDevice) signChequeFor(domain string, cheque Cheque) Cheque {
+	digest := sha256.Sum256([]byte(chequePayload(domain, &cheque)))
+	signature, err := ecdsa.SignASN1(rand.Reader, d.key, digest[:])
+	if err != nil {
+		d.t.Fatalf("failed to sign cheque: %v", err)
+	}
+	cheque.Signature = base64.StdEncoding.EncodeToString(signature)
+	return cheque
+}
+
+func TestRedeemCheque(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var key ChequeKey
+	l.asUser("alice").mustQuery(&key, "RegisterChequeKey", device.publicPEM)
+	l.mustFailWith("already registered", "RegisterChequeKey", device.publicPEM)
+
+	cheque := device.signCheque(Cheque{PayerID: "alice", PayeeID: "shop", Amount: 25, Nonce: "0001", ExpiresAt: testStartTime + 3600, KeyID: key.KeyID})
+	l.asUser("mallory").mustFailWith("only the payee or a commercial bank", "RedeemCheque", cheque)
+	altered := cheque
+	altered.Amount = 90
+	l.asUser("shop").mustFailWith("signature does not verify", "RedeemCheque", altered)
+
+	var record ChequeRecord
+	l.mustQuery(&record, "RedeemCheque", cheque)
+	if record.Status != "Settled" || record.SubmittedBy != "shop" {
+		t.Fatalf("unexpected cheque record %+v", record)
+	}
+	l.expectBalance("alice", 75)
+	l.expectBalance("shop", 25)
+
+	// Each nonce pays once, whoever submits it
+	l.asBank("bank1").mustFailWith("cheque 0001 of alice is already Settled", "RedeemCheque", cheque)
+}
+
+func TestChequeCancelRevokeAndExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var key ChequeKey
+	l.asUser("alice").mustQuery(&key, "RegisterChequeKey", device.publicPEM)
+	cancelled := device.signCheque(Cheque{PayerID: "alice", PayeeID: "shop", Amount: 10, Nonce: "0001", ExpiresAt: testStartTime + 3600, KeyID: key.KeyID})
+	expiring := device.signCheque(Cheque{PayerID: "alice", PayeeID: "shop", Amount: 10, Nonce: "0002", ExpiresAt: testStartTime + 60, KeyID: key.KeyID})
+	revoked := device.signCheque(Cheque{PayerID: "alice", PayeeID: "shop", Amount: 10, Nonce: "0003", ExpiresAt: testStartTime + 3600, KeyID: key.KeyID})
+
+	l.mustInvoke("CancelCheque", "0001")
+	l.asUser("shop").mustFailWith("already Cancelled", "RedeemCheque", cancelled)
+
+	l.now += 120
+	l.mustFailWith("expired at", "RedeemCheque", expiring)
+
+	l.asUser("bob").mustFailWith("does not belong to the caller", "RevokeChequeKey", key.KeyID)
+	l.asUser("alice").mustInvoke("RevokeChequeKey", key.KeyID)
+	l.asUser("shop").mustFailWith("is not an active key of alice", "RedeemCheque", revoked)
+	l.expectBalance("alice", 100)
+}
+
+func TestChequePaysPayeeBankFee(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "shop", 10)
+	l.fund("bank2", "alice", 100)
+	l.link("bank1", "shop")
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payee", "bank1")
+	device := newTestDevice(t)
+
+	var key ChequeKey
+	l.asUser("alice").mustQuery(&key, "RegisterChequeKey", device.publicPEM)
+	cheque := device.signCheque(Cheque{PayerID: "alice", PayeeID: "shop", Amount: 20, Nonce: "0001", ExpiresAt: testStartTime + 3600, KeyID: key.KeyID})
+	l.asBank("bank1").mustInvoke("RedeemCheque", cheque)
+
+	l.expectBalance("alice", 80)
+	l.expectBalance("shop", 29)
+	l.expectBalance("bank1", 1)
+}
+
+func TestChequeIsBoundToTheDeployment(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var key ChequeKey
+	l.asUser("alice").mustQuery(&key, "RegisterChequeKey", device.publicPEM)
+
+	// A cheque signed for another channel or chaincode does not verify here
+	unsigned := Cheque{PayerID: "alice", PayeeID: "shop", Amount: 25, Nonce: "0001", ExpiresAt: testStartTime + 3600, KeyID: key.KeyID}
+	for _, domain := range []string{"otherchannel|" + testChaincodeName, testChannelID + "|othercbdc"} {
+		l.asUser("shop").mustFailWith("signature does not verify", "RedeemCheque", device.signChequeFor(domain, unsigned))
+	}
+
+	cheque := device.signCheque(unsigned)
+	l.chaincodeName = "othercbdc"
+	l.asUser("shop").mustFailWith("signature does not verify", "RedeemCheque", cheque)
+	l.chaincodeName = testChaincodeName
+
+	l.mustInvoke("RedeemCheque", cheque)
+	l.expectBalance("shop", 25)
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// CreditLine is a commercial bank's collateralised intraday credit facility with the central bank
+type CreditLine struct {
+	DocType              string  `json:"docType"`
+	SchemaVersion        int     `json:"schemaVersion"`
+	BankID               string  `json:"bankId"`
+	Limit                float64 `json:"limit"`
+	Drawn                float64 `json:"drawn"`                // Intraday credit outstanding
+	OvernightOutstanding float64 `json:"overnightOutstanding"` // Principal of unpaid overnight loans
+	CollateralValue      float64 `json:"collateralValue"`      // Lending value of pledged collateral
+	OvernightRate        float64 `json:"overnightRate"`        // Annual rate charged on overnight loans
+	ModifiedAt           int64   `json:"modifiedAt"`
+}
+
+// CollateralPledge is an asset pledged by a commercial bank to back its credit line
+type CollateralPledge struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	ID            string  `json:"id"`
+	BankID        string  `json:"bankId"`
+	AssetRef      string  `json:"assetRef"` // e.g. an ISIN or custodian reference
+	MarketValue   float64 `json:"marketValue"`
+	Haircut       float64 `json:"haircut"` // Fraction deducted from the market value, e.g. 0.1
+	LendingValue  float64 `json:"lendingValue"`
+	Status        string  `json:"status"` // Pledged, Released
+	PledgedAt     int64   `json:"pledgedAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// CreditDrawdown records an automatic drawdown on a credit line
+type CreditDrawdown struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	BankID        string  `json:"bankId"`
+	TxID          string  `json:"txId"`
+	Amount        float64 `json:"amount"`
+	DrawnAt       int64   `json:"drawnAt"`
+}
+
+// OvernightLoan is intraday credit that was not repaid by the end of the day
+type OvernightLoan struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	ID            string  `json:"id"`
+	BankID        string  `json:"bankId"`
+	Principal     float64 `json:"principal"`
+	Rate          float64 `json:"rate"`
+	StartedAt     int64   `json:"startedAt"`
+	Interest      float64 `json:"interest"`
+	Status        string  `json:"status"` // Open, Repaid
+	RepaidAt      int64   `json:"repaidAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// CreditExposure is a bank's credit line together with its collateral and overnight loans
+type CreditExposure struct {
+	Line          *CreditLine         `json:"line"`
+	Collateral    []*CollateralPledge `json:"collateral"`
+	Loans         []*OvernightLoan    `json:"loans"`
+	TotalExposure float64             `json:"totalExposure"`
+	Headroom      float64             `json:"headroom"`
+}
+
+// EndOfDayResult reports the outcome of closing intraday credit
+type EndOfDayResult struct {
+	Repaid    float64  `json:"repaid"`
+	Converted float64  `json:"converted"`
+	Loans     []string `json:"loans"`
+}
+
+// PledgeCollateral records collateral pledged by a commercial bank (Central Bank only)
+func (s *SmartContract) PledgeCollateral(ctx contractapi.TransactionContextInterface, bankID string, assetRef string, marketValue float64, haircut float64) (*CollateralPledge, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can record collateral: %v", err)
+	}
+
+	err = s.validateCommercialBank(ctx, bankID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commercial bank ID: %v", err)
+	}
+	if assetRef == "" {
+		return nil, fmt.Errorf("asset reference is required")
+	}
+	if marketValue <= 0 {
+		return nil, fmt.Errorf("market value must be positive")
+	}
+	if haircut < 0 || haircut >= 1 {
+		return nil, fmt.Errorf("haircut must be at least 0 and below 1")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pledge := &CollateralPledge{
+		DocType:      "collateralPledge",
+		ID:           ctx.GetStub().GetTxID(),
+		BankID:       bankID,
+		AssetRef:     assetRef,
+		MarketValue:  marketValue,
+		Haircut:      haircut,
+		LendingValue: marketValue * (1 - haircut),
+		Status:       "Pledged",
+		PledgedAt:    now,
+		ModifiedAt:   time.Now().Unix(),
+	}
+
+	err = s.putCollateralPledge(ctx, pledge)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := s.getOrNewCreditLine(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+	line.CollateralValue += pledge.LendingValue
+	line.ModifiedAt = time.Now().Unix()
+
+	err = s.putCreditLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return pledge, nil
+}
+
+// ReleaseCollateral returns pledged collateral to the bank, provided the remaining collateral
+// still covers the credit limit (Central Bank only)
+func (s *SmartContract) ReleaseCollateral(ctx contractapi.TransactionContextInterface, bankID string, pledgeID string) error {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return fmt.Errorf("only central bank can release collateral: %v", err)
+	}
+
+	pledge, err := s.getCollateralPledge(ctx, bankID, pledgeID)
+	if err != nil {
+		return err
+	}
+	if pledge.Status != "Pledged" {
+		return fmt.Errorf("collateral %s is %s", pledgeID, pledge.Status)
+	}
+
+	line, err := s.getOrNewCreditLine(ctx, bankID)
+	if err != nil {
+		return err
+	}
+	if line.CollateralValue-pledge.LendingValue < line.Limit {
+		return fmt.Errorf("releasing collateral %s would leave the credit limit of %.2f uncovered; lower the limit first", pledgeID, line.Limit)
+	}
+
+	pledge.Status = "Released"
+	pledge.ModifiedAt = time.Now().Unix()
+	err = s.putCollateralPledge(ctx, pledge)
+	if err != nil {
+		return err
+	}
+
+	line.CollateralValue -= pledge.LendingValue
+	line.ModifiedAt = time.Now().Unix()
+	return s.putCreditLine(ctx, line)
+}
+
+// SetIntradayCreditLimit grants or changes a bank's intraday credit limit and overnight rate
+// (Central Bank only). The limit cannot exceed the lending value of the pledged collateral
+// nor fall below the bank's current exposure.
+func (s *SmartContract) SetIntradayCreditLimit(ctx contractapi.TransactionContextInterface, bankID string, limit float64, overnightRate float64) (*CreditLine, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can set credit limits: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetIntradayCreditLimit")
+	if err != nil {
+		return nil, err
+	}
+
+	if limit < 0 || overnightRate < 0 {
+		return nil, fmt.Errorf("limit and overnight rate must not be negative")
+	}
+
+	line, err := s.getOrNewCreditLine(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+	if limit > line.CollateralValue {
+		return nil, fmt.Errorf("limit exceeds the lending value of pledged collateral (%.2f)", line.CollateralValue)
+	}
+	if limit < line.Drawn+line.OvernightOutstanding {
+		return nil, fmt.Errorf("limit is below the current exposure of %.2f", line.Drawn+line.OvernightOutstanding)
+	}
+
+	line.Limit = limit
+	line.OvernightRate = overnightRate
+	line.ModifiedAt = time.Now().Unix()
+
+	err = s.putCreditLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// RepayIntradayCredit repays intraday credit early from the calling bank's balance
+func (s *SmartContract) RepayIntradayCredit(ctx contractapi.TransactionContextInterface, amount float64) (*CreditLine, error) {
+	bankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := s.getCreditLine(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+	if line == nil || line.Drawn <= 0 {
+		return nil, fmt.Errorf("no intraday credit outstanding for %s", bankID)
+	}
+	if amount <= 0 || amount > line.Drawn {
+		return nil, fmt.Errorf("amount must be positive and at most the %.2f outstanding", line.Drawn)
+	}
+
+	// Repay from the balance itself; moveFunds would draw on the same credit line
+	err = s.repayCentralBank(ctx, bankID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	line.Drawn -= amount
+	line.ModifiedAt = time.Now().Unix()
+
+	err = s.putCreditLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// CloseIntradayCredit runs the end-of-day repayment for every credit line (Central Bank only).
+// Outstanding intraday credit is repaid from the bank's balance; whatever the balance cannot
+// cover is converted into an overnight loan at the line's overnight rate.
+func (s *SmartContract) CloseIntradayCredit(ctx contractapi.TransactionContextInterface) (*EndOfDayResult, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can close intraday credit: %v", err)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("creditline_", "creditline`")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credit lines: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	lines := []*CreditLine{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next credit line: %v", err)
+		}
+
+		var line CreditLine
+		err = s.decodeDocument("creditLine", queryResult.Value, &line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal credit line: %v", err)
+		}
+		lines = append(lines, &line)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EndOfDayResult{Loans: []string{}}
+	for _, line := range lines {
+		if line.Drawn <= 0 {
+			continue
+		}
+
+		balance, err := s.getAccountBalance(ctx, line.BankID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get balance of %s: %v", line.BankID, err)
+		}
+
+		repay := math.Min(balance.Balance, line.Drawn)
+		if repay > 0 {
+			err = s.repayCentralBank(ctx, line.BankID, repay)
+			if err != nil {
+				return nil, err
+			}
+			result.Repaid += repay
+		}
+
+		shortfall := line.Drawn - repay
+		if shortfall > 0 {
+			loan := &OvernightLoan{
+				DocType:    "overnightLoan",
+				ID:         ctx.GetStub().GetTxID() + "_" + line.BankID,
+				BankID:     line.BankID,
+				Principal:  shortfall,
+				Rate:       line.OvernightRate,
+				StartedAt:  now,
+				Status:     "Open",
+				ModifiedAt: time.Now().Unix(),
+			}
+			err = s.putOvernightLoan(ctx, loan)
+			if err != nil {
+				return nil, err
+			}
+
+			line.OvernightOutstanding += shortfall
+			result.Converted += shortfall
+			result.Loans = append(result.Loans, loan.ID)
+		}
+
+		line.Drawn = 0
+		line.ModifiedAt = time.Now().Unix()
+		err = s.putCreditLine(ctx, line)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// RepayOvernightLoan repays an overnight loan with interest from the calling bank's balance.
+// Interest accrues on the elapsed time, with a minimum of one day.
+func (s *SmartContract) RepayOvernightLoan(ctx contractapi.TransactionContextInterface, loanID string) (*OvernightLoan, error) {
+	bankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	loan, err := s.getOvernightLoan(ctx, bankID, loanID)
+	if err != nil {
+		return nil, err
+	}
+	if loan.Status != "Open" {
+		return nil, fmt.Errorf("overnight loan %s is %s", loanID, loan.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := now - loan.StartedAt
+	if elapsed < secondsPerDay {
+		elapsed = secondsPerDay
+	}
+	loan.Interest = loan.Principal * loan.Rate * float64(elapsed) / secondsPerYear
+
+	err = s.repayCentralBank(ctx, bankID, loan.Principal+loan.Interest)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Status = "Repaid"
+	loan.RepaidAt = now
+	loan.ModifiedAt = time.Now().Unix()
+	err = s.putOvernightLoan(ctx, loan)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := s.getOrNewCreditLine(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+	line.OvernightOutstanding -= loan.Principal
+	line.ModifiedAt = time.Now().Unix()
+	err = s.putCreditLine(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return loan, nil
+}
+
+// GetCreditExposure returns a bank's credit line, collateral and overnight loans
+func (s *SmartContract) GetCreditExposure(ctx contractapi.TransactionContextInterface, bankID string) (*CreditExposure, error) {
+	line, err := s.getOrNewCreditLine(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+
+	exposure := &CreditExposure{
+		Line:          line,
+		Collateral:    []*CollateralPledge{},
+		Loans:         []*OvernightLoan{},
+		TotalExposure: line.Drawn + line.OvernightOutstanding,
+		Headroom:      s.creditHeadroom(line),
+	}
+
+	collateralIterator, err := ctx.GetStub().GetStateByRange(s.getCollateralKey(bankID, ""), s.getCollateralKey(bankID, "~"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get collateral: %v", err)
+	}
+	defer collateralIterator.Close()
+	for collateralIterator.HasNext() {
+		queryResult, err := collateralIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next collateral: %v", err)
+		}
+		var pledge CollateralPledge
+		err = s.decodeDocument("collateralPledge", queryResult.Value, &pledge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal collateral: %v", err)
+		}
+		exposure.Collateral = append(exposure.Collateral, &pledge)
+	}
+
+	loanIterator, err := ctx.GetStub().GetStateByRange(s.getOvernightLoanKey(bankID, ""), s.getOvernightLoanKey(bankID, "~"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get overnight loans: %v", err)
+	}
+	defer loanIterator.Close()
+	for loanIterator.HasNext() {
+		queryResult, err := loanIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next overnight loan: %v", err)
+		}
+		var loan OvernightLoan
+		err = s.decodeDocument("overnightLoan", queryResult.Value, &loan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal overnight loan: %v", err)
+		}
+		exposure.Loans = append(exposure.Loans, &loan)
+	}
+
+	return exposure, nil
+}
+
+// drawIntradayCredit tops up a bank's balance from its credit line so that a debit of amount
+// can go through. It does nothing if the balance already covers the debit or the shortfall
+// exceeds the headroom, leaving the caller to fail as before.
+func (s *SmartContract) drawIntradayCredit(ctx contractapi.TransactionContextInterface, accountID string, amount float64) error {
+	line, err := s.getCreditLine(ctx, accountID)
+	if err != nil || line == nil {
+		return err
+	}
+
+	balance, err := s.getAccountBalance(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", accountID, err)
+	}
+	shortfall := amount - balance.Balance
+	if shortfall <= 0 || shortfall > s.creditHeadroom(line) {
+		return nil
+	}
+
+	err = s.changeBalance(ctx, s.getCentralBankID(), -shortfall)
+	if err != nil {
+		return fmt.Errorf("central bank cannot fund intraday credit: %v", err)
+	}
+	err = s.changeBalance(ctx, accountID, shortfall)
+	if err != nil {
+		return err
+	}
+
+	line.Drawn += shortfall
+	line.ModifiedAt = time.Now().Unix()
+	err = s.putCreditLine(ctx, line)
+	if err != nil {
+		return err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A transaction can draw more than once, so its drawdowns accumulate under one key
+	key := "creditdraw_" + accountID + "|" + ctx.GetStub().GetTxID()
+	drawdownBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read credit drawdown: %v", err)
+	}
+
+	drawdown := CreditDrawdown{
+		DocType: "creditDrawdown",
+		BankID:  accountID,
+		TxID:    ctx.GetStub().GetTxID(),
+		DrawnAt: now,
+	}
+	if drawdownBytes != nil {
+		err = s.decodeDocument("creditDrawdown", drawdownBytes, &drawdown)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal credit drawdown: %v", err)
+		}
+	}
+	drawdown.Amount += shortfall
+
+	drawdown.SchemaVersion = s.currentSchemaVersion("creditDrawdown")
+	drawdownJSON, err := json.Marshal(drawdown)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credit drawdown: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, drawdownJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put credit drawdown state: %v", err)
+	}
+
+	return nil
+}
+
+// repayCentralBank moves amount from a bank's own balance to the central bank without drawing credit
+func (s *SmartContract) repayCentralBank(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	err := s.changeBalance(ctx, bankID, -amount)
+	if err != nil {
+		return err
+	}
+	return s.changeBalance(ctx, s.getCentralBankID(), amount)
+}
+
+// availableCredit returns how much more an account could draw on its credit line
+func (s *SmartContract) availableCredit(ctx contractapi.TransactionContextInterface, accountID string) (float64, error) {
+	line, err := s.getCreditLine(ctx, accountID)
+	if err != nil || line == nil {
+		return 0, err
+	}
+	return s.creditHeadroom(line), nil
+}
+
+func (s *SmartContract) creditHeadroom(line *CreditLine) float64 {
+	return math.Max(line.Limit-line.Drawn-line.OvernightOutstanding, 0)
+}
+
+func (s *SmartContract) getCreditLineKey(bankID string) string {
+	return "creditline_" + bankID
+}
+
+func (s *SmartContract) getCollateralKey(bankID string, pledgeID string) string {
+	return "collateral_" + bankID + "|" + pledgeID
+}
+
+func (s *SmartContract) getOvernightLoanKey(bankID string, loanID string) string {
+	return "onloan_" + bankID + "|" + loanID
+}
+
+// getCreditLine returns nil when the bank has no credit line
+func (s *SmartContract) getCreditLine(ctx contractapi.TransactionContextInterface, bankID string) (*CreditLine, error) {
+	lineBytes, err := ctx.GetStub().GetState(s.getCreditLineKey(bankID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credit line: %v", err)
+	}
+	if lineBytes == nil {
+		return nil, nil
+	}
+
+	var line CreditLine
+	err = s.decodeDocument("creditLine", lineBytes, &line)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credit line: %v", err)
+	}
+
+	return &line, nil
+}
+
+func (s *SmartContract) getOrNewCreditLine(ctx contractapi.TransactionContextInterface, bankID string) (*CreditLine, error) {
+	line, err := s.getCreditLine(ctx, bankID)
+	if err != nil || line != nil {
+		return line, err
+	}
+	return &CreditLine{DocType: "creditLine", BankID: bankID}, nil
+}
+
+func (s *SmartContract) putCreditLine(ctx contractapi.TransactionContextInterface, line *CreditLine) error {
+	line.SchemaVersion = s.currentSchemaVersion("creditLine")
+	lineJSON, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credit line: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getCreditLineKey(line.BankID), lineJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put credit line state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getCollateralPledge(ctx contractapi.TransactionContextInterface, bankID string, pledgeID string) (*CollateralPledge, error) {
+	pledgeBytes, err := ctx.GetStub().GetState(s.getCollateralKey(bankID, pledgeID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collateral: %v", err)
+	}
+	if pledgeBytes == nil {
+		return nil, fmt.Errorf("collateral %s of %s does not exist", pledgeID, bankID)
+	}
+
+	var pledge CollateralPledge
+	err = s.decodeDocument("collateralPledge", pledgeBytes, &pledge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collateral: %v", err)
+	}
+
+	return &pledge, nil
+}
+
+func (s *SmartContract) putCollateralPledge(ctx contractapi.TransactionContextInterface, pledge *CollateralPledge) error {
+	pledge.SchemaVersion = s.currentSchemaVersion("collateralPledge")
+	pledgeJSON, err := json.Marshal(pledge)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collateral: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getCollateralKey(pledge.BankID, pledge.ID), pledgeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put collateral state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getOvernightLoan(ctx contractapi.TransactionContextInterface, bankID string, loanID string) (*OvernightLoan, error) {
+	loanBytes, err := ctx.GetStub().GetState(s.getOvernightLoanKey(bankID, loanID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overnight loan: %v", err)
+	}
+	if loanBytes == nil {
+		return nil, fmt.Errorf("overnight loan %s of %s does not exist", loanID, bankID)
+	}
+
+	var loan OvernightLoan
+	err = s.decodeDocument("overnightLoan", loanBytes, &loan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal overnight loan: %v", err)
+	}
+
+	return &loan, nil
+}
+
+func (s *SmartContract) putOvernightLoan(ctx contractapi.TransactionContextInterface, loan *OvernightLoan) error {
+	loan.SchemaVersion = s.currentSchemaVersion("overnightLoan")
+	loanJSON, err := json.Marshal(loan)
+	if err != nil {
+		return fmt.Errorf("failed to marshal overnight loan: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getOvernightLoanKey(loan.BankID, loan.ID), loanJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put overnight loan state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestIntradayCreditDrawdownAndOvernightLoan(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 1000.0)
+	l.mustInvoke("TransferToCB", "bank1", 20.0)
+
+	l.asBank("bank1").mustFailWith("only central bank", "PledgeCollateral", "bank1", "XS0001", 200.0, 0.25)
+	var pledge CollateralPledge
+	l.asCentralBank().mustQuery(&pledge, "PledgeCollateral", "bank1", "XS0001", 200.0, 0.25)
+	if pledge.LendingValue != 150 {
+		t.Fatalf("unexpected lending value %.2f", pledge.LendingValue)
+	}
+	l.mustFailWith("exceeds the lending value", "SetIntradayCreditLimit", "bank1", 200.0, 0.365)
+	l.mustInvoke("SetIntradayCreditLimit", "bank1", 100.0, 0.365)
+	l.mustFailWith("would leave the credit limit", "ReleaseCollateral", "bank1", pledge.ID)
+
+	// The bank pays out more than it holds and the shortfall is drawn on its line
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 70.0)
+	l.expectBalance("alice", 70)
+	l.expectBalance("bank1", 0)
+
+	var exposure CreditExposure
+	l.mustQuery(&exposure, "GetCreditExposure", "bank1")
+	if exposure.Line.Drawn != 50 || exposure.Headroom != 50 || len(exposure.Collateral) != 1 {
+		t.Fatalf("unexpected exposure after drawdown %+v", exposure)
+	}
+
+	l.asCentralBank().mustInvoke("TransferToCB", "bank1", 10.0)
+	l.asBank("bank1").mustFailWith("at most the 50.00 outstanding", "RepayIntradayCredit", 60.0)
+	l.mustInvoke("RepayIntradayCredit", 10.0)
+
+	// Whatever the bank cannot repay at the end of the day becomes an overnight loan
+	var result EndOfDayResult
+	l.asCentralBank().mustQuery(&result, "CloseIntradayCredit")
+	if result.Repaid != 0 || result.Converted != 40 || len(result.Loans) != 1 {
+		t.Fatalf("unexpected end of day result %+v", result)
+	}
+
+	l.now += secondsPerDay
+	l.mustInvoke("TransferToCB", "bank1", 50.0)
+	var loan OvernightLoan
+	l.asBank("bank1").mustQuery(&loan, "RepayOvernightLoan", result.Loans[0])
+	if loan.Status != "Repaid" || math.Abs(loan.Interest-0.04) > 1e-9 {
+		t.Fatalf("unexpected repaid loan %+v", loan)
+	}
+	l.mustFailWith("is Repaid", "RepayOvernightLoan", result.Loans[0])
+
+	l.mustQuery(&exposure, "GetCreditExposure", "bank1")
+	if exposure.TotalExposure != 0 || exposure.Headroom != 100 {
+		t.Fatalf("unexpected exposure after repayment %+v", exposure)
+	}
+
+	l.asCentralBank().mustInvoke("SetIntradayCreditLimit", "bank1", 0.0, 0.0)
+	l.mustInvoke("ReleaseCollateral", "bank1", pledge.ID)
+	l.mustFailWith("is Released", "ReleaseCollateral", "bank1", pledge.ID)
+}
+
+func TestQueuedPaymentUsesCreditHeadroom(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 1000.0)
+	l.mustInvoke("PledgeCollateral", "bank1", "XS0002", 100.0, 0.0)
+	l.mustInvoke("SetIntradayCreditLimit", "bank1", 100.0, 0.0)
+
+	var payment QueuedPayment
+	l.asBank("bank1").mustQuery(&payment, "QueuePayment", "CommercialToUser", "alice", 60.0, "Normal")
+	if payment.Status != "Settled" {
+		t.Fatalf("payment covered by credit headroom is %s", payment.Status)
+	}
+	l.expectBalance("alice", 60)
+}
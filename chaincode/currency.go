@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// defaultCurrency is the ledger's original currency, issued by the central bank in Org1
+const defaultCurrency = "CBDC"
+
+// defaultNumericCode is the ISO 4217 numeric code of the default currency until one is registered
+const defaultNumericCode = "999"
+
+// Currency is a registered currency and the central bank that issues it
+type Currency struct {
+	DocType         string `json:"docType"`
+	SchemaVersion   int    `json:"schemaVersion"`
+	Code            string `json:"code"`
+	Name            string `json:"name"`
+	IssuerMSP       string `json:"issuerMsp"`
+	IssuerAccountID string `json:"issuerAccountId"`
+	Decimals        int    `json:"decimals"`
+	NumericCode     string `json:"numericCode"` // ISO 4217 style code used in QR payloads
+	Status          string `json:"status"`      // Active, Suspended, Retired
+	CreatedAt       int64  `json:"createdAt"`
+	ModifiedAt      int64  `json:"modifiedAt"`
+}
+
+// RegisterCurrency adds a currency to the registry (central bank administrators only)
+func (s *SmartContract) RegisterCurrency(ctx contractapi.TransactionContextInterface, code string, name string, issuerMSP string, issuerAccountID string, decimals int, numericCode string) (*Currency, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can register currencies: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "RegisterCurrency")
+	if err != nil {
+		return nil, err
+	}
+
+	if !isValidCurrencyCode(code) {
+		return nil, fmt.Errorf("currency code must be 3-12 uppercase letters or digits")
+	}
+	if issuerMSP == "" || issuerAccountID == "" {
+		return nil, fmt.Errorf("issuer MSP and issuer account are required")
+	}
+	if decimals < 0 || decimals > 8 {
+		return nil, fmt.Errorf("decimals must be between 0 and 8")
+	}
+
+	existing, err := ctx.GetStub().GetState(s.getCurrencyKey(code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read currency: %v", err)
+	}
+	if existing != nil || code == defaultCurrency {
+		return nil, fmt.Errorf("currency %s is already registered", code)
+	}
+
+	currency := &Currency{
+		DocType:         "currency",
+		Code:            code,
+		Name:            name,
+		IssuerMSP:       issuerMSP,
+		IssuerAccountID: issuerAccountID,
+		Decimals:        decimals,
+		NumericCode:     numericCode,
+		Status:          "Active",
+		CreatedAt:       time.Now().Unix(),
+		ModifiedAt:      time.Now().Unix(),
+	}
+
+	err = s.putCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return currency, nil
+}
+
+// SetCurrencyStatus activates, suspends or retires a currency (central bank administrators, under
+// approval, or the currency's issuer)
+func (s *SmartContract) SetCurrencyStatus(ctx contractapi.TransactionContextInterface, code string, status string) error {
+	currency, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if s.validateCentralBankAdmin(ctx) == nil {
+		err = s.requireApproval(ctx, "SetCurrencyStatus")
+		if err != nil {
+			return err
+		}
+	} else if s.validateCurrencyIssuer(ctx, currency) != nil {
+		return fmt.Errorf("only the central bank or the issuer of %s can change its status", code)
+	}
+
+	switch status {
+	case "Active", "Suspended", "Retired":
+	default:
+		return fmt.Errorf("unknown currency status %s", status)
+	}
+
+	currency.Status = status
+	currency.ModifiedAt = time.Now().Unix()
+
+	return s.putCurrency(ctx, currency)
+}
+
+// GetCurrency returns a registered currency
+func (s *SmartContract) GetCurrency(ctx contractapi.TransactionContextInterface, code string) (*Currency, error) {
+	return s.getCurrency(ctx, code)
+}
+
+// ListCurrencies returns every registered currency, including the default one
+func (s *SmartContract) ListCurrencies(ctx contractapi.TransactionContextInterface) ([]*Currency, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(s.getCurrencyKey(""), "currency`")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get currencies: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	currencies := []*Currency{}
+	hasDefault := false
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next currency: %v", err)
+		}
+
+		var currency Currency
+		err = s.decodeDocument("currency", queryResult.Value, &currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal currency: %v", err)
+		}
+		hasDefault = hasDefault || currency.Code == defaultCurrency
+		currencies = append(currencies, &currency)
+	}
+
+	if !hasDefault {
+		currencies = append([]*Currency{s.getDefaultCurrency()}, currencies...)
+	}
+
+	return currencies, nil
+}
+
+// IssueCurrency mints tokens of a currency into its issuer's account (the currency's issuer only).
+// Currencies the central bank issues itself need the same approvals as IssueTokens; the
+// default currency is issued with IssueTokens.
+func (s *SmartContract) IssueCurrency(ctx contractapi.TransactionContextInterface, code string, amount float64) error {
+	if code == defaultCurrency {
+		return fmt.Errorf("%s is issued with IssueTokens", defaultCurrency)
+	}
+
+	currency, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	err = s.validateCurrencyIssuer(ctx, currency)
+	if err != nil {
+		return fmt.Errorf("only the issuer of %s can issue it: %v", code, err)
+	}
+	if currency.IssuerAccountID == s.getCentralBankID() {
+		err = s.requireApproval(ctx, "IssueCurrency")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.validateCurrencyAmount(currency, amount)
+	if err != nil {
+		return err
+	}
+
+	return s.issueCurrency(ctx, currency, amount)
+}
+
+// DistributeCurrency transfers a currency from its issuer's account to a commercial bank (the currency's issuer only).
+// The default currency is distributed with TransferToCB, under its approvals.
+func (s *SmartContract) DistributeCurrency(ctx contractapi.TransactionContextInterface, code string, commercialBankID string, amount float64) error {
+	if code == defaultCurrency {
+		return fmt.Errorf("%s is distributed with TransferToCB", defaultCurrency)
+	}
+
+	currency, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	err = s.validateCurrencyIssuer(ctx, currency)
+	if err != nil {
+		return fmt.Errorf("only the issuer of %s can distribute it: %v", code, err)
+	}
+
+	err = s.validateCommercialBank(ctx, commercialBankID)
+	if err != nil {
+		return fmt.Errorf("invalid commercial bank ID: %v", err)
+	}
+
+	return s.transferCurrency(ctx, currency, currency.IssuerAccountID, commercialBankID, amount, "CBToCommercial")
+}
+
+// TransferCurrency transfers tokens of a currency from the caller's account to another account.
+// The default currency moves with TransferTokens, which applies its fees and limits.
+func (s *SmartContract) TransferCurrency(ctx contractapi.TransactionContextInterface, fromID string, toID string, code string, amount float64) error {
+	if code == defaultCurrency {
+		return fmt.Errorf("%s is transferred with TransferTokens", defaultCurrency)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != fromID {
+		return fmt.Errorf("caller not authorized to transfer from this account")
+	}
+
+	currency, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	return s.transferCurrency(ctx, currency, fromID, toID, amount, "Transfer")
+}
+
+// RedeemCurrency burns tokens of a currency from the caller's account. The default currency is
+// redeemed with RedeemTokens.
+func (s *SmartContract) RedeemCurrency(ctx contractapi.TransactionContextInterface, accountID string, code string, amount float64) error {
+	if code == defaultCurrency {
+		return fmt.Errorf("%s is redeemed with RedeemTokens", defaultCurrency)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != accountID {
+		return fmt.Errorf("caller not authorized to redeem from this account")
+	}
+
+	currency, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return err
+	}
+	if currency.Status == "Suspended" {
+		return fmt.Errorf("currency %s is Suspended", code)
+	}
+
+	err = s.validateCurrencyAmount(currency, amount)
+	if err != nil {
+		return err
+	}
+
+	balance, err := s.getCurrencyBalance(ctx, accountID, code)
+	if err != nil {
+		return fmt.Errorf("failed to get account balance: %v", err)
+	}
+	if balance.Balance < amount {
+		return fmt.Errorf("insufficient funds")
+	}
+
+	balance.Balance -= amount
+	balance.ModifiedAt = time.Now().Unix()
+
+	err = s.putAccountBalance(ctx, balance)
+	if err != nil {
+		return err
+	}
+
+	err = s.updateCurrencySupply(ctx, code, func(supply *SupplyRecord) {
+		supply.TotalRedeemed += amount
+	})
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, accountID, currency.IssuerAccountID, amount, "Redeem")
+	transaction.Currency = code
+	return s.putTransaction(ctx, transaction)
+}
+
+// GetCurrencyBalance returns the balance of an account in a currency
+func (s *SmartContract) GetCurrencyBalance(ctx contractapi.TransactionContextInterface, accountID string, code string) (*AccountBalance, error) {
+	_, err := s.getCurrency(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.getCurrencyBalance(ctx, accountID, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance: %v", err)
+	}
+	return balance, nil
+}
+
+func (s *SmartContract) transferCurrency(ctx contractapi.TransactionContextInterface, currency *Currency, fromID string, toID string, amount float64, txType string) error {
+	if currency.Status != "Active" {
+		return fmt.Errorf("currency %s is %s", currency.Code, currency.Status)
+	}
+
+	err := s.validateCurrencyAmount(currency, amount)
+	if err != nil {
+		return err
+	}
+
+	err = s.moveCurrencyFunds(ctx, fromID, toID, currency.Code, amount)
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, fromID, toID, amount, txType)
+	transaction.Currency = currency.Code
+	return s.putTransaction(ctx, transaction)
+}
+
+// validateCurrencyIssuer checks that the caller is the currency's registered issuer identity
+func (s *SmartContract) validateCurrencyIssuer(ctx contractapi.TransactionContextInterface, currency *Currency) error {
+	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %v", err)
+	}
+	if clientMSPID != currency.IssuerMSP {
+		return fmt.Errorf("caller is not the issuer of %s", currency.Code)
+	}
+
+	// Other members of the issuer's MSP are not the issuer; the central bank acts through its administrators
+	if currency.IssuerAccountID == s.getCentralBankID() {
+		return s.validateCentralBankAdmin(ctx)
+	}
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != currency.IssuerAccountID {
+		return fmt.Errorf("caller is not the issuer of %s", currency.Code)
+	}
+	return nil
+}
+
+// validateCurrencyAmount checks that amount is positive and has no more decimals than the currency allows
+func (s *SmartContract) validateCurrencyAmount(currency *Currency, amount float64) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	scaled := amount * math.Pow10(currency.Decimals)
+	if math.Abs(scaled-math.Round(scaled)) > 1e-6 {
+		return fmt.Errorf("%s amounts allow at most %d decimals", currency.Code, currency.Decimals)
+	}
+	return nil
+}
+
+func (s *SmartContract) getDefaultCurrency() *Currency {
+	return &Currency{
+		DocType:         "currency",
+		Code:            defaultCurrency,
+		Name:            "Central Bank Digital Currency",
+		IssuerMSP:       "Org1MSP",
+		IssuerAccountID: s.getCentralBankID(),
+		Decimals:        2,
+		NumericCode:     defaultNumericCode,
+		Status:          "Active",
+	}
+}
+
+func (s *SmartContract) getCurrencyKey(code string) string {
+	return "currency_" + code
+}
+
+// getCurrency reads a currency from the registry; the default currency exists even if never stored
+func (s *SmartContract) getCurrency(ctx contractapi.TransactionContextInterface, code string) (*Currency, error) {
+	currencyBytes, err := ctx.GetStub().GetState(s.getCurrencyKey(code))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read currency: %v", err)
+	}
+	if currencyBytes == nil {
+		if code == defaultCurrency {
+			return s.getDefaultCurrency(), nil
+		}
+		return nil, fmt.Errorf("currency %s is not registered", code)
+	}
+
+	var currency Currency
+	err = s.decodeDocument("currency", currencyBytes, &currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal currency: %v", err)
+	}
+
+	return &currency, nil
+}
+
+func (s *SmartContract) putCurrency(ctx contractapi.TransactionContextInterface, currency *Currency) error {
+	currency.SchemaVersion = s.currentSchemaVersion("currency")
+	currencyJSON, err := json.Marshal(currency)
+	if err != nil {
+		return fmt.Errorf("failed to marshal currency: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getCurrencyKey(currency.Code), currencyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put currency state: %v", err)
+	}
+	return nil
+}
+
+func isValidCurrencyCode(code string) bool {
+	if len(code) < 3 || len(code) > 12 {
+		return false
+	}
+	for _, c := range code {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+// registerTestCurrency registers EURC, issued by ecb@org3 into the "ecb" account, and issues amount of it
+func (l *testLedger) registerTestCurrency(amount float64) {
+	l.t.Helper()
+	l.asCentralBank().mustInvoke("RegisterCurrency", "EURC", "Euro coin", "Org3MSP", "ecb", 2, "978")
+	l.as("Org3MSP", "ecb@org3.example.com").mustInvoke("IssueCurrency", "EURC", amount)
+}
+
+func TestRegisterCurrency(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asBank("bank1").mustFailWith("only central bank", "RegisterCurrency", "EURC", "Euro coin", "Org3MSP", "ecb", 2, "978")
+	l.asCentralBank().mustFailWith("3-12 uppercase", "RegisterCurrency", "eu", "Euro coin", "Org3MSP", "ecb", 2, "978")
+	l.mustFailWith("already registered", "RegisterCurrency", defaultCurrency, "Again", "Org1MSP", "central-bank", 2, "999")
+	l.registerTestCurrency(1000)
+	l.asCentralBank().mustFailWith("already registered", "RegisterCurrency", "EURC", "Euro coin", "Org3MSP", "ecb", 2, "978")
+
+	var currencies []*Currency
+	l.mustQuery(&currencies, "ListCurrencies")
+	if len(currencies) != 2 || currencies[0].Code != defaultCurrency || currencies[1].Code != "EURC" {
+		t.Fatalf("unexpected currencies %+v", currencies)
+	}
+}
+
+func TestCurrencyBalancesAreSeparate(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.registerTestCurrency(1000)
+
+	l.asBank("bank1").mustFailWith("only the issuer", "IssueCurrency", "EURC", 10.0)
+	l.as("Org3MSP", "ecb@org3.example.com").mustFailWith("at most 2 decimals", "IssueCurrency", "EURC", 0.001)
+	l.mustInvoke("DistributeCurrency", "EURC", "bank1", 300.0)
+	l.asBank("bank1").mustInvoke("TransferCurrency", "bank1", "alice", "EURC", 120.0)
+	l.asUser("alice").mustInvoke("RedeemCurrency", "alice", "EURC", 20.0)
+	l.mustFailWith("insufficient funds", "RedeemCurrency", "alice", "EURC", 500.0)
+
+	var balance AccountBalance
+	l.mustQuery(&balance, "GetCurrencyBalance", "alice", "EURC")
+	if balance.Balance != 100 {
+		t.Fatalf("alice holds %.2f EURC, expected 100", balance.Balance)
+	}
+	l.expectBalance("alice", 100)
+	l.expectBalance("bank1", 0)
+	l.mustFailWith("not registered", "GetCurrencyBalance", "alice", "GBPC")
+}
+
+func TestCurrencyStatus(t *testing.T) {
+	l := newTestLedger(t)
+	l.registerTestCurrency(1000)
+	ecb := func() *testLedger { return l.as("Org3MSP", "ecb@org3.example.com") }
+
+	l.asBank("bank1").mustFailWith("only the central bank or the issuer", "SetCurrencyStatus", "EURC", "Suspended")
+	ecb().mustInvoke("SetCurrencyStatus", "EURC", "Suspended")
+	l.mustFailWith("is Suspended", "DistributeCurrency", "EURC", "bank1", 10.0)
+	l.mustFailWith("is Suspended", "IssueCurrency", "EURC", 10.0)
+
+	l.asCentralBank().mustInvoke("SetCurrencyStatus", "EURC", "Active")
+	ecb().mustInvoke("DistributeCurrency", "EURC", "bank1", 10.0)
+	l.asCentralBank().mustFailWith("unknown currency status", "SetCurrencyStatus", "EURC", "Gone")
+}
+
+func TestDefaultCurrencyKeepsItsApprovals(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustFailWith("CBDC is issued with IssueTokens", "IssueCurrency", defaultCurrency, 100.0)
+	l.mustInvoke("IssueTokens", 100.0)
+	l.mustFailWith("CBDC is distributed with TransferToCB", "DistributeCurrency", defaultCurrency, "bank1", 50.0)
+	l.expectBalance("central-bank", 100)
+
+	// Nor can CBDC bypass the fees, limits and redemption tracking of its own transactions
+	l.fund("bank1", "alice", 50)
+	l.asUser("alice").mustFailWith("CBDC is transferred with TransferTokens", "TransferCurrency", "alice", "bob", defaultCurrency, 10.0)
+	l.mustFailWith("CBDC is redeemed with RedeemTokens", "RedeemCurrency", "alice", defaultCurrency, 10.0)
+	l.expectBalance("alice", 50)
+}
+
+func TestOnlyTheRegisteredIssuerIssues(t *testing.T) {
+	l := newTestLedger(t)
+	l.registerTestCurrency(1000)
+
+	// Another member of the issuer's MSP is not the issuer
+	l.as("Org3MSP", "clerk@org3.example.com").mustFailWith("caller is not the issuer of EURC", "IssueCurrency", "EURC", 10.0)
+	l.mustFailWith("only the central bank or the issuer", "SetCurrencyStatus", "EURC", "Suspended")
+
+	var supply SupplyRecord
+	l.mustQuery(&supply, "GetCurrencySupply", "EURC")
+	if supply.TotalSupply != 1000 {
+		t.Fatalf("EURC supply is %.2f, expected 1000", supply.TotalSupply)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DefundEvent tells a commercial bank's core system to credit a customer's deposit account
+type DefundEvent struct {
+	TxID             string  `json:"txId"`
+	UserID           string  `json:"userId"`
+	BankID           string  `json:"bankId"`
+	Amount           float64 `json:"amount"`
+	DepositReference string  `json:"depositReference"`
+}
+
+// Defund converts the caller's CBDC back into deposits at its linked servicing bank. The bank
+// credits the deposit account named by depositReference on the UserDefunded event.
+func (s *SmartContract) Defund(ctx contractapi.TransactionContextInterface, amount float64, depositReference string) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if depositReference == "" {
+		return fmt.Errorf("deposit reference is required")
+	}
+
+	userID, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	bankID, err := s.getServicingBank(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if bankID == "" {
+		return fmt.Errorf("%s has no servicing bank", userID)
+	}
+
+	err = s.moveFunds(ctx, userID, bankID, amount)
+	if err != nil {
+		return err
+	}
+
+	// The CBDC is back with the bank and no longer with its customers
+	err = s.recordBankRedemption(ctx, bankID, amount)
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, userID, bankID, amount, "Defund")
+	transaction.Reference = depositReference
+	err = s.putTransaction(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	event := DefundEvent{
+		TxID:             transaction.TxID,
+		UserID:           userID,
+		BankID:           bankID,
+		Amount:           amount,
+		DepositReference: depositReference,
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal defund event: %v", err)
+	}
+	err = ctx.GetStub().SetEvent("UserDefunded", eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set defund event: %v", err)
+	}
+
+	return nil
+}
+
+// ReturnToCentralBank passes CBDC from the calling commercial bank back to the central bank,
+// for example after customers have defunded. A bank cannot return the reserve it must hold
+// against what its customers still have while the requirement is enforced.
+func (s *SmartContract) ReturnToCentralBank(ctx contractapi.TransactionContextInterface, amount float64) error {
+	err := s.validateCallerIsCommercialBank(ctx)
+	if err != nil {
+		return fmt.Errorf("only commercial banks can return CBDC to the central bank: %v", err)
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	bankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkReturnAllowed(ctx, bankID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Return from the bank's own balance; moveFunds would draw on its credit line
+	err = s.changeBalance(ctx, bankID, -amount)
+	if err != nil {
+		return err
+	}
+	err = s.changeBalance(ctx, s.getCentralBankID(), amount)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordReturn(ctx, bankID, amount)
+	if err != nil {
+		return err
+	}
+
+	return s.recordTransaction(ctx, bankID, s.getCentralBankID(), amount, "CommercialToCB")
+}
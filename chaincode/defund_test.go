@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDefundToServicingBank(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("bob").mustFailWith("no servicing bank", "Defund", 10.0, "DE00 1234")
+	l.asUser("alice").mustFailWith("alice has no servicing bank", "Defund", 10.0, "DE00 1234")
+	l.link("bank1", "alice")
+
+	// A later funder does not receive alice's defunds
+	l.fund("bank2", "alice", 1)
+	l.asUser("alice").mustFailWith("deposit reference is required", "Defund", 10.0, "")
+	l.mustFailWith("Insufficient balance", "Defund", 150.0, "DE00 1234")
+	l.mustInvoke("Defund", 40.0, "DE00 1234")
+
+	var event DefundEvent
+	err := json.Unmarshal(l.events["UserDefunded"], &event)
+	if err != nil || event.BankID != "bank1" || event.UserID != "alice" || event.Amount != 40 || event.DepositReference != "DE00 1234" {
+		t.Fatalf("unexpected defund event %+v (%v)", event, err)
+	}
+
+	l.expectBalance("alice", 61)
+	l.expectBalance("bank1", 40)
+
+	var reserve BankReserve
+	l.mustQuery(&reserve, "GetBankReserve", "bank1")
+	if reserve.Distributed != 60 {
+		t.Fatalf("bank1 still shows %.2f distributed, expected 60", reserve.Distributed)
+	}
+}
+
+func TestReturnToCentralBank(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+
+	l.asUser("alice").mustFailWith("only commercial banks", "ReturnToCentralBank", 10.0)
+	l.asBank("bank1").mustFailWith("amount must be positive", "ReturnToCentralBank", 0.0)
+	l.mustInvoke("ReturnToCentralBank", 30.0)
+
+	l.expectBalance("bank1", 70)
+	l.expectBalance("central-bank", 30)
+
+	// The reserve held against customers' CBDC cannot be returned
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 50.0)
+	l.asCentralBank().mustInvoke("SetReserveRequirement", 0.2, true)
+	l.asBank("bank1").mustFailWith("return would leave bank1 short of its reserve requirement by 5.00", "ReturnToCentralBank", 15.0)
+	l.mustInvoke("ReturnToCentralBank", 10.0)
+	l.expectBalance("bank1", 10)
+
+	var reserve BankReserve
+	l.mustQuery(&reserve, "GetBankReserve", "bank1")
+	if reserve.Distributed != 50 || reserve.TotalReturned != 40 {
+		t.Fatalf("unexpected reserve after returns %+v", reserve)
+	}
+}
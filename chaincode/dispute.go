@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Dispute deadlines, in seconds
+const (
+	disputeWindow         = 120 * 24 * 60 * 60 // After the original payment
+	disputeEvidencePeriod = 10 * 24 * 60 * 60  // After the dispute is opened
+	disputeReviewPeriod   = 30 * 24 * 60 * 60  // After the dispute is opened
+)
+
+// Dispute represents a consumer's dispute of a merchant payment
+type Dispute struct {
+	DocType          string            `json:"docType"`
+	SchemaVersion    int               `json:"schemaVersion"`
+	OriginalTxID     string            `json:"originalTxId"`
+	ConsumerID       string            `json:"consumerId"`
+	MerchantID       string            `json:"merchantId"`
+	Amount           float64           `json:"amount"`
+	Currency         string            `json:"currency"`
+	HeldAmount       float64           `json:"heldAmount"`
+	Reason           string            `json:"reason"`
+	Evidence         []DisputeEvidence `json:"evidence"`
+	Status           string            `json:"status"`  // Open, Resolved, Expired
+	Outcome          string            `json:"outcome"` // Refund, PartialRefund, Rejected
+	RefundedAmount   float64           `json:"refundedAmount"`
+	ResolvedBy       string            `json:"resolvedBy"`
+	OpenedAt         int64             `json:"openedAt"`
+	EvidenceDeadline int64             `json:"evidenceDeadline"`
+	ReviewDeadline   int64             `json:"reviewDeadline"`
+	ClosedAt         int64             `json:"closedAt"`
+}
+
+// DisputeEvidence records the hash of a document held off-chain
+type DisputeEvidence struct {
+	SubmittedBy string `json:"submittedBy"`
+	Hash        string `json:"hash"` // Hex-encoded SHA-256
+	Description string `json:"description"`
+	SubmittedAt int64  `json:"submittedAt"`
+}
+
+// OpenDispute disputes part or all of a payment made by the caller, optionally holding the amount on the merchant account
+func (s *SmartContract) OpenDispute(ctx contractapi.TransactionContextInterface, originalTxID string, amount float64, reason string, holdFunds bool) (*Dispute, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	original, err := s.getRefundableTransaction(ctx, originalTxID)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller != original.FromID {
+		return nil, fmt.Errorf("only the payer of %s can dispute it", originalTxID)
+	}
+
+	existing, err := ctx.GetStub().GetState(s.getDisputeKey(originalTxID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dispute: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("transaction %s has already been disputed", originalTxID)
+	}
+
+	if amount > original.Amount-original.RefundedAmount {
+		return nil, fmt.Errorf("amount exceeds refundable remainder %.2f of transaction %s", original.Amount-original.RefundedAmount, originalTxID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now > original.Timestamp+disputeWindow {
+		return nil, fmt.Errorf("dispute window for transaction %s has closed", originalTxID)
+	}
+
+	dispute := &Dispute{
+		DocType:          "dispute",
+		OriginalTxID:     originalTxID,
+		ConsumerID:       original.FromID,
+		MerchantID:       original.ToID,
+		Amount:           amount,
+		Currency:         original.Currency,
+		Reason:           reason,
+		Evidence:         []DisputeEvidence{},
+		Status:           "Open",
+		OpenedAt:         now,
+		EvidenceDeadline: now + disputeEvidencePeriod,
+		ReviewDeadline:   now + disputeReviewPeriod,
+	}
+
+	// Hold whatever part of the disputed amount the merchant can currently cover
+	if holdFunds {
+		merchantBalance, err := s.getCurrencyBalance(ctx, original.ToID, original.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get merchant balance: %v", err)
+		}
+		dispute.HeldAmount = math.Min(amount, merchantBalance.Balance)
+		if dispute.HeldAmount > 0 {
+			err = s.adjustHold(ctx, merchantBalance, dispute.HeldAmount)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = s.putDispute(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	return dispute, s.emitDisputeEvent(ctx, dispute)
+}
+
+// AttachDisputeEvidence adds the hash of an off-chain document to an open dispute (consumer or merchant only)
+func (s *SmartContract) AttachDisputeEvidence(ctx contractapi.TransactionContextInterface, originalTxID string, hash string, description string) error {
+	dispute, err := s.getDispute(ctx, originalTxID)
+	if err != nil {
+		return err
+	}
+	if dispute.Status != "Open" {
+		return fmt.Errorf("dispute on %s is %s", originalTxID, dispute.Status)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != dispute.ConsumerID && caller != dispute.MerchantID {
+		return fmt.Errorf("only the parties to the dispute can attach evidence")
+	}
+
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != 32 {
+		return fmt.Errorf("evidence hash must be a hex-encoded SHA-256 digest")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if now > dispute.EvidenceDeadline {
+		return fmt.Errorf("evidence deadline for dispute on %s has passed", originalTxID)
+	}
+
+	dispute.Evidence = append(dispute.Evidence, DisputeEvidence{
+		SubmittedBy: caller,
+		Hash:        hash,
+		Description: description,
+		SubmittedAt: now,
+	})
+
+	err = s.putDispute(ctx, dispute)
+	if err != nil {
+		return err
+	}
+
+	return s.emitDisputeEvent(ctx, dispute)
+}
+
+// ResolveDispute adjudicates an open dispute before its review deadline (central bank
+// administrators or the merchant's servicing bank, never a party to the dispute). The outcome is
+// Refund, PartialRefund or Rejected; amount is only used for PartialRefund. The refund is capped
+// at what the merchant has not already refunded of the payment.
+func (s *SmartContract) ResolveDispute(ctx contractapi.TransactionContextInterface, originalTxID string, outcome string, amount float64) (*Dispute, error) {
+	dispute, err := s.getDispute(ctx, originalTxID)
+	if err != nil {
+		return nil, err
+	}
+	if dispute.Status != "Open" {
+		return nil, fmt.Errorf("dispute on %s is %s", originalTxID, dispute.Status)
+	}
+
+	resolver, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resolver == dispute.ConsumerID || resolver == dispute.MerchantID {
+		return nil, fmt.Errorf("parties to a dispute cannot resolve it")
+	}
+	if s.validateCentralBankAdmin(ctx) != nil {
+		_, err = s.validateServicingBank(ctx, dispute.MerchantID)
+		if err != nil {
+			return nil, fmt.Errorf("only the central bank or the merchant's servicing bank can resolve disputes: %v", err)
+		}
+	}
+
+	var refundAmount float64
+	switch outcome {
+	case "Refund":
+		refundAmount = dispute.Amount
+	case "PartialRefund":
+		if amount <= 0 || amount >= dispute.Amount {
+			return nil, fmt.Errorf("partial refund must be positive and less than the disputed amount %.2f", dispute.Amount)
+		}
+		refundAmount = amount
+	case "Rejected":
+		refundAmount = 0
+	default:
+		return nil, fmt.Errorf("unknown dispute outcome %s", outcome)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now > dispute.ReviewDeadline {
+		return nil, fmt.Errorf("review deadline for dispute on %s has passed", originalTxID)
+	}
+
+	// The merchant may have refunded part of the payment since the dispute was opened
+	original, err := s.getTransaction(ctx, originalTxID)
+	if err != nil {
+		return nil, err
+	}
+	refundAmount = math.Min(refundAmount, original.Amount-original.RefundedAmount)
+
+	err = s.releaseDisputeHold(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	if refundAmount > 0 {
+		err = s.unwindPayment(ctx, original, refundAmount, "Chargeback", "dispute resolved: "+outcome)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dispute.Status = "Resolved"
+	dispute.Outcome = outcome
+	dispute.RefundedAmount = refundAmount
+	dispute.ResolvedBy = resolver
+	dispute.ClosedAt = now
+
+	err = s.putDispute(ctx, dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	return dispute, s.emitDisputeEvent(ctx, dispute)
+}
+
+// ExpireDispute closes a dispute that was not adjudicated before its review deadline and releases any hold
+func (s *SmartContract) ExpireDispute(ctx contractapi.TransactionContextInterface, originalTxID string) error {
+	dispute, err := s.getDispute(ctx, originalTxID)
+	if err != nil {
+		return err
+	}
+	if dispute.Status != "Open" {
+		return fmt.Errorf("dispute on %s is %s", originalTxID, dispute.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if now <= dispute.ReviewDeadline {
+		return fmt.Errorf("dispute on %s is still under review", originalTxID)
+	}
+
+	err = s.releaseDisputeHold(ctx, dispute)
+	if err != nil {
+		return err
+	}
+
+	dispute.Status = "Expired"
+	dispute.ClosedAt = now
+
+	err = s.putDispute(ctx, dispute)
+	if err != nil {
+		return err
+	}
+
+	return s.emitDisputeEvent(ctx, dispute)
+}
+
+// GetDispute returns the dispute raised against a transaction
+func (s *SmartContract) GetDispute(ctx contractapi.TransactionContextInterface, originalTxID string) (*Dispute, error) {
+	return s.getDispute(ctx, originalTxID)
+}
+
+// adjustHold moves amount from the available balance into the held balance; a negative amount releases it
+func (s *SmartContract) adjustHold(ctx contractapi.TransactionContextInterface, balance *AccountBalance, amount float64) error {
+	if amount > balance.Balance {
+		return fmt.Errorf("insufficient available balance to hold %.2f on %s", amount, balance.AccountID)
+	}
+	if -amount > balance.HeldBalance {
+		return fmt.Errorf("cannot release %.2f held on %s", -amount, balance.AccountID)
+	}
+
+	balance.Balance -= amount
+	balance.HeldBalance += amount
+
+	return s.putAccountBalance(ctx, balance)
+}
+
+func (s *SmartContract) releaseDisputeHold(ctx contractapi.TransactionContextInterface, dispute *Dispute) error {
+	if dispute.HeldAmount <= 0 {
+		return nil
+	}
+
+	merchantBalance, err := s.getCurrencyBalance(ctx, dispute.MerchantID, dispute.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to get merchant balance: %v", err)
+	}
+
+	err = s.adjustHold(ctx, merchantBalance, -dispute.HeldAmount)
+	if err != nil {
+		return err
+	}
+
+	dispute.HeldAmount = 0
+	return nil
+}
+
+func (s *SmartContract) getDisputeKey(originalTxID string) string {
+	return "dispute_" + originalTxID
+}
+
+func (s *SmartContract) getDispute(ctx contractapi.TransactionContextInterface, originalTxID string) (*Dispute, error) {
+	disputeBytes, err := ctx.GetStub().GetState(s.getDisputeKey(originalTxID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dispute: %v", err)
+	}
+	if disputeBytes == nil {
+		return nil, fmt.Errorf("no dispute exists for transaction %s", originalTxID)
+	}
+
+	var dispute Dispute
+	err = s.decodeDocument("dispute", disputeBytes, &dispute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dispute: %v", err)
+	}
+
+	return &dispute, nil
+}
+
+func (s *SmartContract) putDispute(ctx contractapi.TransactionContextInterface, dispute *Dispute) error {
+	dispute.SchemaVersion = s.currentSchemaVersion("dispute")
+	disputeJSON, err := json.Marshal(dispute)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dispute: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getDisputeKey(dispute.OriginalTxID), disputeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put dispute state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) emitDisputeEvent(ctx contractapi.TransactionContextInterface, dispute *Dispute) error {
+	eventJSON, err := json.Marshal(dispute)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dispute event: %v", err)
+	}
+	err = ctx.GetStub().SetEvent("DisputeStatusChanged", eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set dispute event: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDisputeRefundReleasesHold(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 60.0)
+	paymentTxID := l.lastTxID()
+
+	l.asUser("shop").mustFailWith("only the payer", "OpenDispute", paymentTxID, 60.0, "not delivered", true)
+	l.asUser("alice").mustFailWith("exceeds refundable remainder", "OpenDispute", paymentTxID, 61.0, "not delivered", true)
+
+	var dispute Dispute
+	l.mustQuery(&dispute, "OpenDispute", paymentTxID, 60.0, "not delivered", true)
+	if dispute.Status != "Open" || dispute.HeldAmount != 60 || dispute.ReviewDeadline != testStartTime+disputeReviewPeriod {
+		t.Fatalf("unexpected dispute %+v", dispute)
+	}
+	l.mustFailWith("already been disputed", "OpenDispute", paymentTxID, 10.0, "again", false)
+	l.expectBalance("shop", 0)
+
+	digest := sha256.Sum256([]byte("receipt"))
+	l.asUser("shop").mustInvoke("AttachDisputeEvidence", paymentTxID, hex.EncodeToString(digest[:]), "delivery receipt")
+	l.mustFailWith("SHA-256", "AttachDisputeEvidence", paymentTxID, "abcd", "bad hash")
+	l.asUser("bob").mustFailWith("only the parties", "AttachDisputeEvidence", paymentTxID, hex.EncodeToString(digest[:]), "")
+
+	l.asUser("alice").mustFailWith("parties to a dispute cannot resolve it", "ResolveDispute", paymentTxID, "Refund", 0.0)
+	l.asBank("bank1").mustFailWith("only the central bank or the merchant's servicing bank", "ResolveDispute", paymentTxID, "Refund", 0.0)
+	l.asCentralBank().mustFailWith("unknown dispute outcome", "ResolveDispute", paymentTxID, "Maybe", 0.0)
+	l.mustInvoke("ResolveDispute", paymentTxID, "Refund", 0.0)
+	l.mustFailWith("is Resolved", "ResolveDispute", paymentTxID, "Rejected", 0.0)
+
+	l.expectBalance("alice", 100)
+	l.expectBalance("shop", 0)
+
+	var resolved Dispute
+	l.mustQuery(&resolved, "GetDispute", paymentTxID)
+	if resolved.Outcome != "Refund" || resolved.RefundedAmount != 60 || resolved.HeldAmount != 0 || len(resolved.Evidence) != 1 {
+		t.Fatalf("unexpected resolved dispute %+v", resolved)
+	}
+}
+
+func TestDisputePartialRefundAndRejection(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 30.0)
+	firstTxID := l.lastTxID()
+	l.mustInvoke("TransferTokens", "alice", "shop", 20.0)
+	secondTxID := l.lastTxID()
+
+	l.mustInvoke("OpenDispute", firstTxID, 30.0, "damaged", false)
+	l.mustInvoke("OpenDispute", secondTxID, 20.0, "changed my mind", true)
+
+	l.asCentralBank().mustFailWith("partial refund must be positive", "ResolveDispute", firstTxID, "PartialRefund", 30.0)
+	l.mustInvoke("ResolveDispute", firstTxID, "PartialRefund", 10.0)
+
+	// The bank serving the merchant can resolve too
+	l.fund("bank2", "shop", 5)
+	l.link("bank2", "shop")
+	l.asBank("bank2").mustInvoke("ResolveDispute", secondTxID, "Rejected", 0.0)
+
+	l.expectBalance("alice", 60)
+	l.expectBalance("shop", 45)
+}
+
+func TestDisputeExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 50.0)
+	paymentTxID := l.lastTxID()
+	l.mustInvoke("OpenDispute", paymentTxID, 50.0, "not delivered", true)
+	l.expectBalance("shop", 0)
+
+	l.mustFailWith("still under review", "ExpireDispute", paymentTxID)
+	l.now += disputeReviewPeriod + 1
+	l.mustInvoke("ExpireDispute", paymentTxID)
+	l.mustFailWith("is Expired", "ExpireDispute", paymentTxID)
+
+	l.expectBalance("shop", 50)
+	l.expectBalance("alice", 50)
+}
+
+func TestDisputeDeadlinesUseTransactionTime(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 30.0)
+	lateTxID := l.lastTxID()
+	l.mustInvoke("TransferTokens", "alice", "shop", 30.0)
+	reviewedTxID := l.lastTxID()
+	if payment := l.transaction(lateTxID); payment.Timestamp != testStartTime {
+		t.Fatalf("payment is stamped %d, expected the transaction time %d", payment.Timestamp, testStartTime)
+	}
+
+	l.mustInvoke("OpenDispute", reviewedTxID, 30.0, "not delivered", true)
+
+	// A resolution after the review deadline would race ExpireDispute
+	l.now += disputeReviewPeriod + 1
+	l.asCentralBank().mustFailWith("review deadline for dispute on "+reviewedTxID+" has passed", "ResolveDispute", reviewedTxID, "Refund", 0.0)
+	l.mustInvoke("ExpireDispute", reviewedTxID)
+
+	l.now = testStartTime + disputeWindow + 1
+	l.asUser("alice").mustFailWith("dispute window", "OpenDispute", lateTxID, 30.0, "not delivered", false)
+	l.expectBalance("shop", 60)
+}
+
+func TestDisputeRefundCappedAtRemainder(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 50.0)
+	paymentTxID := l.lastTxID()
+	l.mustInvoke("OpenDispute", paymentTxID, 50.0, "not delivered", false)
+
+	// The merchant refunds part of the payment while the dispute is open
+	l.asUser("shop").mustInvoke("RefundPayment", paymentTxID, 20.0)
+
+	var resolved Dispute
+	l.asCentralBank().mustQuery(&resolved, "ResolveDispute", paymentTxID, "Refund", 0.0)
+	if resolved.Status != "Resolved" || resolved.RefundedAmount != 30 {
+		t.Fatalf("unexpected resolved dispute %+v", resolved)
+	}
+	l.expectBalance("alice", 100)
+	l.expectBalance("shop", 0)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FeeSchedule defines the fee charged on a transaction type, either by default or for one bank
+type FeeSchedule struct {
+	DocType       string    `json:"docType"`
+	SchemaVersion int       `json:"schemaVersion"`
+	TxType        string    `json:"txType"`
+	BankID        string    `json:"bankId"` // Empty for the default schedule
+	Model         string    `json:"model"`  // Flat, Percentage, Tiered
+	FlatFee       float64   `json:"flatFee"`
+	Rate          float64   `json:"rate"` // Fraction of the amount, e.g. 0.01 for 1%
+	Tiers         []FeeTier `json:"tiers"`
+	MaxFee        float64   `json:"maxFee"`    // 0 means uncapped
+	ChargedTo     string    `json:"chargedTo"` // Payer or Payee
+	FeeAccountID  string    `json:"feeAccountId"`
+	SetBy         string    `json:"setBy"`
+	ModifiedAt    int64     `json:"modifiedAt"`
+}
+
+// FeeTier is one band of a tiered fee; the first tier whose UpTo covers the amount applies
+type FeeTier struct {
+	UpTo    float64 `json:"upTo"` // 0 means no upper bound
+	FlatFee float64 `json:"flatFee"`
+	Rate    float64 `json:"rate"`
+}
+
+// FeeCharge is a fee collected on a transaction
+type FeeCharge struct {
+	Amount    float64
+	AccountID string
+	ChargedTo string
+	BankID    string // Bank whose schedule set the fee, empty for the default schedule
+}
+
+// FeeRevenue totals the fees earned under one bank's schedules, or under the default schedules,
+// in one calendar month (UTC)
+type FeeRevenue struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	BankID        string  `json:"bankId"`                                      // Empty for the default schedules
+	FeeAccountID  string  `json:"feeAccountId,omitempty" metadata:",optional"` // Account last credited
+	Period        string  `json:"period"`                                      // YYYY-MM
+	Amount        float64 `json:"amount"`
+	Count         int     `json:"count"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// SetFeeSchedule creates or replaces a fee schedule. Central bank administrators can set any
+// schedule, under approval; a commercial bank can only set schedules for itself, collected into
+// its own account. Fees a bank's schedule charges the payer are capped at what the default
+// schedule would charge.
+func (s *SmartContract) SetFeeSchedule(ctx contractapi.TransactionContextInterface, txType string, bankID string, model string, flatFee float64, rate float64, tiers []FeeTier, maxFee float64, chargedTo string, feeAccountID string) (*FeeSchedule, error) {
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.validateCentralBankAdmin(ctx) == nil {
+		err = s.requireApproval(ctx, "SetFeeSchedule")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		err = s.validateCallerIsCommercialBank(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("only the central bank or a commercial bank can set fee schedules: %v", err)
+		}
+		if bankID != caller {
+			return nil, fmt.Errorf("commercial banks can only set their own fee schedules")
+		}
+		if feeAccountID != "" && feeAccountID != caller {
+			return nil, fmt.Errorf("commercial banks collect fees into their own account")
+		}
+		feeAccountID = caller
+	}
+
+	if txType == "" {
+		return nil, fmt.Errorf("transaction type is required")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if feeAccountID == "" {
+		return nil, fmt.Errorf("fee account is required")
+	}
+	if chargedTo != "Payer" && chargedTo != "Payee" {
+		return nil, fmt.Errorf("fees must be charged to Payer or Payee")
+	}
+	if flatFee < 0 || rate < 0 || maxFee < 0 {
+		return nil, fmt.Errorf("fee amounts and rates must not be negative")
+	}
+
+	switch model {
+	case "Flat", "Percentage":
+	case "Tiered":
+		if len(tiers) == 0 {
+			return nil, fmt.Errorf("tiered fee schedules need at least one tier")
+		}
+		for i, tier := range tiers {
+			if tier.FlatFee < 0 || tier.Rate < 0 || tier.UpTo < 0 {
+				return nil, fmt.Errorf("tier %d has a negative value", i)
+			}
+			if i > 0 && (tiers[i-1].UpTo == 0 || (tier.UpTo != 0 && tier.UpTo <= tiers[i-1].UpTo)) {
+				return nil, fmt.Errorf("tiers must be in ascending order with only the last one unbounded")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown fee model %s", model)
+	}
+	if tiers == nil {
+		tiers = []FeeTier{}
+	}
+
+	schedule := &FeeSchedule{
+		DocType:      "feeSchedule",
+		TxType:       txType,
+		BankID:       bankID,
+		Model:        model,
+		FlatFee:      flatFee,
+		Rate:         rate,
+		Tiers:        tiers,
+		MaxFee:       maxFee,
+		ChargedTo:    chargedTo,
+		FeeAccountID: feeAccountID,
+		SetBy:        caller,
+		ModifiedAt:   now,
+	}
+
+	schedule.SchemaVersion = s.currentSchemaVersion("feeSchedule")
+	scheduleJSON, err := json.Marshal(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal fee schedule: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getFeeScheduleKey(txType, bankID), scheduleJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put fee schedule state: %v", err)
+	}
+
+	return schedule, nil
+}
+
+// RemoveFeeSchedule deletes a fee schedule, with the same permissions as SetFeeSchedule
+func (s *SmartContract) RemoveFeeSchedule(ctx contractapi.TransactionContextInterface, txType string, bankID string) error {
+	if s.validateCentralBankAdmin(ctx) == nil {
+		err := s.requireApproval(ctx, "RemoveFeeSchedule")
+		if err != nil {
+			return err
+		}
+	} else {
+		caller, err := s.getCallerID(ctx)
+		if err != nil {
+			return err
+		}
+		if s.validateCallerIsCommercialBank(ctx) != nil || bankID != caller {
+			return fmt.Errorf("caller not authorized to remove this fee schedule")
+		}
+	}
+
+	return ctx.GetStub().DelState(s.getFeeScheduleKey(txType, bankID))
+}
+
+// GetFeeSchedule returns the fee schedule for a transaction type and bank; an empty bank ID returns the default
+func (s *SmartContract) GetFeeSchedule(ctx contractapi.TransactionContextInterface, txType string, bankID string) (*FeeSchedule, error) {
+	schedule, err := s.getFeeSchedule(ctx, txType, bankID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, fmt.Errorf("no fee schedule for %s", txType)
+	}
+	return schedule, nil
+}
+
+// GetFeeRevenue returns the fees earned under a bank's schedules in each month from fromPeriod to
+// toPeriod (YYYY-MM, inclusive); an empty bank ID returns the default schedules' revenue
+func (s *SmartContract) GetFeeRevenue(ctx contractapi.TransactionContextInterface, bankID string, fromPeriod string, toPeriod string) ([]*FeeRevenue, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(s.getFeeRevenueKey(bankID, fromPeriod), s.getFeeRevenueKey(bankID, toPeriod)+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fee revenue: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	revenues := []*FeeRevenue{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next fee revenue: %v", err)
+		}
+
+		var revenue FeeRevenue
+		err = s.decodeDocument("feeRevenue", queryResult.Value, &revenue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal fee revenue: %v", err)
+		}
+		revenues = append(revenues, &revenue)
+	}
+
+	return revenues, nil
+}
+
+// chargeFee collects the fee for a transaction that moved amount from payerID to payeeID.
+// A schedule for bankID takes precedence over the default one. Returns nil when no fee applies.
+func (s *SmartContract) chargeFee(ctx contractapi.TransactionContextInterface, txType string, bankID string, payerID string, payeeID string, amount float64) (*FeeCharge, error) {
+	fee, err := s.quoteFee(ctx, txType, bankID, payerID, payeeID, amount)
+	if err != nil || fee == nil {
+		return nil, err
+	}
+
+	chargedID := payerID
+	if fee.ChargedTo == "Payee" {
+		chargedID = payeeID
+	}
+
+	err = s.moveFunds(ctx, chargedID, fee.AccountID, fee.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect %s fee: %v", txType, err)
+	}
+
+	err = s.addFeeRevenue(ctx, fee)
+	if err != nil {
+		return nil, err
+	}
+
+	return fee, nil
+}
+
+// chargeUserFee collects the fee for a payment between users, applying the schedule of the
+// payee's servicing bank when it has one
+func (s *SmartContract) chargeUserFee(ctx contractapi.TransactionContextInterface, txType string, payerID string, payeeID string, amount float64) (*FeeCharge, error) {
+	bankID, err := s.getServicingBank(ctx, payeeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.chargeFee(ctx, txType, bankID, payerID, payeeID, amount)
+}
+
+// quoteUserFee returns the fee chargeUserFee would collect, without moving any funds
+func (s *SmartContract) quoteUserFee(ctx contractapi.TransactionContextInterface, txType string, payerID string, payeeID string, amount float64) (*FeeCharge, error) {
+	bankID, err := s.getServicingBank(ctx, payeeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.quoteFee(ctx, txType, bankID, payerID, payeeID, amount)
+}
+
+// quoteFee returns the fee chargeFee would collect, without moving any funds
+func (s *SmartContract) quoteFee(ctx contractapi.TransactionContextInterface, txType string, bankID string, payerID string, payeeID string, amount float64) (*FeeCharge, error) {
+	var schedule *FeeSchedule
+	var err error
+	if bankID != "" {
+		schedule, err = s.getFeeSchedule(ctx, txType, bankID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if schedule == nil {
+		schedule, err = s.getFeeSchedule(ctx, txType, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if schedule == nil {
+		return nil, nil
+	}
+
+	feeAmount := schedule.calculate(amount)
+	chargedID := payerID
+	if schedule.ChargedTo == "Payee" {
+		chargedID = payeeID
+		// A payee never pays more in fees than it received
+		feeAmount = math.Min(feeAmount, amount)
+	} else if schedule.BankID != "" {
+		// The payer need not be the bank's customer, so its fee is capped by the default schedule
+		defaultSchedule, err := s.getFeeSchedule(ctx, txType, "")
+		if err != nil {
+			return nil, err
+		}
+		if defaultSchedule == nil {
+			return nil, nil
+		}
+		feeAmount = math.Min(feeAmount, defaultSchedule.calculate(amount))
+	}
+	if feeAmount <= 0 || chargedID == schedule.FeeAccountID {
+		return nil, nil
+	}
+
+	return &FeeCharge{
+		Amount:    feeAmount,
+		AccountID: schedule.FeeAccountID,
+		ChargedTo: schedule.ChargedTo,
+		BankID:    schedule.BankID,
+	}, nil
+}
+
+// calculate returns the fee on amount under this schedule, applying the cap
+func (f *FeeSchedule) calculate(amount float64) float64 {
+	var fee float64
+	switch f.Model {
+	case "Flat":
+		fee = f.FlatFee
+	case "Percentage":
+		fee = amount * f.Rate
+	case "Tiered":
+		for _, tier := range f.Tiers {
+			if tier.UpTo == 0 || amount <= tier.UpTo {
+				fee = tier.FlatFee + amount*tier.Rate
+				break
+			}
+		}
+	}
+
+	if f.MaxFee > 0 && fee > f.MaxFee {
+		fee = f.MaxFee
+	}
+	return fee
+}
+
+func (s *SmartContract) addFeeRevenue(ctx contractapi.TransactionContextInterface, fee *FeeCharge) error {
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	period := time.Unix(now, 0).UTC().Format("2006-01")
+	key := s.getFeeRevenueKey(fee.BankID, period)
+
+	revenueBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read fee revenue: %v", err)
+	}
+
+	revenue := FeeRevenue{
+		DocType: "feeRevenue",
+		BankID:  fee.BankID,
+		Period:  period,
+	}
+	if revenueBytes != nil {
+		err = s.decodeDocument("feeRevenue", revenueBytes, &revenue)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal fee revenue: %v", err)
+		}
+	}
+
+	revenue.FeeAccountID = fee.AccountID
+	revenue.Amount += fee.Amount
+	revenue.Count++
+	revenue.ModifiedAt = now
+
+	revenue.SchemaVersion = s.currentSchemaVersion("feeRevenue")
+	revenueJSON, err := json.Marshal(revenue)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fee revenue: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, revenueJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put fee revenue state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getFeeScheduleKey(txType string, bankID string) string {
+	return "fee_" + txType + "_" + bankID
+}
+
+func (s *SmartContract) getFeeRevenueKey(bankID string, period string) string {
+	return "feerev_" + bankID + "_" + period
+}
+
+func (s *SmartContract) getFeeSchedule(ctx contractapi.TransactionContextInterface, txType string, bankID string) (*FeeSchedule, error) {
+	scheduleBytes, err := ctx.GetStub().GetState(s.getFeeScheduleKey(txType, bankID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fee schedule: %v", err)
+	}
+	if scheduleBytes == nil {
+		return nil, nil
+	}
+
+	var schedule FeeSchedule
+	err = s.decodeDocument("feeSchedule", scheduleBytes, &schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fee schedule: %v", err)
+	}
+
+	return &schedule, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestDefaultTransferFee(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asCentralBank().mustFailWith("unknown fee model", "SetFeeSchedule", "Transfer", "", "Banded", 0.0, 0.25, []FeeTier{}, 5.0, "Payer", "fees")
+	l.mustFailWith("Payer or Payee", "SetFeeSchedule", "Transfer", "", "Percentage", 0.0, 0.25, []FeeTier{}, 5.0, "Nobody", "fees")
+	l.mustInvoke("SetFeeSchedule", "Transfer", "", "Percentage", 0.0, 0.25, []FeeTier{}, 5.0, "Payer", "fees")
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 10.0)
+	l.mustInvoke("TransferTokens", "alice", "bob", 40.0)
+	cappedTxID := l.lastTxID()
+	l.expectBalance("alice", 100-10-2.5-40-5)
+	l.expectBalance("bob", 50)
+	l.expectBalance("fees", 7.5)
+
+	transfer := l.transaction(cappedTxID)
+	if transfer.Fee != 5 || transfer.FeeAccountID != "fees" || transfer.FeeChargedTo != "Payer" {
+		t.Fatalf("unexpected fee on transaction %+v", transfer)
+	}
+
+	var revenue []*FeeRevenue
+	l.mustQuery(&revenue, "GetFeeRevenue", "", "2023-11", "2023-11")
+	if len(revenue) != 1 || revenue[0].Amount != 7.5 || revenue[0].Count != 2 || revenue[0].FeeAccountID != "fees" {
+		t.Fatalf("unexpected fee revenue %+v", revenue)
+	}
+}
+
+func TestBankFeeSchedule(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asBank("bank1").mustFailWith("only set their own", "SetFeeSchedule", "CommercialToUser", "bank2", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payee", "bank1")
+	l.mustFailWith("into their own account", "SetFeeSchedule", "CommercialToUser", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payee", "bank2")
+	var schedule FeeSchedule
+	l.mustQuery(&schedule, "SetFeeSchedule", "CommercialToUser", "bank1", "Tiered", 0.0, 0.0, []FeeTier{{UpTo: 50, FlatFee: 1}, {FlatFee: 2}}, 0.0, "Payee", "")
+	if schedule.FeeAccountID != "bank1" {
+		t.Fatalf("bank schedule collects into %s", schedule.FeeAccountID)
+	}
+	l.mustFailWith("ascending order", "SetFeeSchedule", "CommercialToUser", "bank1", "Tiered", 0.0, 0.0, []FeeTier{{FlatFee: 2}, {UpTo: 50, FlatFee: 1}}, 0.0, "Payee", "bank1")
+
+	l.fund("bank1", "alice", 40)
+	l.fund("bank1", "alice", 60)
+	l.fund("bank2", "bob", 60)
+	l.expectBalance("alice", 97)
+	l.expectBalance("bank1", 3)
+	l.expectBalance("bob", 60)
+
+	l.asBank("bank2").mustFailWith("not authorized", "RemoveFeeSchedule", "CommercialToUser", "bank1")
+	l.asBank("bank1").mustInvoke("RemoveFeeSchedule", "CommercialToUser", "bank1")
+	l.fund("bank1", "alice", 10)
+	l.expectBalance("alice", 107)
+}
+
+func TestPayeeBankScheduleAppliesToUserPayments(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "shop", 10)
+	l.fund("bank2", "alice", 100)
+	l.fund("bank2", "bob", 10)
+	l.link("bank1", "shop")
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payee", "bank1")
+
+	// Paying one of bank1's customers carries bank1's fee
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 20.0)
+	paymentTxID := l.lastTxID()
+	l.expectBalance("shop", 29)
+	l.expectBalance("bank1", 1)
+	if payment := l.transaction(paymentTxID); payment.Fee != 1 || payment.FeeAccountID != "bank1" || payment.FeeChargedTo != "Payee" {
+		t.Fatalf("unexpected fee on payment %+v", payment)
+	}
+
+	// A payee served by another bank is not charged bank1's fee
+	l.mustInvoke("TransferTokens", "alice", "bob", 20.0)
+	l.expectBalance("bob", 30)
+	l.expectBalance("alice", 60)
+}
+
+func TestBankPayerFeesAreCappedByTheDefault(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank2", "alice", 100)
+	l.fund("bank1", "shop", 10)
+	l.link("bank1", "shop")
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 50.0, 0.0, []FeeTier{}, 0.0, "Payer", "")
+
+	// Without a default schedule a bank cannot charge other banks' customers
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 10.0)
+	l.expectBalance("alice", 90)
+	l.expectBalance("bank1", 0)
+
+	l.asCentralBank().mustInvoke("SetFeeSchedule", "Transfer", "", "Flat", 2.0, 0.0, []FeeTier{}, 0.0, "Payer", "fees")
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 10.0)
+	l.expectBalance("alice", 78)
+	l.expectBalance("bank1", 2)
+
+	var revenue []*FeeRevenue
+	l.mustQuery(&revenue, "GetFeeRevenue", "bank1", "2023-11", "2023-11")
+	if len(revenue) != 1 || revenue[0].BankID != "bank1" || revenue[0].Amount != 2 {
+		t.Fatalf("unexpected bank1 fee revenue %+v", revenue)
+	}
+	l.mustQuery(&revenue, "GetFeeRevenue", "", "2023-11", "2023-11")
+	if len(revenue) != 0 {
+		t.Fatalf("bank1's fees were booked to the default schedules %+v", revenue)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testStartTime is the transaction timestamp tests start from
+const testStartTime = 1700000000
+
+// Tests run as chaincode testChaincodeName on channel testChannelID, which signed payloads are
+// bound to through testSigningDomain
+const (
+	testChannelID     = "cbdcchannel"
+	testChaincodeName = "cbdc"
+	testSigningDomain = testChannelID + "|" + testChaincodeName
+)
+
+// testLedger invokes the chaincode on a mock stub with the peer's commit semantics: a
+// transaction reads only state committed before it, and its writes are dropped if it fails.
+type testLedger struct {
+	t             *testing.T
+	stub          *shimtest.MockStub
+	chaincode     *contractapi.ContractChaincode
+	chaincodeName string // Name the chaincode is invoked under
+	identities    map[string][]byte
+	txCount       int
+	now           int64
+	events        map[string][]byte // Events set by the last transaction
+}
+
+// Building the chaincode metadata is slow, and the contracts hold no state, so tests share one
+var (
+	testChaincode     *contractapi.ContractChaincode
+	testChaincodeErr  error
+	testChaincodeOnce sync.Once
+)
+
+func newTestLedger(t *testing.T) *testLedger {
+	t.Helper()
+	testChaincodeOnce.Do(func() {
+		testChaincode, testChaincodeErr = newChaincode()
+	})
+	chaincode, err := testChaincode, testChaincodeErr
+	if err != nil {
+		t.Fatalf("failed to create chaincode: %v", err)
+	}
+	stub := shimtest.NewMockStub(testChaincodeName, chaincode)
+	stub.ChannelID = testChannelID
+	return &testLedger{
+		t:             t,
+		stub:          stub,
+		chaincode:     chaincode,
+		chaincodeName: testChaincodeName,
+		identities:    map[string][]byte{},
+		now:           testStartTime,
+	}
+}
+
+// as makes later transactions come from the identity with the given MSP and common name
+func (l *testLedger) as(mspID string, commonName string) *testLedger {
+	key := mspID + "|" + commonName
+	if _, ok := l.identities[key]; !ok {
+		l.identities[key], _ = newTestIdentity(mspID, commonName)
+	}
+	l.stub.Creator = l.identities[key]
+	return l
+}
+
+func (l *testLedger) asCentralBank() *testLedger {
+	return l.as("Org1MSP", "admin@org1.example.com")
+}
+
+func (l *testLedger) asBank(bankID string) *testLedger {
+	return l.as("Org2MSP", bankID+"@org2.example.com")
+}
+
+func (l *testLedger) asUser(userID string) *testLedger {
+	return l.as("Org1MSP", userID+"@org1.example.com")
+}
+
+// invoke submits one transaction. String arguments are passed as is, anything else as JSON.
+func (l *testLedger) invoke(function string, args ...interface{}) (string, error) {
+	l.txCount++
+	txArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		if s, ok := arg.(string); ok {
+			txArgs = append(txArgs, []byte(s))
+			continue
+		}
+		argJSON, err := json.Marshal(arg)
+		if err != nil {
+			l.t.Fatalf("failed to marshal argument %v: %v", arg, err)
+		}
+		txArgs = append(txArgs, argJSON)
+	}
+
+	txID := l.lastTxID()
+	l.stub.MockTransactionStart(txID)
+	l.stub.TxTimestamp = &timestamp.Timestamp{Seconds: l.now}
+	tx := &committingStub{MockStub: l.stub, args: txArgs, writes: map[string][]byte{}, events: map[string][]byte{}}
+	tx.signedProposal = newTestSignedProposal(l.t, l.chaincodeName, txArgs)
+	response := l.chaincode.Invoke(tx)
+	if response.Status == 200 {
+		for key, value := range tx.writes {
+			if value == nil {
+				l.stub.DelState(key)
+			} else {
+				l.stub.PutState(key, value)
+			}
+		}
+	}
+	l.stub.MockTransactionEnd(txID)
+	l.events = tx.events
+
+	if response.Status != 200 {
+		return "", fmt.Errorf("%s", response.Message)
+	}
+	return string(response.Payload), nil
+}
+
+// mustInvoke submits a transaction that has to succeed and returns its response
+func (l *testLedger) mustInvoke(function string, args ...interface{}) string {
+	l.t.Helper()
+	response, err := l.invoke(function, args...)
+	if err != nil {
+		l.t.Fatalf("%s failed: %v", function, err)
+	}
+	return response
+}
+
+// mustFail submits a transaction that has to fail and returns its error message
+func (l *testLedger) mustFail(function string, args ...interface{}) string {
+	l.t.Helper()
+	_, err := l.invoke(function, args...)
+	if err == nil {
+		l.t.Fatalf("%s succeeded, expected an error", function)
+	}
+	return err.Error()
+}
+
+// mustFailWith submits a transaction that has to fail with an error containing want
+func (l *testLedger) mustFailWith(want string, function string, args ...interface{}) {
+	l.t.Helper()
+	message := l.mustFail(function, args...)
+	if !strings.Contains(message, want) {
+		l.t.Fatalf("%s failed with %q, expected %q", function, message, want)
+	}
+}
+
+// mustQuery submits a transaction that has to succeed and decodes its JSON response into v
+func (l *testLedger) mustQuery(v interface{}, function string, args ...interface{}) {
+	l.t.Helper()
+	response := l.mustInvoke(function, args...)
+	err := json.Unmarshal([]byte(response), v)
+	if err != nil {
+		l.t.Fatalf("failed to decode %s response %q: %v", function, response, err)
+	}
+}
+
+// lastTxID returns the ID of the most recent transaction
+func (l *testLedger) lastTxID() string {
+	return fmt.Sprintf("tx%d", l.txCount)
+}
+
+// balance returns an account's balance
+func (l *testLedger) balance(accountID string) float64 {
+	l.t.Helper()
+	var balance AccountBalance
+	l.mustQuery(&balance, "GetBalance", accountID)
+	return balance.Balance
+}
+
+// expectBalance fails the test unless accountID holds want
+func (l *testLedger) expectBalance(accountID string, want float64) {
+	l.t.Helper()
+	if got := l.balance(accountID); got != want {
+		l.t.Fatalf("balance of %s is %.2f, expected %.2f", accountID, got, want)
+	}
+}
+
+// fund issues amount and passes it through bank to userID
+func (l *testLedger) fund(bankID string, userID string, amount float64) {
+	l.t.Helper()
+	l.asCentralBank().mustInvoke("IssueTokens", amount)
+	l.mustInvoke("TransferToCB", bankID, amount)
+	l.asBank(bankID).mustInvoke("TransferToUser", userID, amount)
+}
+
+// link makes bankID the servicing bank of userID, offered by the bank and accepted by the user
+func (l *testLedger) link(bankID string, userID string) {
+	l.t.Helper()
+	l.asBank(bankID).mustInvoke("OfferServicing", userID)
+	l.asUser(userID).mustInvoke("AcceptServicingBank", bankID)
+}
+
+// committingStub buffers a transaction's writes and events until the ledger commits them
+type committingStub struct {
+	*shimtest.MockStub
+	args           [][]byte
+	writes         map[string][]byte // A nil value marks a deleted key
+	events         map[string][]byte
+	signedProposal *peer.SignedProposal
+}
+
+func (s *committingStub) GetState(key string) ([]byte, error) {
+	return s.MockStub.GetState(key)
+}
+
+func (s *committingStub) PutState(key string, value []byte) error {
+	s.writes[key] = value
+	return nil
+}
+
+func (s *committingStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+func (s *committingStub) SetEvent(name string, payload []byte) error {
+	s.events[name] = payload
+	return nil
+}
+
+func (s *committingStub) GetSignedProposal() (*peer.SignedProposal, error) {
+	return s.signedProposal, nil
+}
+
+func (s *committingStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *committingStub) GetStringArgs() []string {
+	args := make([]string, 0, len(s.args))
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *committingStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	return args[0], args[1:]
+}
+
+// newTestSignedProposal returns an unsigned proposal invoking chaincodeName with args, enough
+// for the chaincode to read the name it was invoked under
+func newTestSignedProposal(t *testing.T, chaincodeName string, args [][]byte) *peer.SignedProposal {
+	input, err := proto.Marshal(&peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{
+		ChaincodeId: &peer.ChaincodeID{Name: chaincodeName},
+		Input:       &peer.ChaincodeInput{Args: args},
+	}})
+	if err != nil {
+		t.Fatalf("failed to marshal chaincode invocation: %v", err)
+	}
+	payload, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: input})
+	if err != nil {
+		t.Fatalf("failed to marshal proposal payload: %v", err)
+	}
+	proposal, err := proto.Marshal(&peer.Proposal{Payload: payload})
+	if err != nil {
+		t.Fatalf("failed to marshal proposal: %v", err)
+	}
+	return &peer.SignedProposal{ProposalBytes: proposal}
+}
+
+// newTestIdentity returns a serialized identity with a fresh self-signed certificate, and the
+// certificate's PEM. Common names starting with "admin" get the admin node OU, others client.
+func newTestIdentity(mspID string, commonName string) ([]byte, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	ou := "client"
+	if strings.HasPrefix(commonName, "admin") {
+		ou = "admin"
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, OrganizationalUnit: []string{ou}},
+		Issuer:       pkix.Name{CommonName: "ca." + mspID},
+		NotBefore:    time.Unix(testStartTime-365*86400, 0),
+		NotAfter:     time.Unix(testStartTime+10*365*86400, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	identity, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	if err != nil {
+		panic(err)
+	}
+	return identity, string(certPEM)
+}
+
+// transaction reads the first committed history record of a transaction
+func (l *testLedger) transaction(txID string) TransactionHistory {
+	l.t.Helper()
+	return l.transactionLeg(txID, 0)
+}
+
+// transactionLeg reads a committed history record of a transaction by its leg
+func (l *testLedger) transactionLeg(txID string, leg int) TransactionHistory {
+	l.t.Helper()
+	var transaction TransactionHistory
+	transactionJSON, err := l.stub.GetState((&SmartContract{}).getTransactionKey(txID, leg))
+	if err == nil {
+		err = json.Unmarshal(transactionJSON, &transaction)
+	}
+	if err != nil {
+		l.t.Fatalf("failed to read transaction %s: %v", txID, err)
+	}
+	return transaction
+}
@@ -0,0 +1,626 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// recoveryWaitingPeriod is how long a bank-assisted recovery waits before it can complete,
+// giving the account's current identities time to object
+const recoveryWaitingPeriod = 3 * secondsPerDay
+
+// Ways an identity can be bound to an account
+const (
+	BindPublicKey    = "PublicKey"    // The certificate's public key; survives a change of name
+	BindEnrollmentID = "EnrollmentID" // The MSP and enrollment ID; survives re-enrollment
+)
+
+// AccountIdentities lists the identities bound to an account. Once an account has bound
+// identities, only they can act for it; before that the certificate CN decides as before.
+type AccountIdentities struct {
+	DocType       string           `json:"docType"`
+	SchemaVersion int              `json:"schemaVersion"`
+	AccountID     string           `json:"accountId"`
+	Identities    []*BoundIdentity `json:"identities"`
+	Pending       []*BoundIdentity `json:"pending,omitempty" metadata:",optional"`  // Added but not yet confirmed by the new identity
+	Recovery      *RecoveryRequest `json:"recovery,omitempty" metadata:",optional"` // Pending bank-assisted recovery
+	ModifiedAt    int64            `json:"modifiedAt"`
+}
+
+// BoundIdentity is one identity that can act for an account
+type BoundIdentity struct {
+	Key     string `json:"key"` // pk:<sha256 of public key> or eid:<MSP ID>:<enrollment ID>
+	Method  string `json:"method"`
+	MSPID   string `json:"mspId"`
+	Subject string `json:"subject"` // Certificate CN, for reference
+	AddedBy string `json:"addedBy"`
+	AddedAt int64  `json:"addedAt"`
+}
+
+// RecoveryRequest is a bank's request to rebind an account to a new identity
+type RecoveryRequest struct {
+	NewIdentity  *BoundIdentity `json:"newIdentity"`
+	RequestedBy  string         `json:"requestedBy"`
+	RequestedAt  int64          `json:"requestedAt"`
+	ExecutableAt int64          `json:"executableAt"`
+}
+
+// IdentityEvent notifies an account's identities of a change to them
+type IdentityEvent struct {
+	AccountID    string `json:"accountId"`
+	Action       string `json:"action"` // Offered, Added, Removed, RecoveryInitiated, RecoveryCancelled, Recovered
+	IdentityKey  string `json:"identityKey"`
+	ChangedBy    string `json:"changedBy"`
+	Timestamp    int64  `json:"timestamp"`
+	ExecutableAt int64  `json:"executableAt,omitempty"`
+}
+
+// RegisterIdentity binds the caller's certificate to its account by method
+func (s *SmartContract) RegisterIdentity(ctx contractapi.TransactionContextInterface, method string) (*AccountIdentities, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	identity, err := s.newBoundIdentity(ctx, cert, mspID, method, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.bindIdentity(ctx, account, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// AddIdentity lets the caller offer a new certificate (PEM) from mspID to its account, e.g.
+// ahead of a certificate rotation. It is bound once the new identity submits ConfirmIdentity,
+// which proves it holds the certificate's key and belongs to mspID. The caller's own
+// certificate is registered by public key first if the account has no bound identities yet.
+func (s *SmartContract) AddIdentity(ctx contractapi.TransactionContextInterface, certPEM string, mspID string, method string) (*AccountIdentities, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := s.parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.newBoundIdentity(ctx, cert, mspID, method, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the caller's access once the account stops falling back to the CN
+	if len(account.Identities) == 0 {
+		callerCert, err := ctx.GetClientIdentity().GetX509Certificate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get client certificate: %v", err)
+		}
+		callerMSPID, err := ctx.GetClientIdentity().GetMSPID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get MSPID: %v", err)
+		}
+		caller, err := s.newBoundIdentity(ctx, callerCert, callerMSPID, BindPublicKey, accountID)
+		if err != nil {
+			return nil, err
+		}
+		err = s.bindIdentity(ctx, account, caller)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	boundTo, err := s.getIdentityAccount(ctx, identity.Key)
+	if err != nil {
+		return nil, err
+	}
+	if boundTo != "" {
+		return nil, fmt.Errorf("identity %s is already bound to an account", identity.Key)
+	}
+	for _, pending := range account.Pending {
+		if pending.Key == identity.Key {
+			return nil, fmt.Errorf("identity %s is already awaiting confirmation", identity.Key)
+		}
+	}
+	account.Pending = append(account.Pending, identity)
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountIdentityChanged", account, "Offered", identity.Key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// ConfirmIdentity binds the caller's certificate to an account that offered it with
+// AddIdentity. Submitting it proves the caller holds the certificate's private key.
+func (s *SmartContract) ConfirmIdentity(ctx contractapi.TransactionContextInterface, accountID string) (*AccountIdentities, error) {
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, pending := range account.Pending {
+		if s.validateCallerIsIdentity(ctx, pending) == nil {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("caller has not been offered to %s", accountID)
+	}
+
+	identity := account.Pending[index]
+	account.Pending = append(account.Pending[:index], account.Pending[index+1:]...)
+	err = s.bindIdentity(ctx, account, identity)
+	if err != nil {
+		return nil, err
+	}
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountIdentityChanged", account, "Added", identity.Key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// RemoveIdentity unbinds one of the caller's account identities, such as a retired
+// certificate. The last identity cannot be removed.
+func (s *SmartContract) RemoveIdentity(ctx contractapi.TransactionContextInterface, identityKey string) (*AccountIdentities, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, identity := range account.Identities {
+		if identity.Key == identityKey {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("identity %s is not bound to %s", identityKey, accountID)
+	}
+	if len(account.Identities) == 1 {
+		return nil, fmt.Errorf("cannot remove the last identity of %s", accountID)
+	}
+
+	account.Identities = append(account.Identities[:index], account.Identities[index+1:]...)
+	err = ctx.GetStub().DelState(s.getIdentityKey(identityKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete identity binding: %v", err)
+	}
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountIdentityChanged", account, "Removed", identityKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// InitiateRecovery starts rebinding a customer's account to a new certificate (PEM) from mspID
+// after a lost identity (the account's linked servicing bank only). The new identity completes it once
+// the waiting period has passed without the account objecting.
+func (s *SmartContract) InitiateRecovery(ctx contractapi.TransactionContextInterface, accountID string, certPEM string, mspID string, method string) (*AccountIdentities, error) {
+	bankID, err := s.validateServicingBank(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := s.parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := s.newBoundIdentity(ctx, cert, mspID, method, bankID)
+	if err != nil {
+		return nil, err
+	}
+	boundTo, err := s.getIdentityAccount(ctx, identity.Key)
+	if err != nil {
+		return nil, err
+	}
+	if boundTo != "" {
+		return nil, fmt.Errorf("identity %s is already bound to %s", identity.Key, boundTo)
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Recovery != nil {
+		return nil, fmt.Errorf("a recovery of %s is already pending", accountID)
+	}
+
+	account.Recovery = &RecoveryRequest{
+		NewIdentity:  identity,
+		RequestedBy:  bankID,
+		RequestedAt:  identity.AddedAt,
+		ExecutableAt: identity.AddedAt + recoveryWaitingPeriod,
+	}
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountRecoveryInitiated", account, "RecoveryInitiated", identity.Key, account.Recovery.ExecutableAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// CancelRecovery withdraws a pending recovery (the account itself or the requesting bank)
+func (s *SmartContract) CancelRecovery(ctx contractapi.TransactionContextInterface, accountID string) (*AccountIdentities, error) {
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.Recovery == nil {
+		return nil, fmt.Errorf("no recovery of %s is pending", accountID)
+	}
+	if caller != accountID && caller != account.Recovery.RequestedBy {
+		return nil, fmt.Errorf("caller not authorized to cancel this recovery")
+	}
+
+	identityKey := account.Recovery.NewIdentity.Key
+	account.Recovery = nil
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountRecoveryCancelled", account, "RecoveryCancelled", identityKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// CompleteRecovery replaces the account's identities with the recovered one once the waiting
+// period has passed. It must be submitted by the new identity, proving it holds the key.
+func (s *SmartContract) CompleteRecovery(ctx contractapi.TransactionContextInterface, accountID string) (*AccountIdentities, error) {
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	recovery := account.Recovery
+	if recovery == nil {
+		return nil, fmt.Errorf("no recovery of %s is pending", accountID)
+	}
+	err = s.validateCallerIsIdentity(ctx, recovery.NewIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("only the recovered identity can complete the recovery: %v", err)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now < recovery.ExecutableAt {
+		return nil, fmt.Errorf("recovery of %s can complete from %d", accountID, recovery.ExecutableAt)
+	}
+
+	// Unbind every old identity, then bind the new one
+	for _, identity := range account.Identities {
+		err = ctx.GetStub().DelState(s.getIdentityKey(identity.Key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete identity binding: %v", err)
+		}
+	}
+	account.Identities = []*BoundIdentity{}
+	account.Recovery = nil
+	err = s.bindIdentity(ctx, account, recovery.NewIdentity)
+	if err != nil {
+		return nil, err
+	}
+	err = s.putAccountIdentities(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitIdentityEvent(ctx, "AccountRecovered", account, "Recovered", recovery.NewIdentity.Key, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// GetAccountIdentities returns the identities bound to an account and any pending recovery
+func (s *SmartContract) GetAccountIdentities(ctx contractapi.TransactionContextInterface, accountID string) (*AccountIdentities, error) {
+	return s.getAccountIdentities(ctx, accountID)
+}
+
+// resolveCallerAccount maps the caller's certificate to the account it is bound to. An
+// unbound certificate falls back to its alias or canonical ID, or in Legacy mode to shortName,
+// unless that account has bound identities.
+func (s *SmartContract) resolveCallerAccount(ctx contractapi.TransactionContextInterface, cert *x509.Certificate, shortName string) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	for _, key := range []string{s.publicKeyIdentity(cert), s.enrollmentIdentity(mspID, cert)} {
+		accountID, err := s.getIdentityAccount(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if accountID != "" {
+			return accountID, nil
+		}
+	}
+
+	accountID, err := s.identityAccountID(ctx, cert, mspID, shortName)
+	if err != nil {
+		return "", err
+	}
+
+	account, err := s.getAccountIdentities(ctx, accountID)
+	if err != nil {
+		return "", err
+	}
+	if len(account.Identities) > 0 {
+		return "", fmt.Errorf("account %s only accepts its registered identities", accountID)
+	}
+
+	return accountID, nil
+}
+
+// validateServicingBank checks that the caller is the commercial bank linked to accountID as its
+// servicing bank and returns the bank's ID. Accounts without a link have no servicing bank.
+func (s *SmartContract) validateServicingBank(ctx contractapi.TransactionContextInterface, accountID string) (string, error) {
+	err := s.validateCallerIsCommercialBank(ctx)
+	if err != nil {
+		return "", fmt.Errorf("only the servicing bank of %s can do this: %v", accountID, err)
+	}
+
+	bankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	servicingBankID, err := s.getServicingBank(ctx, accountID)
+	if err != nil {
+		return "", err
+	}
+	if servicingBankID == "" {
+		return "", fmt.Errorf("%s has no servicing bank", accountID)
+	}
+	if servicingBankID != bankID {
+		return "", fmt.Errorf("%s is not the servicing bank of %s", bankID, accountID)
+	}
+
+	return bankID, nil
+}
+
+// validateCallerIsIdentity checks that the caller's certificate is the given identity. The
+// caller's certificate and MSP have been validated by Fabric and the caller signed the proposal.
+func (s *SmartContract) validateCallerIsIdentity(ctx contractapi.TransactionContextInterface, identity *BoundIdentity) error {
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get MSPID: %v", err)
+	}
+
+	if mspID != identity.MSPID {
+		return fmt.Errorf("caller is not a member of %s", identity.MSPID)
+	}
+	if identity.Key != s.publicKeyIdentity(cert) && identity.Key != s.enrollmentIdentity(mspID, cert) {
+		return fmt.Errorf("caller is not identity %s", identity.Key)
+	}
+	return nil
+}
+
+func (s *SmartContract) newBoundIdentity(ctx contractapi.TransactionContextInterface, cert *x509.Certificate, mspID string, method string, addedBy string) (*BoundIdentity, error) {
+	if mspID == "" {
+		return nil, fmt.Errorf("an MSP ID is required")
+	}
+	identity := &BoundIdentity{Method: method, MSPID: mspID, Subject: cert.Subject.CommonName, AddedBy: addedBy}
+	switch method {
+	case BindPublicKey:
+		identity.Key = s.publicKeyIdentity(cert)
+	case BindEnrollmentID:
+		if cert.Subject.CommonName == "" {
+			return nil, fmt.Errorf("binding by enrollment ID needs a certificate CN")
+		}
+		identity.Key = s.enrollmentIdentity(mspID, cert)
+	default:
+		return nil, fmt.Errorf("method must be %s or %s", BindPublicKey, BindEnrollmentID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now < cert.NotBefore.Unix() || now > cert.NotAfter.Unix() {
+		return nil, fmt.Errorf("certificate of %s is not valid at this time", cert.Subject.CommonName)
+	}
+	identity.AddedAt = now
+
+	return identity, nil
+}
+
+// bindIdentity adds identity to the account and indexes it, refusing one bound elsewhere
+func (s *SmartContract) bindIdentity(ctx contractapi.TransactionContextInterface, account *AccountIdentities, identity *BoundIdentity) error {
+	boundTo, err := s.getIdentityAccount(ctx, identity.Key)
+	if err != nil {
+		return err
+	}
+	if boundTo == account.AccountID {
+		return fmt.Errorf("identity %s is already bound to %s", identity.Key, boundTo)
+	}
+	if boundTo != "" {
+		return fmt.Errorf("identity %s is bound to another account", identity.Key)
+	}
+
+	err = ctx.GetStub().PutState(s.getIdentityKey(identity.Key), []byte(account.AccountID))
+	if err != nil {
+		return fmt.Errorf("failed to put identity binding: %v", err)
+	}
+	account.Identities = append(account.Identities, identity)
+	return nil
+}
+
+func (s *SmartContract) emitIdentityEvent(ctx contractapi.TransactionContextInterface, name string, account *AccountIdentities, action string, identityKey string, executableAt int64) error {
+	changedBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	event := IdentityEvent{
+		AccountID:    account.AccountID,
+		Action:       action,
+		IdentityKey:  identityKey,
+		ChangedBy:    changedBy,
+		Timestamp:    now,
+		ExecutableAt: executableAt,
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal identity event: %v", err)
+	}
+	err = ctx.GetStub().SetEvent(name, eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set identity event: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("certificate must be PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	return cert, nil
+}
+
+func (s *SmartContract) publicKeyIdentity(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "pk:" + hex.EncodeToString(sum[:])
+}
+
+func (s *SmartContract) enrollmentIdentity(mspID string, cert *x509.Certificate) string {
+	return "eid:" + mspID + ":" + strings.Split(cert.Subject.CommonName, "@")[0]
+}
+
+func (s *SmartContract) getIdentityKey(identityKey string) string {
+	return "identity_" + identityKey
+}
+
+func (s *SmartContract) getAccountIdentitiesKey(accountID string) string {
+	return "accountidentities_" + accountID
+}
+
+// getIdentityAccount returns the account an identity is bound to, or "" if none
+func (s *SmartContract) getIdentityAccount(ctx contractapi.TransactionContextInterface, identityKey string) (string, error) {
+	accountBytes, err := ctx.GetStub().GetState(s.getIdentityKey(identityKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to read identity binding: %v", err)
+	}
+	return string(accountBytes), nil
+}
+
+// getAccountIdentities returns an empty list for an account without bound identities
+func (s *SmartContract) getAccountIdentities(ctx contractapi.TransactionContextInterface, accountID string) (*AccountIdentities, error) {
+	accountBytes, err := ctx.GetStub().GetState(s.getAccountIdentitiesKey(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account identities: %v", err)
+	}
+
+	account := AccountIdentities{DocType: "accountIdentities", AccountID: accountID, Identities: []*BoundIdentity{}}
+	if accountBytes != nil {
+		err = s.decodeDocument("accountIdentities", accountBytes, &account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal account identities: %v", err)
+		}
+	}
+
+	return &account, nil
+}
+
+func (s *SmartContract) putAccountIdentities(ctx contractapi.TransactionContextInterface, account *AccountIdentities) error {
+	account.ModifiedAt = time.Now().Unix()
+
+	account.SchemaVersion = s.currentSchemaVersion("accountIdentities")
+	accountJSON, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account identities: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getAccountIdentitiesKey(account.AccountID), accountJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put account identities state: %v", err)
+	}
+	return nil
+}
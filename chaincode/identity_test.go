@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+// asSerialized makes later transactions come from a serialized identity
+func (l *testLedger) asSerialized(identity []byte) *testLedger {
+	l.stub.Creator = identity
+	return l
+}
+
+func TestBoundIdentities(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	phone, phonePEM := newTestIdentity("Org1MSP", "alice-phone@org1.example.com")
+	l.asUser("alice").mustFailWith("method must be", "AddIdentity", phonePEM, "Org1MSP", "Fingerprint")
+	var account AccountIdentities
+	l.mustQuery(&account, "AddIdentity", phonePEM, "Org1MSP", BindPublicKey)
+	if len(account.Identities) != 1 || len(account.Pending) != 1 {
+		t.Fatalf("expected the caller to be bound and the new identity offered, got %+v", account)
+	}
+	l.asSerialized(phone).mustFailWith("caller not authorized to transfer", "TransferTokens", "alice", "bob", 10.0)
+
+	// The new certificate proves it holds its key by confirming the offer itself
+	stranger, _ := newTestIdentity("Org1MSP", "mallory@org1.example.com")
+	l.asSerialized(stranger).mustFailWith("caller has not been offered to alice", "ConfirmIdentity", "alice")
+	var confirmed AccountIdentities
+	l.asSerialized(phone).mustQuery(&confirmed, "ConfirmIdentity", "alice")
+	if len(confirmed.Identities) != 2 || len(confirmed.Pending) != 0 {
+		t.Fatalf("expected both identities to be bound, got %+v", confirmed)
+	}
+
+	// The new certificate acts for alice although its CN differs
+	l.asSerialized(phone).mustInvoke("TransferTokens", "alice", "bob", 10.0)
+	l.expectBalance("alice", 90)
+
+	// Another certificate with alice's CN no longer falls back to her account
+	impostor, _ := newTestIdentity("Org1MSP", "alice@org1.example.com")
+	l.asSerialized(impostor).mustFailWith("only accepts its registered identities", "TransferTokens", "alice", "bob", 10.0)
+
+	l.asSerialized(phone).mustInvoke("RemoveIdentity", confirmed.Identities[0].Key)
+	l.asUser("alice").mustFailWith("only accepts its registered identities", "TransferTokens", "alice", "bob", 10.0)
+	l.asSerialized(phone).mustFailWith("cannot remove the last identity", "RemoveIdentity", confirmed.Identities[1].Key)
+}
+
+func TestBankAssistedRecovery(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asUser("alice").mustInvoke("RegisterIdentity", BindPublicKey)
+
+	// Funding alice does not let a bank recover her account; only her linked bank can
+	replacement, replacementPEM := newTestIdentity("Org1MSP", "alice@org1.example.com")
+	l.asBank("bank1").mustFailWith("alice has no servicing bank", "InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+	l.link("bank1", "alice")
+	l.fund("bank2", "alice", 1)
+	l.asUser("bob").mustFailWith("only the servicing bank", "InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+	l.asBank("bank2").mustFailWith("bank2 is not the servicing bank of alice", "InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+	l.asBank("bank1").mustInvoke("InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+	l.mustFailWith("already pending", "InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+
+	l.asSerialized(replacement).mustFailWith("can complete from", "CompleteRecovery", "alice")
+	l.mustFailWith("only accepts its registered identities", "TransferTokens", "alice", "bob", 10.0)
+
+	// Only the new identity completes the recovery, proving it holds the key
+	l.now += recoveryWaitingPeriod
+	l.asBank("bank1").mustFailWith("only the recovered identity can complete the recovery", "CompleteRecovery", "alice")
+	l.asSerialized(replacement).mustInvoke("CompleteRecovery", "alice")
+	l.asSerialized(replacement).mustInvoke("TransferTokens", "alice", "bob", 10.0)
+	l.asUser("alice").mustFailWith("only accepts its registered identities", "TransferTokens", "alice", "bob", 10.0)
+	l.expectBalance("alice", 91)
+}
+
+func TestAccountCancelsRecovery(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.link("bank1", "alice")
+	l.asUser("alice").mustInvoke("RegisterIdentity", BindPublicKey)
+
+	_, replacementPEM := newTestIdentity("Org1MSP", "mallory@org1.example.com")
+	l.asBank("bank1").mustInvoke("InitiateRecovery", "alice", replacementPEM, "Org1MSP", BindPublicKey)
+	l.asUser("bob").mustFailWith("not authorized to cancel", "CancelRecovery", "alice")
+	l.asUser("alice").mustInvoke("CancelRecovery", "alice")
+
+	l.now += recoveryWaitingPeriod
+	l.asUser("mallory").mustFailWith("no recovery of alice is pending", "CompleteRecovery", "alice")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// interbankPurposeCodes are the ISO 20022 external purpose codes accepted on interbank transfers
+var interbankPurposeCodes = map[string]string{
+	"CASH": "Cash management",
+	"CORT": "Trade settlement",
+	"INTC": "Intra-company payment",
+	"INTE": "Interest",
+	"LOAN": "Loan",
+	"SALA": "Salary",
+	"SECU": "Securities",
+	"SUPP": "Supplier payment",
+	"TRAD": "Trade services",
+	"TREA": "Treasury payment",
+	"OTHR": "Other",
+}
+
+// InterbankLimit caps the interbank transfers a commercial bank can send. The record with an
+// empty BankID is the default for banks without their own.
+type InterbankLimit struct {
+	DocType           string  `json:"docType"`
+	SchemaVersion     int     `json:"schemaVersion"`
+	BankID            string  `json:"bankId"`
+	MaxPerTransaction float64 `json:"maxPerTransaction"` // 0 means no limit
+	DailyLimit        float64 `json:"dailyLimit"`        // 0 means no limit
+	SetBy             string  `json:"setBy"`
+	ModifiedAt        int64   `json:"modifiedAt"`
+}
+
+// InterbankUsage totals the interbank transfers sent by a bank on one day (UTC)
+type InterbankUsage struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	BankID        string  `json:"bankId"`
+	Date          string  `json:"date"` // YYYY-MM-DD
+	Amount        float64 `json:"amount"`
+	Count         int     `json:"count"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// TransferBetweenBanks transfers CBDC from the calling commercial bank to another commercial bank.
+// purposeCode is optional and must be a supported ISO 20022 purpose code when given.
+func (s *SmartContract) TransferBetweenBanks(ctx contractapi.TransactionContextInterface, toBankID string, amount float64, purposeCode string) error {
+	// Validate that caller is a commercial bank
+	err := s.validateCallerIsCommercialBank(ctx)
+	if err != nil {
+		return fmt.Errorf("only commercial banks can transfer between banks: %v", err)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.validateCommercialBank(ctx, caller)
+	if err != nil {
+		return fmt.Errorf("caller is not a registered bank identity: %v", err)
+	}
+
+	err = s.validateCommercialBank(ctx, toBankID)
+	if err != nil {
+		return fmt.Errorf("invalid commercial bank ID: %v", err)
+	}
+
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if _, ok := interbankPurposeCodes[purposeCode]; purposeCode != "" && !ok {
+		return fmt.Errorf("unsupported purpose code %s", purposeCode)
+	}
+
+	// Enforce the sending bank's limits
+	err = s.useInterbankLimit(ctx, caller, amount)
+	if err != nil {
+		return err
+	}
+
+	err = s.moveFunds(ctx, caller, toBankID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Charge any interbank fee set for the sending bank
+	fee, err := s.chargeFee(ctx, "InterbankTransfer", caller, caller, toBankID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Record transaction
+	transaction := s.newTransaction(ctx, caller, toBankID, amount, "InterbankTransfer")
+	transaction.PurposeCode = purposeCode
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
+	}
+	err = s.putTransaction(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	// Release any payments the receiving bank has queued
+	return s.releaseQueuedPayments(ctx, toBankID)
+}
+
+// SetInterbankLimit sets the interbank transfer limits of a bank, or the default limits when
+// bankID is empty (Central Bank only)
+func (s *SmartContract) SetInterbankLimit(ctx contractapi.TransactionContextInterface, bankID string, maxPerTransaction float64, dailyLimit float64) (*InterbankLimit, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can set interbank limits: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetInterbankLimit")
+	if err != nil {
+		return nil, err
+	}
+
+	if bankID != "" {
+		err = s.validateCommercialBank(ctx, bankID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commercial bank ID: %v", err)
+		}
+	}
+	if maxPerTransaction < 0 || dailyLimit < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := &InterbankLimit{
+		DocType:           "interbankLimit",
+		BankID:            bankID,
+		MaxPerTransaction: maxPerTransaction,
+		DailyLimit:        dailyLimit,
+		SetBy:             setBy,
+		ModifiedAt:        time.Now().Unix(),
+	}
+
+	limit.SchemaVersion = s.currentSchemaVersion("interbankLimit")
+	limitJSON, err := json.Marshal(limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal interbank limit: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getInterbankLimitKey(bankID), limitJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put interbank limit state: %v", err)
+	}
+
+	return limit, nil
+}
+
+// GetInterbankLimit returns the limits that apply to a bank, falling back to the default limits
+func (s *SmartContract) GetInterbankLimit(ctx contractapi.TransactionContextInterface, bankID string) (*InterbankLimit, error) {
+	limit, err := s.getInterbankLimit(ctx, bankID)
+	if err != nil {
+		return nil, err
+	}
+	if limit == nil {
+		return nil, fmt.Errorf("no interbank limit applies to %s", bankID)
+	}
+	return limit, nil
+}
+
+// GetInterbankUsage returns the interbank transfers sent by a bank on a day (YYYY-MM-DD, UTC)
+func (s *SmartContract) GetInterbankUsage(ctx contractapi.TransactionContextInterface, bankID string, date string) (*InterbankUsage, error) {
+	return s.getInterbankUsage(ctx, bankID, date)
+}
+
+// GetPurposeCodes returns the supported interbank purpose codes and their descriptions
+func (s *SmartContract) GetPurposeCodes(ctx contractapi.TransactionContextInterface) (map[string]string, error) {
+	return interbankPurposeCodes, nil
+}
+
+// useInterbankLimit checks amount against the bank's limits and adds it to today's usage
+func (s *SmartContract) useInterbankLimit(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	limit, err := s.getInterbankLimit(ctx, bankID)
+	if err != nil || limit == nil {
+		return err
+	}
+
+	if limit.MaxPerTransaction > 0 && amount > limit.MaxPerTransaction {
+		return fmt.Errorf("amount exceeds the interbank limit of %.2f per transaction", limit.MaxPerTransaction)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	usage, err := s.getInterbankUsage(ctx, bankID, time.Unix(now, 0).UTC().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	if limit.DailyLimit > 0 && usage.Amount+amount > limit.DailyLimit {
+		return fmt.Errorf("amount exceeds the remaining daily interbank limit of %.2f", limit.DailyLimit-usage.Amount)
+	}
+
+	usage.Amount += amount
+	usage.Count++
+	usage.ModifiedAt = time.Now().Unix()
+
+	usage.SchemaVersion = s.currentSchemaVersion("interbankUsage")
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interbank usage: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getInterbankUsageKey(bankID, usage.Date), usageJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put interbank usage state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getInterbankLimitKey(bankID string) string {
+	return "iblimit_" + bankID
+}
+
+func (s *SmartContract) getInterbankUsageKey(bankID string, date string) string {
+	return "ibusage_" + bankID + "_" + date
+}
+
+// getInterbankLimit returns the bank's own limits, the default limits, or nil if neither is set
+func (s *SmartContract) getInterbankLimit(ctx contractapi.TransactionContextInterface, bankID string) (*InterbankLimit, error) {
+	for _, key := range []string{s.getInterbankLimitKey(bankID), s.getInterbankLimitKey("")} {
+		limitBytes, err := ctx.GetStub().GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read interbank limit: %v", err)
+		}
+		if limitBytes == nil {
+			continue
+		}
+
+		var limit InterbankLimit
+		err = s.decodeDocument("interbankLimit", limitBytes, &limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal interbank limit: %v", err)
+		}
+		return &limit, nil
+	}
+
+	return nil, nil
+}
+
+func (s *SmartContract) getInterbankUsage(ctx contractapi.TransactionContextInterface, bankID string, date string) (*InterbankUsage, error) {
+	usageBytes, err := ctx.GetStub().GetState(s.getInterbankUsageKey(bankID, date))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interbank usage: %v", err)
+	}
+
+	usage := InterbankUsage{DocType: "interbankUsage", BankID: bankID, Date: date}
+	if usageBytes != nil {
+		err = s.decodeDocument("interbankUsage", usageBytes, &usage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal interbank usage: %v", err)
+		}
+	}
+
+	return &usage, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestTransferBetweenBanks(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+
+	l.asUser("alice").mustFailWith("only commercial banks", "TransferBetweenBanks", "bank2", 10.0, "")
+	l.asBank("bank1").mustFailWith("unsupported purpose code", "TransferBetweenBanks", "bank2", 10.0, "XXXX")
+	l.mustFailWith("invalid commercial bank ID", "TransferBetweenBanks", "alice", 10.0, "")
+	l.mustInvoke("TransferBetweenBanks", "bank2", 25.0, "TREA")
+	transferTxID := l.lastTxID()
+
+	l.expectBalance("bank1", 75)
+	l.expectBalance("bank2", 25)
+	if transfer := l.transaction(transferTxID); transfer.Type != "InterbankTransfer" || transfer.PurposeCode != "TREA" {
+		t.Fatalf("unexpected interbank transaction %+v", transfer)
+	}
+}
+
+func TestInterbankLimits(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 200.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+	l.mustInvoke("TransferToCB", "bank3", 100.0)
+
+	l.asBank("bank1").mustFailWith("only central bank", "SetInterbankLimit", "bank1", 100.0, 100.0)
+	l.asCentralBank().mustInvoke("SetInterbankLimit", "", 50.0, 60.0)
+	l.mustInvoke("SetInterbankLimit", "bank3", 0.0, 0.0)
+
+	l.asBank("bank1").mustFailWith("per transaction", "TransferBetweenBanks", "bank2", 55.0, "")
+	l.mustInvoke("TransferBetweenBanks", "bank2", 40.0, "")
+	l.mustFailWith("remaining daily interbank limit of 20.00", "TransferBetweenBanks", "bank2", 30.0, "")
+
+	var usage InterbankUsage
+	l.mustQuery(&usage, "GetInterbankUsage", "bank1", "2023-11-14")
+	if usage.Amount != 40 || usage.Count != 1 {
+		t.Fatalf("unexpected interbank usage %+v", usage)
+	}
+
+	// The next day starts from zero, and a bank's own limit overrides the default
+	l.now += secondsPerDay
+	l.mustInvoke("TransferBetweenBanks", "bank2", 50.0, "")
+	l.asBank("bank3").mustInvoke("TransferBetweenBanks", "bank2", 100.0, "")
+	l.expectBalance("bank2", 190)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const secondsPerYear = 365 * 24 * 60 * 60
+
+// RateSchedule is the central bank's tiered remuneration schedule. Rates are annual and
+// simple; holdings up to TierLimit earn TierRate and holdings above it earn AboveTierRate.
+type RateSchedule struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	TierLimit     float64 `json:"tierLimit"`
+	TierRate      float64 `json:"tierRate"`      // Zero or positive
+	AboveTierRate float64 `json:"aboveTierRate"` // Zero or negative (demurrage)
+	EffectiveFrom int64   `json:"effectiveFrom"`
+	SetBy         string  `json:"setBy"`
+}
+
+// AccrualBatchResult reports the progress of an AccrueInterest batch
+type AccrualBatchResult struct {
+	Processed int    `json:"processed"`
+	Bookmark  string `json:"bookmark"` // Empty when all accounts have been covered
+}
+
+// SetRateSchedule sets the remuneration schedule (central bank administrators only). Accrual
+// under the previous schedule stops at this point, so run AccrueInterest first to settle idle
+// accounts.
+func (s *SmartContract) SetRateSchedule(ctx contractapi.TransactionContextInterface, tierLimit float64, tierRate float64, aboveTierRate float64) (*RateSchedule, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can set the rate schedule: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetRateSchedule")
+	if err != nil {
+		return nil, err
+	}
+
+	if tierLimit < 0 {
+		return nil, fmt.Errorf("tier limit must not be negative")
+	}
+	if tierRate < 0 {
+		return nil, fmt.Errorf("tier rate must not be negative")
+	}
+	if aboveTierRate > 0 {
+		return nil, fmt.Errorf("rate above the tier must be zero or negative")
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := &RateSchedule{
+		DocType:       "rateSchedule",
+		TierLimit:     tierLimit,
+		TierRate:      tierRate,
+		AboveTierRate: aboveTierRate,
+		EffectiveFrom: now,
+		SetBy:         setBy,
+	}
+
+	schedule.SchemaVersion = s.currentSchemaVersion("rateSchedule")
+	scheduleJSON, err := json.Marshal(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rate schedule: %v", err)
+	}
+	err = ctx.GetStub().PutState("rate_schedule", scheduleJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put rate schedule state: %v", err)
+	}
+
+	return schedule, nil
+}
+
+// GetRateSchedule returns the current remuneration schedule
+func (s *SmartContract) GetRateSchedule(ctx contractapi.TransactionContextInterface) (*RateSchedule, error) {
+	schedule, err := s.getRateSchedule(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if schedule == nil {
+		return nil, fmt.Errorf("no rate schedule has been set")
+	}
+	return schedule, nil
+}
+
+// AccrueInterest brings up to pageSize accounts up to date, starting from bookmark, so that
+// idle accounts accrue too (central bank administrators only). Pass the returned bookmark to
+// continue; an empty one starts over. The rate schedule applies to the default currency only,
+// so the batch covers default-currency balances and skips the other currencies.
+func (s *SmartContract) AccrueInterest(ctx contractapi.TransactionContextInterface, bookmark string, pageSize int) (*AccrualBatchResult, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can run interest accrual: %v", err)
+	}
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+
+	startKey := bookmark
+	if startKey == "" {
+		startKey = s.getBalanceKey("")
+	}
+
+	// Range pagination is not allowed in update transactions, so page by hand
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, s.getBalanceKeyRangeEnd())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read balances: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	result := &AccrualBatchResult{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next balance: %v", err)
+		}
+
+		if result.Processed == pageSize {
+			result.Bookmark = queryResult.Key
+			break
+		}
+
+		var balance AccountBalance
+		err = s.decodeDocument("balance", queryResult.Value, &balance)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal account balance: %v", err)
+		}
+		balance.Currency = defaultCurrency
+
+		err = s.accrueInterest(ctx, &balance)
+		if err != nil {
+			return nil, err
+		}
+
+		// Persist the new accrual point even when nothing was earned; this books the interest
+		err = s.putAccountBalance(ctx, &balance)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Processed++
+	}
+
+	return result, nil
+}
+
+func (s *SmartContract) getRateSchedule(ctx contractapi.TransactionContextInterface) (*RateSchedule, error) {
+	scheduleBytes, err := ctx.GetStub().GetState("rate_schedule")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate schedule: %v", err)
+	}
+	if scheduleBytes == nil {
+		return nil, nil
+	}
+
+	var schedule RateSchedule
+	err = s.decodeDocument("rateSchedule", scheduleBytes, &schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rate schedule: %v", err)
+	}
+
+	return &schedule, nil
+}
+
+// accrueInterest applies remuneration earned since the balance was last accrued to the balance
+// in memory. The accrual takes effect, and enters the supply, only when the caller writes the
+// balance with putAccountBalance; a balance that is only read accrues again next time.
+func (s *SmartContract) accrueInterest(ctx contractapi.TransactionContextInterface, balance *AccountBalance) error {
+	// The central bank's own account is the source of issuance, not a holding, and
+	// the schedule only covers the default currency
+	if balance.AccountID == s.getCentralBankID() || balance.Currency != defaultCurrency {
+		return nil
+	}
+
+	schedule, err := s.getRateSchedule(ctx)
+	if err != nil {
+		return err
+	}
+	if schedule == nil {
+		return nil
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	from := balance.LastAccruedAt
+	if from < schedule.EffectiveFrom {
+		from = schedule.EffectiveFrom
+	}
+	balance.LastAccruedAt = now
+	if from == 0 || now <= from {
+		return nil
+	}
+
+	holdings := balance.Balance + balance.HeldBalance
+	tiered := math.Min(holdings, schedule.TierLimit)
+	above := math.Max(holdings-schedule.TierLimit, 0)
+	elapsed := float64(now-from) / secondsPerYear
+	interest := (tiered*schedule.TierRate + above*schedule.AboveTierRate) * elapsed
+
+	// Demurrage never takes the available balance below zero
+	if interest < -balance.Balance {
+		interest = -balance.Balance
+	}
+	if interest == 0 {
+		return nil
+	}
+
+	balance.Balance += interest
+	balance.AccruedInterest += interest
+	balance.pendingInterest += interest
+	balance.ModifiedAt = now
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestTieredRemuneration(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.fund("bank1", "bob", 300)
+
+	l.asBank("bank1").mustFailWith("only central bank", "SetRateSchedule", 100.0, 0.5, -0.125)
+	l.asCentralBank().mustFailWith("zero or negative", "SetRateSchedule", 100.0, 0.5, 0.1)
+	l.mustInvoke("SetRateSchedule", 100.0, 0.5, -0.125)
+
+	// Alice earns the tier rate on all of her holdings, Bob pays demurrage above the tier
+	l.now += secondsPerYear
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 50.0)
+	l.expectBalance("alice", 100)
+	l.expectBalance("bob", 375)
+
+	var supply SupplyRecord
+	l.mustQuery(&supply, "GetSupply")
+	if supply.TotalIssued != 400 || supply.InterestPaid != 75 || supply.DemurrageCharged != 0 || supply.TotalSupply != 475 {
+		t.Fatalf("unexpected supply %+v", supply)
+	}
+}
+
+func TestAccrueInterestBatch(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.fund("bank1", "bob", 200)
+
+	l.asCentralBank().mustInvoke("SetRateSchedule", 1000.0, 0.25, 0.0)
+	l.now += secondsPerYear
+
+	l.asBank("bank1").mustFailWith("only central bank", "AccrueInterest", "", 1)
+	l.asCentralBank().mustFailWith("page size must be positive", "AccrueInterest", "", 0)
+
+	bookmark, batches := "", 0
+	for {
+		var result AccrualBatchResult
+		l.mustQuery(&result, "AccrueInterest", bookmark, 1)
+		batches++
+		if result.Bookmark == "" {
+			break
+		}
+		bookmark = result.Bookmark
+	}
+	if batches < 3 {
+		t.Fatalf("AccrueInterest finished in %d batches of one account", batches)
+	}
+
+	var balance AccountBalance
+	l.mustQuery(&balance, "GetBalance", "bob")
+	if balance.Balance != 250 || balance.AccruedInterest != 50 || balance.LastAccruedAt != l.now {
+		t.Fatalf("unexpected balance after accrual %+v", balance)
+	}
+
+	var supply SupplyRecord
+	l.mustQuery(&supply, "GetSupply")
+	if supply.InterestPaid != 75 {
+		t.Fatalf("supply shows %.2f interest paid, expected 75", supply.InterestPaid)
+	}
+}
+
+func TestBalanceQueriesDoNotWrite(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("InitLedger")
+	l.fund("bank1", "alice", 100)
+	l.asCentralBank().mustInvoke("SetRateSchedule", 1000.0, 0.5, 0.0)
+	l.now += secondsPerYear
+
+	stored := string(l.stub.State["balance_alice"])
+	var balance AccountBalance
+	l.asUser("alice").mustQuery(&balance, "GetBalance", "alice")
+	var tokenBalance float64
+	l.mustQuery(&tokenBalance, "token:BalanceOf", "alice")
+	if balance.Balance != 150 || tokenBalance != 150 {
+		t.Fatalf("queries report %.2f and %.2f, expected 150 with interest", balance.Balance, tokenBalance)
+	}
+	if string(l.stub.State["balance_alice"]) != stored {
+		t.Fatalf("a balance query wrote alice's balance")
+	}
+
+	var supply SupplyRecord
+	l.mustQuery(&supply, "GetSupply")
+	if supply.InterestPaid != 0 {
+		t.Fatalf("a balance query booked %.2f interest", supply.InterestPaid)
+	}
+
+	// The interest is booked once alice's balance is written
+	l.mustInvoke("TransferTokens", "alice", "bob", 50.0)
+	l.expectBalance("alice", 100)
+	l.mustQuery(&supply, "GetSupply")
+	if supply.InterestPaid != 50 || supply.TotalSupply != 150 {
+		t.Fatalf("unexpected supply after the transfer %+v", supply)
+	}
+}
+
+func TestSeedSupplyCountsEarlierBalances(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.putLegacyState("balance_carol", `{"docType":"balance","accountId":"carol","balance":40,"modifiedAt":1}`)
+
+	l.asUser("mallory").mustFailWith("only central bank administrators", "SeedSupply")
+	var supply SupplyRecord
+	l.asCentralBank().mustQuery(&supply, "SeedSupply")
+	if supply.SeededSupply != 40 || supply.TotalSupply != 140 || supply.SeededAt != l.now {
+		t.Fatalf("unexpected seeded supply %+v", supply)
+	}
+	l.mustFailWith("already been seeded", "SeedSupply")
+
+	l.asUser("carol").mustInvoke("RedeemTokens", "carol", 10.0)
+	l.mustQuery(&supply, "GetSupply")
+	if supply.TotalSupply != 130 {
+		t.Fatalf("supply is %.2f after redeeming 10, expected 130", supply.TotalSupply)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// IssuancePolicy bounds how much of the default currency IssueTokens can mint. A limit of 0
+// means no limit. MaxOutstanding caps the supply record's total, which includes balances held
+// before supply was tracked, once seeded, and booked interest net of demurrage.
+type IssuancePolicy struct {
+	DocType           string  `json:"docType"`
+	SchemaVersion     int     `json:"schemaVersion"`
+	MaxOutstanding    float64 `json:"maxOutstanding"`    // Cap on the total supply in circulation
+	PeriodBudget      float64 `json:"periodBudget"`      // Cap on issuance within one period
+	PeriodSeconds     int64   `json:"periodSeconds"`     // Length of a budget period, e.g. 86400
+	MaxPerTransaction float64 `json:"maxPerTransaction"` // Cap on a single issuance
+	SetBy             string  `json:"setBy"`
+	ModifiedAt        int64   `json:"modifiedAt"`
+}
+
+// IssuancePeriod totals the tokens issued in one budget period
+type IssuancePeriod struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	PeriodStart   int64   `json:"periodStart"`
+	Issued        float64 `json:"issued"`
+	Count         int     `json:"count"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// IssuanceHeadroom reports how much more can be issued under the issuance policy. Headroom
+// fields are -1 where no limit applies.
+type IssuanceHeadroom struct {
+	Outstanding         float64 `json:"outstanding"`
+	MaxOutstanding      float64 `json:"maxOutstanding"`
+	OutstandingHeadroom float64 `json:"outstandingHeadroom"`
+	PeriodStart         int64   `json:"periodStart"`
+	PeriodEnd           int64   `json:"periodEnd"`
+	PeriodIssued        float64 `json:"periodIssued"`
+	PeriodBudget        float64 `json:"periodBudget"`
+	PeriodHeadroom      float64 `json:"periodHeadroom"`
+	MaxPerTransaction   float64 `json:"maxPerTransaction"`
+	Headroom            float64 `json:"headroom"` // Largest amount IssueTokens would accept now
+}
+
+// SetIssuancePolicy sets the issuance caps (central bank administrators only). Changes must go
+// through an approval proposal, so an approval policy has to be set first, and a cap on the
+// outstanding supply needs the supply seeded with SeedSupply.
+func (s *SmartContract) SetIssuancePolicy(ctx contractapi.TransactionContextInterface, maxOutstanding float64, periodBudget float64, periodSeconds int64, maxPerTransaction float64) (*IssuancePolicy, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can set the issuance policy: %v", err)
+	}
+
+	approvalPolicy, err := s.getApprovalPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if approvalPolicy == nil {
+		return nil, fmt.Errorf("the issuance policy can only be changed through governance; set an approval policy first")
+	}
+	err = s.requireApproval(ctx, "SetIssuancePolicy")
+	if err != nil {
+		return nil, err
+	}
+
+	if maxOutstanding < 0 || periodBudget < 0 || maxPerTransaction < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+	if periodBudget > 0 && periodSeconds <= 0 {
+		return nil, fmt.Errorf("a period budget needs a positive period length")
+	}
+
+	// Without seeding, the supply misses whatever was issued before it was tracked
+	if maxOutstanding > 0 {
+		supply, err := s.getCurrencySupply(ctx, defaultCurrency)
+		if err != nil {
+			return nil, err
+		}
+		if supply.SeededAt == 0 {
+			return nil, fmt.Errorf("seed the supply with SeedSupply before capping it")
+		}
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &IssuancePolicy{
+		DocType:           "issuancePolicy",
+		MaxOutstanding:    maxOutstanding,
+		PeriodBudget:      periodBudget,
+		PeriodSeconds:     periodSeconds,
+		MaxPerTransaction: maxPerTransaction,
+		SetBy:             setBy,
+		ModifiedAt:        now,
+	}
+
+	policy.SchemaVersion = s.currentSchemaVersion("issuancePolicy")
+	policyJSON, err := json.Marshal(policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal issuance policy: %v", err)
+	}
+	err = ctx.GetStub().PutState("issuance_policy", policyJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put issuance policy state: %v", err)
+	}
+
+	return policy, nil
+}
+
+// GetIssuancePolicy returns the current issuance policy
+func (s *SmartContract) GetIssuancePolicy(ctx contractapi.TransactionContextInterface) (*IssuancePolicy, error) {
+	policy, err := s.getIssuancePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("no issuance policy has been set")
+	}
+	return policy, nil
+}
+
+// GetIssuanceHeadroom returns how much more can be issued under the issuance policy
+func (s *SmartContract) GetIssuanceHeadroom(ctx contractapi.TransactionContextInterface) (*IssuanceHeadroom, error) {
+	policy, err := s.getIssuancePolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = &IssuancePolicy{}
+	}
+
+	supply, err := s.getCurrencySupply(ctx, defaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	headroom := &IssuanceHeadroom{
+		Outstanding:         supply.TotalSupply,
+		MaxOutstanding:      policy.MaxOutstanding,
+		OutstandingHeadroom: -1,
+		PeriodBudget:        policy.PeriodBudget,
+		PeriodHeadroom:      -1,
+		MaxPerTransaction:   policy.MaxPerTransaction,
+		Headroom:            -1,
+	}
+
+	limit := func(value float64) {
+		value = math.Max(value, 0)
+		if headroom.Headroom < 0 || value < headroom.Headroom {
+			headroom.Headroom = value
+		}
+	}
+
+	if policy.MaxOutstanding > 0 {
+		headroom.OutstandingHeadroom = math.Max(policy.MaxOutstanding-supply.TotalSupply, 0)
+		limit(headroom.OutstandingHeadroom)
+	}
+
+	if policy.PeriodSeconds > 0 {
+		period, err := s.getIssuancePeriod(ctx, policy)
+		if err != nil {
+			return nil, err
+		}
+		headroom.PeriodStart = period.PeriodStart
+		headroom.PeriodEnd = period.PeriodStart + policy.PeriodSeconds
+		headroom.PeriodIssued = period.Issued
+		if policy.PeriodBudget > 0 {
+			headroom.PeriodHeadroom = math.Max(policy.PeriodBudget-period.Issued, 0)
+			limit(headroom.PeriodHeadroom)
+		}
+	}
+
+	if policy.MaxPerTransaction > 0 {
+		limit(policy.MaxPerTransaction)
+	}
+
+	return headroom, nil
+}
+
+// useIssuanceHeadroom rejects an issuance that would breach the issuance policy and otherwise
+// counts it against the current period's budget
+func (s *SmartContract) useIssuanceHeadroom(ctx contractapi.TransactionContextInterface, amount float64) error {
+	policy, err := s.getIssuancePolicy(ctx)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	if policy.MaxPerTransaction > 0 && amount > policy.MaxPerTransaction {
+		return fmt.Errorf("amount exceeds the issuance limit of %.2f per transaction", policy.MaxPerTransaction)
+	}
+
+	// Interest accrues outside the cap, so once booked it uses up headroom like issuance
+	if policy.MaxOutstanding > 0 {
+		supply, err := s.getCurrencySupply(ctx, defaultCurrency)
+		if err != nil {
+			return err
+		}
+		if supply.TotalSupply+amount > policy.MaxOutstanding {
+			return fmt.Errorf("issuance would take the outstanding supply above its cap of %.2f", policy.MaxOutstanding)
+		}
+	}
+
+	if policy.PeriodSeconds <= 0 {
+		return nil
+	}
+
+	period, err := s.getIssuancePeriod(ctx, policy)
+	if err != nil {
+		return err
+	}
+	if policy.PeriodBudget > 0 && period.Issued+amount > policy.PeriodBudget {
+		return fmt.Errorf("amount exceeds the remaining issuance budget of %.2f for this period", policy.PeriodBudget-period.Issued)
+	}
+
+	period.Issued += amount
+	period.Count++
+	period.ModifiedAt = time.Now().Unix()
+
+	period.SchemaVersion = s.currentSchemaVersion("issuancePeriod")
+	periodJSON, err := json.Marshal(period)
+	if err != nil {
+		return fmt.Errorf("failed to marshal issuance period: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getIssuancePeriodKey(period.PeriodStart), periodJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put issuance period state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getIssuancePeriodKey(periodStart int64) string {
+	return fmt.Sprintf("issuance_period_%d", periodStart)
+}
+
+func (s *SmartContract) getIssuancePolicy(ctx contractapi.TransactionContextInterface) (*IssuancePolicy, error) {
+	policyBytes, err := ctx.GetStub().GetState("issuance_policy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuance policy: %v", err)
+	}
+	if policyBytes == nil {
+		return nil, nil
+	}
+
+	var policy IssuancePolicy
+	err = s.decodeDocument("issuancePolicy", policyBytes, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal issuance policy: %v", err)
+	}
+
+	return &policy, nil
+}
+
+// getIssuancePeriod returns the budget period containing the transaction timestamp. Periods
+// are aligned to multiples of the period length since the Unix epoch.
+func (s *SmartContract) getIssuancePeriod(ctx contractapi.TransactionContextInterface, policy *IssuancePolicy) (*IssuancePeriod, error) {
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	periodStart := now - now%policy.PeriodSeconds
+
+	periodBytes, err := ctx.GetStub().GetState(s.getIssuancePeriodKey(periodStart))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read issuance period: %v", err)
+	}
+
+	period := IssuancePeriod{DocType: "issuancePeriod", PeriodStart: periodStart}
+	if periodBytes != nil {
+		err = s.decodeDocument("issuancePeriod", periodBytes, &period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal issuance period: %v", err)
+		}
+	}
+
+	return &period, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// approve proposes action with args as the central bank and has admin1 and admin2 approve it.
+// It returns the error of the final approval, which runs the action.
+func (l *testLedger) approve(action string, args string) error {
+	l.t.Helper()
+	var proposal Proposal
+	l.asCentralBank().mustQuery(&proposal, "ProposeAction", action, args)
+	l.asOfficer("admin1").mustInvoke("ApproveProposal", proposal.ID)
+	_, err := l.asOfficer("admin2").invoke("ApproveProposal", proposal.ID)
+	return err
+}
+
+// mustApprove passes a proposal whose action has to succeed
+func (l *testLedger) mustApprove(action string, args string) {
+	l.t.Helper()
+	if err := l.approve(action, args); err != nil {
+		l.t.Fatalf("approved %s %s failed: %v", action, args, err)
+	}
+}
+
+func TestIssuancePolicyCaps(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustFailWith("set an approval policy first", "SetIssuancePolicy", 300.0, 250.0, int64(secondsPerDay), 200.0)
+	l.setTestApprovalPolicy()
+	l.asUser("mallory").mustFailWith("only central bank administrators", "SetIssuancePolicy", 300.0, 250.0, int64(secondsPerDay), 200.0)
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "SetIssuancePolicy", 300.0, 250.0, int64(secondsPerDay), 200.0)
+	if err := l.approve("SetIssuancePolicy", `[300, 250, 86400, 200]`); err == nil || !strings.Contains(err.Error(), "seed the supply") {
+		t.Fatalf("an outstanding cap was set on an unseeded supply: %v", err)
+	}
+	l.asCentralBank().mustInvoke("SeedSupply")
+	l.mustApprove("SetIssuancePolicy", `[300, 250, 86400, 200]`)
+
+	if err := l.approve("IssueTokens", `[201]`); err == nil {
+		t.Fatal("issuance above the per-transaction cap succeeded")
+	}
+	l.mustApprove("IssueTokens", `[200]`)
+	if err := l.approve("IssueTokens", `[60]`); err == nil {
+		t.Fatal("issuance above the period budget succeeded")
+	}
+
+	var headroom IssuanceHeadroom
+	l.mustQuery(&headroom, "GetIssuanceHeadroom")
+	if headroom.Outstanding != 200 || headroom.OutstandingHeadroom != 100 || headroom.PeriodHeadroom != 50 || headroom.Headroom != 50 {
+		t.Fatalf("unexpected issuance headroom %+v", headroom)
+	}
+
+	// A new period restores the budget but not the outstanding cap
+	l.now += secondsPerDay
+	if err := l.approve("IssueTokens", `[150]`); err == nil {
+		t.Fatal("issuance above the outstanding cap succeeded")
+	}
+	l.mustApprove("IssueTokens", `[100]`)
+	l.expectBalance("central-bank", 300)
+}
+
+func TestOutstandingCapCountsInterest(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.setTestApprovalPolicy()
+	l.asCentralBank().mustInvoke("SeedSupply")
+	l.mustApprove("SetIssuancePolicy", `[200, 0, 0, 0]`)
+	l.mustApprove("SetRateSchedule", `[1000, 0.5, 0]`)
+
+	l.now += secondsPerYear
+	l.asCentralBank().mustInvoke("AccrueInterest", "", 10)
+
+	var headroom IssuanceHeadroom
+	l.mustQuery(&headroom, "GetIssuanceHeadroom")
+	if headroom.Outstanding != 150 || headroom.OutstandingHeadroom != 50 {
+		t.Fatalf("unexpected headroom after interest %+v", headroom)
+	}
+	if err := l.approve("IssueTokens", `[60]`); err == nil {
+		t.Fatal("issuance above the cap left by interest succeeded")
+	}
+	l.mustApprove("IssueTokens", `[50]`)
+}
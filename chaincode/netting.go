@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// InterbankObligation is a payment owed by one commercial bank to another, settled on a net
+// basis when its settlement cycle closes
+type InterbankObligation struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	ID            string  `json:"id"`
+	CycleID       int     `json:"cycleId"`
+	PayerBankID   string  `json:"payerBankId"`
+	PayeeBankID   string  `json:"payeeBankId"`
+	Amount        float64 `json:"amount"`
+	Reference     string  `json:"reference"`
+	Status        string  `json:"status"`      // Pending, Settled
+	CarriedOver   int     `json:"carriedOver"` // Cycles this obligation was deferred for lack of reserves
+	SubmittedAt   int64   `json:"submittedAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// SettlementCycle is a deferred net settlement cycle and, once closed, its settlement report
+type SettlementCycle struct {
+	DocType           string         `json:"docType"`
+	SchemaVersion     int            `json:"schemaVersion"`
+	ID                int            `json:"id"`
+	Status            string         `json:"status"` // Open, Settled
+	OpenedAt          int64          `json:"openedAt"`
+	ClosedAt          int64          `json:"closedAt"`
+	ClosedBy          string         `json:"closedBy"`
+	ObligationCount   int            `json:"obligationCount"`
+	SettledCount      int            `json:"settledCount"`
+	DeferredCount     int            `json:"deferredCount"`
+	GrossValue        float64        `json:"grossValue"` // Total of settled obligations
+	NetValue          float64        `json:"netValue"`   // Total moved between reserve accounts
+	Positions         []*NetPosition `json:"positions"`
+	InsufficientBanks []string       `json:"insufficientBanks"` // Banks whose payments were deferred to the next cycle
+}
+
+// NetPosition is a bank's multilateral position in a settlement cycle
+type NetPosition struct {
+	BankID     string  `json:"bankId"`
+	Payable    float64 `json:"payable"`
+	Receivable float64 `json:"receivable"`
+	Net        float64 `json:"net"` // Positive when the bank receives
+}
+
+// SubmitInterbankObligation queues a payment from the calling commercial bank to another bank
+// in the open settlement cycle. Reserves move only when the cycle is closed.
+func (s *SmartContract) SubmitInterbankObligation(ctx contractapi.TransactionContextInterface, payeeBankID string, amount float64, reference string) (*InterbankObligation, error) {
+	err := s.validateCallerIsCommercialBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only commercial banks can submit interbank obligations: %v", err)
+	}
+
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	payerBankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validateCommercialBank(ctx, payeeBankID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commercial bank ID: %v", err)
+	}
+	if payeeBankID == payerBankID {
+		return nil, fmt.Errorf("payer and payee banks must differ")
+	}
+
+	cycleID, err := s.getOpenSettlementCycleID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	obligation := &InterbankObligation{
+		DocType:     "interbankObligation",
+		ID:          ctx.GetStub().GetTxID(),
+		CycleID:     cycleID,
+		PayerBankID: payerBankID,
+		PayeeBankID: payeeBankID,
+		Amount:      amount,
+		Reference:   reference,
+		Status:      "Pending",
+		SubmittedAt: now,
+		ModifiedAt:  time.Now().Unix(),
+	}
+
+	err = s.putObligation(ctx, obligation)
+	if err != nil {
+		return nil, err
+	}
+
+	return obligation, nil
+}
+
+// CloseSettlementCycle nets the obligations of the open cycle and settles each bank's net
+// position against its reserve balance (Central Bank only). While a net payer cannot cover
+// its position, its outgoing obligations are deferred to the next cycle and the positions
+// are recomputed. A new cycle is opened in the same transaction.
+func (s *SmartContract) CloseSettlementCycle(ctx contractapi.TransactionContextInterface) (*SettlementCycle, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can close settlement cycles: %v", err)
+	}
+
+	closedBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cycle, err := s.getSettlementCycle(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	obligations, err := s.getCycleObligations(ctx, cycle.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude payers that cannot cover their net debit until every remaining position settles
+	deferred := map[string]bool{}
+	var positions map[string]*NetPosition
+	for {
+		positions = s.computeNetPositions(obligations, deferred)
+
+		changed := false
+		for _, bankID := range sortedBankIDs(positions) {
+			position := positions[bankID]
+			if position.Net >= 0 {
+				continue
+			}
+			balance, err := s.getAccountBalance(ctx, bankID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get reserve balance of %s: %v", bankID, err)
+			}
+			if balance.Balance < -position.Net {
+				deferred[bankID] = true
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Move reserves: debit net payers first so no account goes negative
+	bankIDs := sortedBankIDs(positions)
+	sort.SliceStable(bankIDs, func(i, j int) bool {
+		return positions[bankIDs[i]].Net < positions[bankIDs[j]].Net
+	})
+
+	cycle.Positions = []*NetPosition{}
+	leg := 0
+	for _, bankID := range bankIDs {
+		position := positions[bankID]
+		cycle.Positions = append(cycle.Positions, position)
+		if position.Net == 0 {
+			continue
+		}
+
+		balance, err := s.getAccountBalance(ctx, bankID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reserve balance of %s: %v", bankID, err)
+		}
+		balance.Balance += position.Net
+		balance.ModifiedAt = time.Now().Unix()
+		err = s.putAccountBalance(ctx, balance)
+		if err != nil {
+			return nil, err
+		}
+
+		transaction := s.newTransaction(ctx, "", bankID, position.Net, "NetSettlement")
+		if position.Net < 0 {
+			transaction.FromID = bankID
+			transaction.ToID = ""
+			transaction.Amount = -position.Net
+			cycle.NetValue += -position.Net
+		}
+		transaction.Reference = strconv.Itoa(cycle.ID)
+		transaction.Leg = leg
+		leg++
+		err = s.putTransaction(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Mark settled obligations and carry deferred ones into the next cycle
+	nextCycleID := cycle.ID + 1
+	for _, obligation := range obligations {
+		if deferred[obligation.PayerBankID] {
+			err = ctx.GetStub().DelState(s.getObligationKey(obligation.CycleID, obligation.ID))
+			if err != nil {
+				return nil, fmt.Errorf("failed to delete obligation: %v", err)
+			}
+			obligation.CycleID = nextCycleID
+			obligation.CarriedOver++
+			cycle.DeferredCount++
+		} else {
+			obligation.Status = "Settled"
+			cycle.SettledCount++
+			cycle.GrossValue += obligation.Amount
+		}
+		obligation.ModifiedAt = time.Now().Unix()
+		err = s.putObligation(ctx, obligation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cycle.InsufficientBanks = []string{}
+	for bankID := range deferred {
+		cycle.InsufficientBanks = append(cycle.InsufficientBanks, bankID)
+	}
+	sort.Strings(cycle.InsufficientBanks)
+
+	cycle.Status = "Settled"
+	cycle.ObligationCount = len(obligations)
+	cycle.ClosedAt = now
+	cycle.ClosedBy = closedBy
+
+	err = s.putSettlementCycle(ctx, cycle)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &SettlementCycle{
+		DocType:           "settlementCycle",
+		ID:                nextCycleID,
+		Status:            "Open",
+		OpenedAt:          now,
+		Positions:         []*NetPosition{},
+		InsufficientBanks: []string{},
+	}
+	err = s.putSettlementCycle(ctx, next)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState("netcycle_current", []byte(strconv.Itoa(nextCycleID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to put current settlement cycle: %v", err)
+	}
+
+	eventJSON, err := json.Marshal(cycle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal settlement cycle event: %v", err)
+	}
+	err = ctx.GetStub().SetEvent("SettlementCycleClosed", eventJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set settlement cycle event: %v", err)
+	}
+
+	return cycle, nil
+}
+
+// GetSettlementCycle returns a settlement cycle and its report; cycle ID 0 returns the open cycle
+func (s *SmartContract) GetSettlementCycle(ctx contractapi.TransactionContextInterface, cycleID int) (*SettlementCycle, error) {
+	return s.getSettlementCycle(ctx, cycleID)
+}
+
+// GetCycleObligations returns the obligations submitted to or carried into a settlement cycle
+func (s *SmartContract) GetCycleObligations(ctx contractapi.TransactionContextInterface, cycleID int) ([]*InterbankObligation, error) {
+	return s.getCycleObligations(ctx, cycleID)
+}
+
+// computeNetPositions sums the pending obligations per bank, skipping those of excluded payers
+func (s *SmartContract) computeNetPositions(obligations []*InterbankObligation, excluded map[string]bool) map[string]*NetPosition {
+	positions := map[string]*NetPosition{}
+	position := func(bankID string) *NetPosition {
+		if positions[bankID] == nil {
+			positions[bankID] = &NetPosition{BankID: bankID}
+		}
+		return positions[bankID]
+	}
+
+	for _, obligation := range obligations {
+		if excluded[obligation.PayerBankID] {
+			continue
+		}
+		position(obligation.PayerBankID).Payable += obligation.Amount
+		position(obligation.PayeeBankID).Receivable += obligation.Amount
+	}
+
+	for _, p := range positions {
+		// Round away float noise so that net positions sum to zero
+		p.Net = math.Round((p.Receivable-p.Payable)*1e8) / 1e8
+	}
+
+	return positions
+}
+
+func sortedBankIDs(positions map[string]*NetPosition) []string {
+	bankIDs := make([]string, 0, len(positions))
+	for bankID := range positions {
+		bankIDs = append(bankIDs, bankID)
+	}
+	sort.Strings(bankIDs)
+	return bankIDs
+}
+
+func (s *SmartContract) getSettlementCycleKey(cycleID int) string {
+	return "netcycle_" + strconv.Itoa(cycleID)
+}
+
+func (s *SmartContract) getObligationKey(cycleID int, obligationID string) string {
+	return fmt.Sprintf("obligation_%d_%s", cycleID, obligationID)
+}
+
+func (s *SmartContract) getOpenSettlementCycleID(ctx contractapi.TransactionContextInterface) (int, error) {
+	currentBytes, err := ctx.GetStub().GetState("netcycle_current")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read current settlement cycle: %v", err)
+	}
+	if currentBytes == nil {
+		return 1, nil
+	}
+	return strconv.Atoi(string(currentBytes))
+}
+
+// getSettlementCycle reads a cycle; ID 0 means the open one, which exists even if never stored
+func (s *SmartContract) getSettlementCycle(ctx contractapi.TransactionContextInterface, cycleID int) (*SettlementCycle, error) {
+	openCycleID, err := s.getOpenSettlementCycleID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cycleID == 0 {
+		cycleID = openCycleID
+	}
+
+	cycleBytes, err := ctx.GetStub().GetState(s.getSettlementCycleKey(cycleID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement cycle: %v", err)
+	}
+	if cycleBytes == nil {
+		if cycleID == openCycleID {
+			return &SettlementCycle{
+				DocType:           "settlementCycle",
+				ID:                cycleID,
+				Status:            "Open",
+				Positions:         []*NetPosition{},
+				InsufficientBanks: []string{},
+			}, nil
+		}
+		return nil, fmt.Errorf("settlement cycle %d does not exist", cycleID)
+	}
+
+	var cycle SettlementCycle
+	err = s.decodeDocument("settlementCycle", cycleBytes, &cycle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal settlement cycle: %v", err)
+	}
+
+	return &cycle, nil
+}
+
+func (s *SmartContract) putSettlementCycle(ctx contractapi.TransactionContextInterface, cycle *SettlementCycle) error {
+	cycle.SchemaVersion = s.currentSchemaVersion("settlementCycle")
+	cycleJSON, err := json.Marshal(cycle)
+	if err != nil {
+		return fmt.Errorf("failed to marshal settlement cycle: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getSettlementCycleKey(cycle.ID), cycleJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put settlement cycle state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getCycleObligations(ctx contractapi.TransactionContextInterface, cycleID int) ([]*InterbankObligation, error) {
+	prefix := s.getObligationKey(cycleID, "")
+	resultsIterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"~")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get obligations: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	obligations := []*InterbankObligation{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next obligation: %v", err)
+		}
+
+		var obligation InterbankObligation
+		err = s.decodeDocument("interbankObligation", queryResult.Value, &obligation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal obligation: %v", err)
+		}
+		obligations = append(obligations, &obligation)
+	}
+
+	return obligations, nil
+}
+
+func (s *SmartContract) putObligation(ctx contractapi.TransactionContextInterface, obligation *InterbankObligation) error {
+	obligation.SchemaVersion = s.currentSchemaVersion("interbankObligation")
+	obligationJSON, err := json.Marshal(obligation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal obligation: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getObligationKey(obligation.CycleID, obligation.ID), obligationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put obligation state: %v", err)
+	}
+	return nil
+}
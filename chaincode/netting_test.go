@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestCloseSettlementCycleNetsObligations(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 150.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+	l.mustInvoke("TransferToCB", "bank2", 50.0)
+
+	l.asUser("alice").mustFailWith("only commercial banks", "SubmitInterbankObligation", "bank2", 10.0, "")
+	l.asBank("bank1").mustFailWith("must differ", "SubmitInterbankObligation", "bank1", 10.0, "")
+	l.mustInvoke("SubmitInterbankObligation", "bank2", 80.0, "batch 1")
+	l.asBank("bank2").mustInvoke("SubmitInterbankObligation", "bank1", 30.0, "batch 2")
+	l.asBank("bank3").mustInvoke("SubmitInterbankObligation", "bank1", 40.0, "batch 3")
+	carriedID := l.lastTxID()
+
+	// Nothing moves until the cycle closes
+	l.expectBalance("bank1", 100)
+
+	l.asBank("bank1").mustFailWith("only central bank", "CloseSettlementCycle")
+	var cycle SettlementCycle
+	l.asCentralBank().mustQuery(&cycle, "CloseSettlementCycle")
+	if cycle.ID != 1 || cycle.SettledCount != 2 || cycle.DeferredCount != 1 || cycle.GrossValue != 110 || cycle.NetValue != 50 {
+		t.Fatalf("unexpected settlement report %+v", cycle)
+	}
+	if len(cycle.InsufficientBanks) != 1 || cycle.InsufficientBanks[0] != "bank3" {
+		t.Fatalf("expected bank3 to be deferred, got %v", cycle.InsufficientBanks)
+	}
+
+	l.expectBalance("bank1", 50)
+	l.expectBalance("bank2", 100)
+	l.expectBalance("bank3", 0)
+
+	var open SettlementCycle
+	l.mustQuery(&open, "GetSettlementCycle", 0)
+	if open.ID != 2 || open.Status != "Open" {
+		t.Fatalf("unexpected open cycle %+v", open)
+	}
+
+	var carried []*InterbankObligation
+	l.mustQuery(&carried, "GetCycleObligations", 2)
+	if len(carried) != 1 || carried[0].ID != carriedID || carried[0].CarriedOver != 1 || carried[0].Status != "Pending" {
+		t.Fatalf("unexpected carried obligations %+v", carried)
+	}
+}
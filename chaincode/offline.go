@@ -0,0 +1,611 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// offlineRedemptionWindow is how long vouchers can still be redeemed after the owner starts
+// closing an offline wallet
+const offlineRedemptionWindow = int64(7 * 24 * 60 * 60)
+
+// OfflineWallet is an allocation of its owner's balance, held on ledger and spendable offline
+// by the device holding the wallet's key
+type OfflineWallet struct {
+	DocType         string  `json:"docType"`
+	SchemaVersion   int     `json:"schemaVersion"`
+	WalletID        string  `json:"walletId"` // Hex SHA-256 of the device public key
+	OwnerID         string  `json:"ownerId"`
+	DevicePublicKey string  `json:"devicePublicKey"` // PEM encoded ECDSA public key
+	Allocated       float64 `json:"allocated"`       // Total ever moved into the wallet
+	Remaining       float64 `json:"remaining"`       // Still held for vouchers
+	Redeemed        float64 `json:"redeemed"`
+	HighestCounter  int64   `json:"highestCounter"`
+	Status          string  `json:"status"`                                 // Active, Closing, Closed
+	CloseAt         int64   `json:"closeAt,omitempty" metadata:",optional"` // End of the redemption window once Closing
+	Flagged         bool    `json:"flagged"`                                // The device signed conflicting or unfunded vouchers
+	DoubleSpends    int     `json:"doubleSpends"`
+	CreatedAt       int64   `json:"createdAt"`
+	ModifiedAt      int64   `json:"modifiedAt"`
+}
+
+// OfflineVoucher is a payment signed offline by a wallet's device. The signature is an ASN.1
+// DER ECDSA signature, base64 encoded, over the SHA-256 of
+// "offline-voucher|<channelId>|<chaincodeName>|<currency>|<walletId>|<counter>|<payeeId>|<amount>",
+// with the amount in shortest decimal form. Each voucher must use a new counter.
+type OfflineVoucher struct {
+	WalletID  string  `json:"walletId"`
+	Counter   int64   `json:"counter"`
+	PayeeID   string  `json:"payeeId"`
+	Amount    float64 `json:"amount"`
+	Signature string  `json:"signature"`
+}
+
+// OfflineVoucherRecord is the first voucher redeemed against a counter, with any conflicting
+// vouchers later presented for the same counter
+type OfflineVoucherRecord struct {
+	DocType       string            `json:"docType"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Voucher       *OfflineVoucher   `json:"voucher"`
+	Status        string            `json:"status"` // Redeemed, Overspend
+	TxID          string            `json:"txId"`
+	RedeemedAt    int64             `json:"redeemedAt"`
+	Conflicts     []*OfflineVoucher `json:"conflicts"`
+}
+
+// OfflineVoucherOutcome reports what happened to one voucher of a redemption
+type OfflineVoucherOutcome struct {
+	WalletID string  `json:"walletId"`
+	Counter  int64   `json:"counter"`
+	Amount   float64 `json:"amount"`
+	Status   string  `json:"status"` // Redeemed, AlreadyRedeemed, DoubleSpend, Overspend, WalletClosed
+}
+
+// OfflineRedemption is the result of RedeemOfflineVouchers
+type OfflineRedemption struct {
+	PayeeID  string                   `json:"payeeId"`
+	Amount   float64                  `json:"amount"` // Total credited to the payee
+	Outcomes []*OfflineVoucherOutcome `json:"outcomes"`
+}
+
+// OpenOfflineWallet holds amount of the caller's balance in a new offline wallet bound to a
+// device's ECDSA public key
+func (s *SmartContract) OpenOfflineWallet(ctx contractapi.TransactionContextInterface, devicePublicKey string, amount float64) (*OfflineWallet, error) {
+	ownerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	walletID, err := s.offlineWalletID(devicePublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := ctx.GetStub().GetState(s.getOfflineWalletKey(walletID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline wallet: %v", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("device key is already bound to offline wallet %s", walletID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet := &OfflineWallet{
+		DocType:         "offlineWallet",
+		WalletID:        walletID,
+		OwnerID:         ownerID,
+		DevicePublicKey: devicePublicKey,
+		Status:          "Active",
+		CreatedAt:       now,
+	}
+
+	err = s.fundOfflineWallet(ctx, wallet, amount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// FundOfflineWallet moves more of the owner's balance into an active offline wallet
+func (s *SmartContract) FundOfflineWallet(ctx contractapi.TransactionContextInterface, walletID string, amount float64) (*OfflineWallet, error) {
+	wallet, err := s.getOwnOfflineWallet(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	if wallet.Status != "Active" {
+		return nil, fmt.Errorf("offline wallet %s is %s", walletID, wallet.Status)
+	}
+	if wallet.Flagged {
+		return nil, fmt.Errorf("offline wallet %s is flagged for double-spending", walletID)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.fundOfflineWallet(ctx, wallet, amount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// CloseOfflineWallet starts closing an offline wallet; called again once the redemption window
+// has passed, it returns the unspent allocation to the owner's available balance
+func (s *SmartContract) CloseOfflineWallet(ctx contractapi.TransactionContextInterface, walletID string) (*OfflineWallet, error) {
+	wallet, err := s.getOwnOfflineWallet(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch wallet.Status {
+	case "Active":
+		// Step 1: Give payees time to come online and redeem outstanding vouchers
+		wallet.Status = "Closing"
+		wallet.CloseAt = now + offlineRedemptionWindow
+	case "Closing":
+		if now < wallet.CloseAt {
+			return nil, fmt.Errorf("offline wallet %s can be closed from %d", walletID, wallet.CloseAt)
+		}
+
+		// Step 2: Release what was not spent
+		balance, err := s.getAccountBalance(ctx, wallet.OwnerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get owner balance: %v", err)
+		}
+		err = s.adjustHold(ctx, balance, -wallet.Remaining)
+		if err != nil {
+			return nil, err
+		}
+		wallet.Remaining = 0
+		wallet.Status = "Closed"
+	default:
+		return nil, fmt.Errorf("offline wallet %s is %s", walletID, wallet.Status)
+	}
+
+	wallet.ModifiedAt = now
+	err = s.putOfflineWallet(ctx, wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	return wallet, nil
+}
+
+// RedeemOfflineVouchers credits the caller with vouchers paid to it offline. Vouchers that
+// reuse a counter or exceed the wallet's allocation are not paid and flag the wallet. Offline
+// payments carry no fee: the device signs a fixed amount out of a fixed allocation without
+// seeing any schedule, and a fee that could not be collected would fail the whole batch.
+func (s *SmartContract) RedeemOfflineVouchers(ctx contractapi.TransactionContextInterface, vouchers []OfflineVoucher) (*OfflineRedemption, error) {
+	payeeID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(vouchers) == 0 {
+		return nil, fmt.Errorf("no vouchers to redeem")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := s.signingDomain(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Redeem in counter order so each wallet's allocation is used up the way it was spent
+	sort.SliceStable(vouchers, func(i, j int) bool {
+		if vouchers[i].WalletID != vouchers[j].WalletID {
+			return vouchers[i].WalletID < vouchers[j].WalletID
+		}
+		return vouchers[i].Counter < vouchers[j].Counter
+	})
+
+	redemption := &OfflineRedemption{PayeeID: payeeID, Outcomes: []*OfflineVoucherOutcome{}}
+	flagged := []*OfflineVoucherOutcome{}
+	for i := range vouchers {
+		voucher := &vouchers[i]
+
+		// Step 1: Check the voucher is addressed to the caller and signed by the wallet's device
+		if voucher.PayeeID != payeeID {
+			return nil, fmt.Errorf("voucher %s/%d is payable to %s", voucher.WalletID, voucher.Counter, voucher.PayeeID)
+		}
+		err = s.validateCurrencyAmount(s.getDefaultCurrency(), voucher.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("voucher %s/%d: %v", voucher.WalletID, voucher.Counter, err)
+		}
+		if voucher.Counter < 1 {
+			return nil, fmt.Errorf("voucher %s/%d: counter must be positive", voucher.WalletID, voucher.Counter)
+		}
+
+		wallet, err := s.getOfflineWallet(ctx, voucher.WalletID)
+		if err != nil {
+			return nil, err
+		}
+		if wallet.OwnerID == payeeID {
+			return nil, fmt.Errorf("voucher %s/%d is payable to the wallet's owner", voucher.WalletID, voucher.Counter)
+		}
+		err = s.verifyOfflineVoucher(domain, wallet, voucher)
+		if err != nil {
+			return nil, err
+		}
+
+		// Step 2: Enforce the counter; a different voucher under a used counter is a double-spend
+		outcome := &OfflineVoucherOutcome{WalletID: voucher.WalletID, Counter: voucher.Counter, Amount: voucher.Amount}
+		redemption.Outcomes = append(redemption.Outcomes, outcome)
+
+		record, err := s.getOfflineVoucherRecord(ctx, voucher.WalletID, voucher.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			if offlineVoucherPayload(domain, record.Voucher) == offlineVoucherPayload(domain, voucher) {
+				outcome.Status = "AlreadyRedeemed"
+				continue
+			}
+
+			outcome.Status = "DoubleSpend"
+			record.Conflicts = append(record.Conflicts, voucher)
+			err = s.putOfflineVoucherRecord(ctx, record)
+			if err != nil {
+				return nil, err
+			}
+			err = s.flagOfflineWallet(ctx, wallet, now)
+			if err != nil {
+				return nil, err
+			}
+			flagged = append(flagged, outcome)
+			continue
+		}
+
+		if wallet.Status == "Closed" {
+			outcome.Status = "WalletClosed"
+			continue
+		}
+
+		record = &OfflineVoucherRecord{
+			DocType:    "offlineVoucher",
+			Voucher:    voucher,
+			TxID:       ctx.GetStub().GetTxID(),
+			RedeemedAt: now,
+			Conflicts:  []*OfflineVoucher{},
+		}
+		if voucher.Counter > wallet.HighestCounter {
+			wallet.HighestCounter = voucher.Counter
+		}
+
+		// Step 3: Enforce the allocation; a device signing beyond it has been tampered with
+		if voucher.Amount > wallet.Remaining+1e-9 {
+			outcome.Status = "Overspend"
+			record.Status = "Overspend"
+			err = s.putOfflineVoucherRecord(ctx, record)
+			if err != nil {
+				return nil, err
+			}
+			err = s.flagOfflineWallet(ctx, wallet, now)
+			if err != nil {
+				return nil, err
+			}
+			flagged = append(flagged, outcome)
+			continue
+		}
+
+		// Step 4: Pay the voucher out of the held allocation
+		ownerBalance, err := s.getAccountBalance(ctx, wallet.OwnerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get owner balance: %v", err)
+		}
+		err = s.adjustHold(ctx, ownerBalance, -voucher.Amount)
+		if err != nil {
+			return nil, err
+		}
+		err = s.moveFunds(ctx, wallet.OwnerID, payeeID, voucher.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		wallet.Remaining -= voucher.Amount
+		wallet.Redeemed += voucher.Amount
+		wallet.ModifiedAt = now
+		err = s.putOfflineWallet(ctx, wallet)
+		if err != nil {
+			return nil, err
+		}
+
+		outcome.Status = "Redeemed"
+		record.Status = "Redeemed"
+		err = s.putOfflineVoucherRecord(ctx, record)
+		if err != nil {
+			return nil, err
+		}
+
+		transaction := s.newTransaction(ctx, wallet.OwnerID, payeeID, voucher.Amount, "OfflinePayment")
+		transaction.Reference = fmt.Sprintf("%s/%d", voucher.WalletID, voucher.Counter)
+		err = s.putTransaction(ctx, transaction)
+		if err != nil {
+			return nil, err
+		}
+
+		redemption.Amount += voucher.Amount
+	}
+
+	// Step 5: Alert the wallets' banks to double-spends
+	if len(flagged) > 0 {
+		eventJSON, err := json.Marshal(flagged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event: %v", err)
+		}
+		err = ctx.GetStub().SetEvent("OfflineDoubleSpendDetected", eventJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set event: %v", err)
+		}
+	}
+
+	if redemption.Amount > 0 {
+		err = s.releaseQueuedPayments(ctx, payeeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return redemption, nil
+}
+
+// GetOfflineWallet returns an offline wallet
+func (s *SmartContract) GetOfflineWallet(ctx contractapi.TransactionContextInterface, walletID string) (*OfflineWallet, error) {
+	return s.getOfflineWallet(ctx, walletID)
+}
+
+// GetOfflineVoucher returns the voucher redeemed against a wallet's counter and any conflicts
+func (s *SmartContract) GetOfflineVoucher(ctx contractapi.TransactionContextInterface, walletID string, counter int64) (*OfflineVoucherRecord, error) {
+	record, err := s.getOfflineVoucherRecord(ctx, walletID, counter)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("no voucher has been redeemed for %s/%d", walletID, counter)
+	}
+	return record, nil
+}
+
+// fundOfflineWallet holds amount of the owner's available balance for the wallet
+func (s *SmartContract) fundOfflineWallet(ctx contractapi.TransactionContextInterface, wallet *OfflineWallet, amount float64, now int64) error {
+	err := s.validateCurrencyAmount(s.getDefaultCurrency(), amount)
+	if err != nil {
+		return err
+	}
+
+	balance, err := s.getAccountBalance(ctx, wallet.OwnerID)
+	if err != nil {
+		return fmt.Errorf("failed to get owner balance: %v", err)
+	}
+	err = s.adjustHold(ctx, balance, amount)
+	if err != nil {
+		return err
+	}
+
+	wallet.Allocated += amount
+	wallet.Remaining += amount
+	wallet.ModifiedAt = now
+
+	return s.putOfflineWallet(ctx, wallet)
+}
+
+func (s *SmartContract) flagOfflineWallet(ctx contractapi.TransactionContextInterface, wallet *OfflineWallet, now int64) error {
+	wallet.Flagged = true
+	wallet.DoubleSpends++
+	wallet.ModifiedAt = now
+	return s.putOfflineWallet(ctx, wallet)
+}
+
+// offlineVoucherPayload is the message a device signs for a voucher, bound to the deployment
+// named by domain and to the default currency
+func offlineVoucherPayload(domain string, voucher *OfflineVoucher) string {
+	return fmt.Sprintf("offline-voucher|%s|%s|%s|%d|%s|%s", domain, defaultCurrency, voucher.WalletID, voucher.Counter,
+		voucher.PayeeID, strconv.FormatFloat(voucher.Amount, 'f', -1, 64))
+}
+
+// signingDomain returns "<channelId>|<chaincodeName>" for the current proposal. Signed payloads
+// include it so that a signature made for one deployment cannot be replayed on another.
+func (s *SmartContract) signingDomain(ctx contractapi.TransactionContextInterface) (string, error) {
+	signedProposal, err := ctx.GetStub().GetSignedProposal()
+	if err != nil {
+		return "", fmt.Errorf("failed to get signed proposal: %v", err)
+	}
+	if signedProposal == nil {
+		return "", fmt.Errorf("transaction has no signed proposal")
+	}
+
+	proposal := &peer.Proposal{}
+	err = proto.Unmarshal(signedProposal.ProposalBytes, proposal)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal proposal: %v", err)
+	}
+	payload := &peer.ChaincodeProposalPayload{}
+	err = proto.Unmarshal(proposal.Payload, payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal proposal payload: %v", err)
+	}
+	invocation := &peer.ChaincodeInvocationSpec{}
+	err = proto.Unmarshal(payload.Input, invocation)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal chaincode invocation: %v", err)
+	}
+
+	chaincodeName := invocation.GetChaincodeSpec().GetChaincodeId().GetName()
+	if chaincodeName == "" {
+		return "", fmt.Errorf("proposal does not name a chaincode")
+	}
+
+	return ctx.GetStub().GetChannelID() + "|" + chaincodeName, nil
+}
+
+func (s *SmartContract) verifyOfflineVoucher(domain string, wallet *OfflineWallet, voucher *OfflineVoucher) error {
+	publicKey, _, err := s.parseECDSAPublicKey(wallet.DevicePublicKey)
+	if err != nil {
+		return fmt.Errorf("offline wallet %s: %v", wallet.WalletID, err)
+	}
+
+	err = s.verifyECDSASignature(publicKey, offlineVoucherPayload(domain, voucher), voucher.Signature)
+	if err != nil {
+		return fmt.Errorf("voucher %s/%d: %v", voucher.WalletID, voucher.Counter, err)
+	}
+	return nil
+}
+
+// offlineWalletID derives a wallet's ID from its device's PEM encoded ECDSA public key
+func (s *SmartContract) offlineWalletID(devicePublicKey string) (string, error) {
+	_, walletID, err := s.parseECDSAPublicKey(devicePublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid device public key: %v", err)
+	}
+	return walletID, nil
+}
+
+// parseECDSAPublicKey parses a PEM encoded ECDSA public key and returns it with its ID, the hex
+// SHA-256 of the encoded key
+func (s *SmartContract) parseECDSAPublicKey(publicKeyPEM string) (*ecdsa.PublicKey, string, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, "", fmt.Errorf("public key must be PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse public key: %v", err)
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("public key must be an ECDSA key")
+	}
+
+	sum := sha256.Sum256(block.Bytes)
+	return publicKey, hex.EncodeToString(sum[:]), nil
+}
+
+// verifyECDSASignature checks a base64 encoded ASN.1 DER signature over the SHA-256 of payload
+func (s *SmartContract) verifyECDSASignature(publicKey *ecdsa.PublicKey, payload string, signature string) error {
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	digest := sha256.Sum256([]byte(payload))
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signatureBytes) {
+		return fmt.Errorf("signature does not verify")
+	}
+	return nil
+}
+
+func (s *SmartContract) getOfflineWalletKey(walletID string) string {
+	return "offlinewallet_" + walletID
+}
+
+func (s *SmartContract) getOfflineVoucherKey(walletID string, counter int64) string {
+	return fmt.Sprintf("offlinevoucher_%s_%020d", walletID, counter)
+}
+
+func (s *SmartContract) getOfflineWallet(ctx contractapi.TransactionContextInterface, walletID string) (*OfflineWallet, error) {
+	walletBytes, err := ctx.GetStub().GetState(s.getOfflineWalletKey(walletID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline wallet: %v", err)
+	}
+	if walletBytes == nil {
+		return nil, fmt.Errorf("offline wallet %s does not exist", walletID)
+	}
+
+	var wallet OfflineWallet
+	err = s.decodeDocument("offlineWallet", walletBytes, &wallet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal offline wallet: %v", err)
+	}
+
+	return &wallet, nil
+}
+
+// getOwnOfflineWallet returns an offline wallet owned by the caller
+func (s *SmartContract) getOwnOfflineWallet(ctx contractapi.TransactionContextInterface, walletID string) (*OfflineWallet, error) {
+	callerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wallet, err := s.getOfflineWallet(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+	if wallet.OwnerID != callerID {
+		return nil, fmt.Errorf("caller does not own offline wallet %s", walletID)
+	}
+
+	return wallet, nil
+}
+
+func (s *SmartContract) putOfflineWallet(ctx contractapi.TransactionContextInterface, wallet *OfflineWallet) error {
+	wallet.SchemaVersion = s.currentSchemaVersion("offlineWallet")
+	walletJSON, err := json.Marshal(wallet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline wallet: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getOfflineWalletKey(wallet.WalletID), walletJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put offline wallet state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) getOfflineVoucherRecord(ctx contractapi.TransactionContextInterface, walletID string, counter int64) (*OfflineVoucherRecord, error) {
+	recordBytes, err := ctx.GetStub().GetState(s.getOfflineVoucherKey(walletID, counter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offline voucher: %v", err)
+	}
+	if recordBytes == nil {
+		return nil, nil
+	}
+
+	var record OfflineVoucherRecord
+	err = s.decodeDocument("offlineVoucher", recordBytes, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal offline voucher: %v", err)
+	}
+
+	return &record, nil
+}
+
+func (s *SmartContract) putOfflineVoucherRecord(ctx contractapi.TransactionContextInterface, record *OfflineVoucherRecord) error {
+	record.SchemaVersion = s.currentSchemaVersion("offlineVoucher")
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offline voucher: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getOfflineVoucherKey(record.Voucher.WalletID, record.Voucher.Counter), recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put offline voucher state: %v", err)
+	}
+	return nil
+}
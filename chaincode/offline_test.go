@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+// testDevice is an offline wallet device holding its signing key
+type testDevice struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	publicPEM string
+}
+
+func newTestDevice(t *testing.T) *testDevice {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate device key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal device key: %v", err)
+	}
+	return &testDevice{t: t, key: key, publicPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}
+}
+
+// sign returns a voucher from walletID signed by the device for the test deployment
+func (d *testDevice) sign(walletID string, counter int64, payeeID string, amount float64) OfflineVoucher {
+	return d.signFor(testSigningDomain, walletID, counter, payeeID, amount)
+}
+
+// signFor returns a voucher from walletID signed by the device for the deployment named by domain
+func (d *testDevice) signFor(domain string, walletID string, counter int64, payeeID string, amount float64) OfflineVoucher {
+	voucher := OfflineVoucher{WalletID: walletID, Counter: counter, PayeeID: payeeID, Amount: amount}
+	digest := sha256.Sum256([]byte(offlineVoucherPayload(domain, &voucher)))
+	signature, err := ecdsa.SignASN1(rand.Reader, d.key, digest[:])
+	if err != nil {
+		d.t.Fatalf("failed to sign voucher: %v", err)
+	}
+	voucher.Signature = base64.StdEncoding.EncodeToString(signature)
+	return voucher
+}
+
+func TestRedeemOfflineVouchers(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var wallet OfflineWallet
+	l.asUser("alice").mustQuery(&wallet, "OpenOfflineWallet", device.publicPEM, 50.0)
+	l.mustFailWith("already bound", "OpenOfflineWallet", device.publicPEM, 10.0)
+	l.expectBalance("alice", 50)
+
+	paid := device.sign(wallet.WalletID, 1, "shop", 20)
+	l.asUser("mallory").mustFailWith("is payable to shop", "RedeemOfflineVouchers", []OfflineVoucher{paid})
+	forged := paid
+	forged.Amount = 45
+	l.asUser("shop").mustFailWith("signature does not verify", "RedeemOfflineVouchers", []OfflineVoucher{forged})
+
+	var redemption OfflineRedemption
+	l.mustQuery(&redemption, "RedeemOfflineVouchers", []OfflineVoucher{paid})
+	if redemption.Amount != 20 || redemption.Outcomes[0].Status != "Redeemed" {
+		t.Fatalf("unexpected redemption %+v", redemption)
+	}
+	l.expectBalance("shop", 20)
+
+	// Presenting the same voucher again pays nothing
+	var again OfflineRedemption
+	l.mustQuery(&again, "RedeemOfflineVouchers", []OfflineVoucher{paid})
+	if again.Amount != 0 || again.Outcomes[0].Status != "AlreadyRedeemed" {
+		t.Fatalf("unexpected repeated redemption %+v", again)
+	}
+	l.expectBalance("shop", 20)
+	l.expectBalance("alice", 50)
+}
+
+func TestOfflineDoubleSpendFlagsWallet(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var wallet OfflineWallet
+	l.asUser("alice").mustQuery(&wallet, "OpenOfflineWallet", device.publicPEM, 30.0)
+
+	var redemption OfflineRedemption
+	l.asUser("shop").mustQuery(&redemption, "RedeemOfflineVouchers", []OfflineVoucher{
+		device.sign(wallet.WalletID, 1, "shop", 20),
+		device.sign(wallet.WalletID, 2, "shop", 20),
+	})
+	if redemption.Amount != 20 || redemption.Outcomes[1].Status != "Overspend" {
+		t.Fatalf("unexpected redemption beyond the allocation %+v", redemption)
+	}
+	l.asUser("cafe").mustQuery(&redemption, "RedeemOfflineVouchers", []OfflineVoucher{device.sign(wallet.WalletID, 1, "cafe", 5)})
+	if redemption.Amount != 0 || redemption.Outcomes[0].Status != "DoubleSpend" {
+		t.Fatalf("unexpected redemption of a reused counter %+v", redemption)
+	}
+	if l.events["OfflineDoubleSpendDetected"] == nil {
+		t.Fatal("no double-spend event was set")
+	}
+
+	l.mustQuery(&wallet, "GetOfflineWallet", wallet.WalletID)
+	if !wallet.Flagged || wallet.DoubleSpends != 2 || wallet.Remaining != 10 {
+		t.Fatalf("unexpected wallet after double-spends %+v", wallet)
+	}
+	l.asUser("alice").mustFailWith("flagged for double-spending", "FundOfflineWallet", wallet.WalletID, 10.0)
+}
+
+func TestCloseOfflineWallet(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	device := newTestDevice(t)
+
+	var wallet OfflineWallet
+	l.asUser("alice").mustQuery(&wallet, "OpenOfflineWallet", device.publicPEM, 30.0)
+	l.asUser("bob").mustFailWith("does not own offline wallet", "CloseOfflineWallet", wallet.WalletID)
+	l.asUser("alice").mustInvoke("CloseOfflineWallet", wallet.WalletID)
+	l.mustFailWith("can be closed from", "CloseOfflineWallet", wallet.WalletID)
+
+	// Vouchers signed before the close can still be redeemed during the window
+	l.asUser("shop").mustInvoke("RedeemOfflineVouchers", []OfflineVoucher{device.sign(wallet.WalletID, 1, "shop", 10)})
+
+	l.now += offlineRedemptionWindow
+	l.asUser("alice").mustQuery(&wallet, "CloseOfflineWallet", wallet.WalletID)
+	if wallet.Status != "Closed" || wallet.Remaining != 0 {
+		t.Fatalf("unexpected closed wallet %+v", wallet)
+	}
+	l.expectBalance("alice", 90)
+	l.expectBalance("shop", 10)
+}
+
+func TestOfflineVoucherIsBoundToTheDeployment(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asCentralBank().mustInvoke("SetFeeSchedule", "Transfer", "", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "fees")
+	device := newTestDevice(t)
+
+	var wallet OfflineWallet
+	l.asUser("alice").mustQuery(&wallet, "OpenOfflineWallet", device.publicPEM, 30.0)
+	if wallet.CreatedAt != l.now || wallet.ModifiedAt != l.now {
+		t.Fatalf("wallet is not stamped with the transaction time %+v", wallet)
+	}
+
+	// A voucher the device signed for another channel or chaincode does not verify here
+	for _, domain := range []string{"otherchannel|" + testChaincodeName, testChannelID + "|othercbdc"} {
+		voucher := device.signFor(domain, wallet.WalletID, 1, "shop", 10)
+		l.asUser("shop").mustFailWith("signature does not verify", "RedeemOfflineVouchers", []OfflineVoucher{voucher})
+	}
+
+	l.chaincodeName = "othercbdc"
+	l.asUser("shop").mustFailWith("signature does not verify", "RedeemOfflineVouchers", []OfflineVoucher{device.sign(wallet.WalletID, 1, "shop", 10)})
+	l.chaincodeName = testChaincodeName
+
+	// Offline payments carry no fee
+	l.asUser("shop").mustInvoke("RedeemOfflineVouchers", []OfflineVoucher{device.sign(wallet.WalletID, 1, "shop", 10)})
+	l.expectBalance("shop", 10)
+	l.expectBalance("alice", 70)
+	l.expectBalance("fees", 0)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Pause scopes. PauseAll halts every state-changing transaction; the others halt one area.
+const (
+	PauseAll       = "all"
+	PauseIssuance  = "issuance"
+	PauseRetail    = "retail"
+	PauseInterbank = "interbank"
+)
+
+// pauseScopes maps the transactions each narrower scope halts. PauseAll needs no list: it halts
+// every transaction that is not exempt.
+var pauseScopes = map[string][]string{
+	PauseIssuance: {"IssueTokens", "IssueCurrency", "AccrueInterest"},
+	PauseRetail: {"TransferTokens", "TransferToUser", "TransferCurrency", "PayQR", "PayRequest", "PayRequestPartial",
+		"CreatePaymentRequest", "CancelPaymentRequest", "ReleaseQueuedPayments", "CancelQueuedPayment", "Defund",
+		"RefundPayment", "ReversePayment", "RedeemTokens", "RedeemCurrency", "OpenDispute", "AttachDisputeEvidence",
+		"ResolveDispute", "ExpireDispute", "Approve", "RevokeAllowance", "TransferFrom", "OpenOfflineWallet",
+		"FundOfflineWallet", "CloseOfflineWallet", "RedeemOfflineVouchers", "RegisterChequeKey", "RevokeChequeKey",
+		"RedeemCheque", "CancelCheque", "OfferServicing", "AcceptServicingBank", "AssignServicingBank", "SetLinkedBank",
+		"RegisterAlias", "AssignAlias", "RegisterIdentity", "ConfirmIdentity", "AddIdentity", "RemoveIdentity",
+		"InitiateRecovery", "CancelRecovery", "CompleteRecovery"},
+	PauseInterbank: {"TransferToCB", "DistributeCurrency", "TransferBetweenBanks", "ReturnToCentralBank", "SubmitInterbankObligation",
+		"CloseSettlementCycle", "ResolveGridlock", "RepayIntradayCredit", "CloseIntradayCredit", "RepayOvernightLoan",
+		"PledgeCollateral", "ReleaseCollateral", "CreateFXOffer", "AcceptFXOffer", "CancelFXOffer", "ExpireFXOffer"},
+}
+
+// pauseAllOnly are the state-changing transactions no narrower scope covers, so only PauseAll
+// halts them: governance and configuration, and QueuePayment, whose payment type decides its scope
+var pauseAllOnly = map[string]bool{
+	"SetApprovalPolicy":      true,
+	"ProposeAction":          true,
+	"ApproveProposal":        true,
+	"RejectProposal":         true,
+	"CancelProposal":         true,
+	"RegisterCurrency":       true,
+	"SetCurrencyStatus":      true,
+	"SetFeeSchedule":         true,
+	"RemoveFeeSchedule":      true,
+	"SetHoldingLimit":        true,
+	"SetIdentityMode":        true,
+	"SetInterbankLimit":      true,
+	"SetIntradayCreditLimit": true,
+	"SetIssuancePolicy":      true,
+	"SetRateSchedule":        true,
+	"SetReserveRequirement":  true,
+	"SeedSupply":             true,
+	"MigrateState":           true,
+	"QueuePayment":           true,
+}
+
+// pauseExempt are the transactions that keep working under every scope: the pause controls
+// and the queries not named Get..., including those of TokenContract
+var pauseExempt = map[string]bool{
+	"Pause":                  true,
+	"Unpause":                true,
+	"SetEmergencyOperators":  true,
+	"InitLedger":             true,
+	"CheckReserveCompliance": true,
+	"ListCurrencies":         true,
+	"VerifyQRPayment":        true,
+	"Allowance":              true,
+	"Name":                   true,
+	"Symbol":                 true,
+	"Decimals":               true,
+	"TotalSupply":            true,
+	"BalanceOf":              true,
+	"ClientAccountID":        true,
+}
+
+// EmergencyRole lists the identities allowed to pause and unpause the contract
+type EmergencyRole struct {
+	DocType       string   `json:"docType"`
+	SchemaVersion int      `json:"schemaVersion"`
+	Operators     []string `json:"operators"`
+	SetBy         string   `json:"setBy"`
+	ModifiedAt    int64    `json:"modifiedAt"`
+}
+
+// PauseState lists the scopes that are currently paused
+type PauseState struct {
+	DocType       string         `json:"docType"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Paused        []*PausedScope `json:"paused"`
+}
+
+// PausedScope is one paused scope and why it was paused
+type PausedScope struct {
+	Scope    string `json:"scope"`
+	Reason   string `json:"reason"`
+	PausedBy string `json:"pausedBy"`
+	PausedAt int64  `json:"pausedAt"`
+}
+
+// PauseEvent records one pause or unpause
+type PauseEvent struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	TxID          string `json:"txId"`
+	Action        string `json:"action"` // Pause, Unpause
+	Scope         string `json:"scope"`
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changedBy"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// SetEmergencyOperators designates the identities that can pause the contract (central bank
+// administrators only). Until operators are designated any central bank administrator can pause.
+func (s *SmartContract) SetEmergencyOperators(ctx contractapi.TransactionContextInterface, operators []string) (*EmergencyRole, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can designate emergency operators: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetEmergencyOperators")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, operator := range operators {
+		if operator == "" || seen[operator] {
+			return nil, fmt.Errorf("operators must be distinct and non-empty")
+		}
+		seen[operator] = true
+	}
+	if len(operators) == 0 {
+		return nil, fmt.Errorf("at least one emergency operator is required")
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	role := &EmergencyRole{
+		DocType:    "emergencyRole",
+		Operators:  operators,
+		SetBy:      setBy,
+		ModifiedAt: now,
+	}
+
+	role.SchemaVersion = s.currentSchemaVersion("emergencyRole")
+	roleJSON, err := json.Marshal(role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal emergency role: %v", err)
+	}
+	err = ctx.GetStub().PutState("emergency_role", roleJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put emergency role state: %v", err)
+	}
+
+	return role, nil
+}
+
+// Pause halts the transactions of a scope (emergency operators only)
+func (s *SmartContract) Pause(ctx contractapi.TransactionContextInterface, scope string, reason string) (*PauseState, error) {
+	return s.changePause(ctx, "Pause", scope, reason)
+}
+
+// Unpause resumes the transactions of a paused scope (emergency operators only)
+func (s *SmartContract) Unpause(ctx contractapi.TransactionContextInterface, scope string, reason string) (*PauseState, error) {
+	return s.changePause(ctx, "Unpause", scope, reason)
+}
+
+// GetPauseState returns the scopes that are currently paused
+func (s *SmartContract) GetPauseState(ctx contractapi.TransactionContextInterface) (*PauseState, error) {
+	return s.getPauseState(ctx)
+}
+
+// GetPauseHistory returns every pause and unpause, oldest first
+func (s *SmartContract) GetPauseHistory(ctx contractapi.TransactionContextInterface) ([]*PauseEvent, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("pauseevent_", "pauseevent`")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pause history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	events := []*PauseEvent{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next pause event: %v", err)
+		}
+
+		var event PauseEvent
+		err = s.decodeDocument("pauseEvent", queryResult.Value, &event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pause event: %v", err)
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// beforeTransaction is the contract's BeforeTransaction hook. It rejects the invoked function
+// when a scope covering it is paused, and lets a state-changing one claim the caller's short name.
+func (s *SmartContract) beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+	err := s.checkNotPaused(ctx, function)
+	if err != nil {
+		return err
+	}
+
+	if pauseExempt[function] || strings.HasPrefix(function, "Get") {
+		return nil
+	}
+	return s.claimShortName(ctx)
+}
+
+// checkNotPaused rejects function if it is a state-changing transaction in a paused scope.
+// Queries are never paused.
+func (s *SmartContract) checkNotPaused(ctx contractapi.TransactionContextInterface, function string) error {
+	paused, err := s.pausedScope(ctx, function)
+	if err != nil {
+		return err
+	}
+	if paused != nil {
+		return fmt.Errorf("%s is paused (%s): %s", function, paused.Scope, paused.Reason)
+	}
+	return nil
+}
+
+// pausedScope returns the paused scope that covers function, or nil if none does
+func (s *SmartContract) pausedScope(ctx contractapi.TransactionContextInterface, function string) (*PausedScope, error) {
+	if pauseExempt[function] || strings.HasPrefix(function, "Get") {
+		return nil, nil
+	}
+
+	state, err := s.getPauseState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, paused := range state.Paused {
+		if paused.Scope == PauseAll || s.scopeCovers(paused.Scope, function) {
+			return paused, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *SmartContract) scopeCovers(scope string, function string) bool {
+	for _, name := range pauseScopes[scope] {
+		if name == function {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SmartContract) changePause(ctx contractapi.TransactionContextInterface, action string, scope string, reason string) (*PauseState, error) {
+	err := s.validateEmergencyOperator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := pauseScopes[scope]; !ok && scope != PauseAll {
+		return nil, fmt.Errorf("unknown pause scope %s", scope)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, fmt.Errorf("a reason is required")
+	}
+
+	changedBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.getPauseState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, paused := range state.Paused {
+		if paused.Scope == scope {
+			index = i
+		}
+	}
+	if action == "Pause" {
+		if index >= 0 {
+			return nil, fmt.Errorf("scope %s is already paused", scope)
+		}
+		state.Paused = append(state.Paused, &PausedScope{Scope: scope, Reason: reason, PausedBy: changedBy, PausedAt: now})
+	} else {
+		if index < 0 {
+			return nil, fmt.Errorf("scope %s is not paused", scope)
+		}
+		state.Paused = append(state.Paused[:index], state.Paused[index+1:]...)
+	}
+
+	state.SchemaVersion = s.currentSchemaVersion("pauseState")
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pause state: %v", err)
+	}
+	err = ctx.GetStub().PutState("pause_state", stateJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put pause state: %v", err)
+	}
+
+	// Record the change with its reason
+	event := PauseEvent{
+		DocType:       "pauseEvent",
+		SchemaVersion: s.currentSchemaVersion("pauseEvent"),
+		TxID:          ctx.GetStub().GetTxID(),
+		Action:        action,
+		Scope:         scope,
+		Reason:        reason,
+		ChangedBy:     changedBy,
+		Timestamp:     now,
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pause event: %v", err)
+	}
+	err = ctx.GetStub().PutState(fmt.Sprintf("pauseevent_%020d_%s", now, event.TxID), eventJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put pause event state: %v", err)
+	}
+	err = ctx.GetStub().SetEvent("ContractPauseChanged", eventJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set pause event: %v", err)
+	}
+
+	return state, nil
+}
+
+// validateEmergencyOperator checks that the caller holds the emergency role
+func (s *SmartContract) validateEmergencyOperator(ctx contractapi.TransactionContextInterface) error {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return fmt.Errorf("only emergency operators can pause the contract: %v", err)
+	}
+
+	roleBytes, err := ctx.GetStub().GetState("emergency_role")
+	if err != nil {
+		return fmt.Errorf("failed to read emergency role: %v", err)
+	}
+	if roleBytes == nil {
+		return nil
+	}
+
+	var role EmergencyRole
+	err = s.decodeDocument("emergencyRole", roleBytes, &role)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal emergency role: %v", err)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	for _, operator := range role.Operators {
+		if operator == caller {
+			return nil
+		}
+	}
+	return fmt.Errorf("caller is not an emergency operator")
+}
+
+func (s *SmartContract) getPauseState(ctx contractapi.TransactionContextInterface) (*PauseState, error) {
+	stateBytes, err := ctx.GetStub().GetState("pause_state")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pause state: %v", err)
+	}
+
+	state := PauseState{DocType: "pauseState", Paused: []*PausedScope{}}
+	if stateBytes != nil {
+		err = s.decodeDocument("pauseState", stateBytes, &state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pause state: %v", err)
+		}
+	}
+
+	return &state, nil
+}
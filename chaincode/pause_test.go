@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func TestPauseScopes(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+
+	l.asBank("bank1").mustFailWith("only emergency operators", "Pause", PauseRetail, "incident")
+	l.asUser("mallory").mustFailWith("only emergency operators", "Pause", PauseAll, "incident")
+	l.asCentralBank().mustFailWith("unknown pause scope", "Pause", "wholesale", "incident")
+	l.mustFailWith("a reason is required", "Pause", PauseRetail, " ")
+	l.mustInvoke("Pause", PauseRetail, "incident 42")
+	l.mustFailWith("already paused", "Pause", PauseRetail, "incident 42")
+
+	l.asUser("alice").mustFailWith("TransferTokens is paused (retail): incident 42", "TransferTokens", "alice", "bob", 10.0)
+	l.expectBalance("alice", 100)
+	l.asCentralBank().mustInvoke("TransferToCB", "bank1", 50.0)
+
+	l.now += 60
+	var state PauseState
+	l.mustQuery(&state, "Unpause", PauseRetail, "resolved")
+	if len(state.Paused) != 0 {
+		t.Fatalf("retail is still paused %+v", state)
+	}
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 10.0)
+
+	var history []*PauseEvent
+	l.mustQuery(&history, "GetPauseHistory")
+	if len(history) != 2 || history[0].Action != "Pause" || history[1].Action != "Unpause" || history[1].ChangedBy != "admin" {
+		t.Fatalf("unexpected pause history %+v", history)
+	}
+}
+
+func TestEmergencyOperatorsPauseAll(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.asUser("mallory").mustFailWith("only central bank administrators", "SetEmergencyOperators", []string{"mallory"})
+	l.asCentralBank().mustFailWith("at least one emergency operator", "SetEmergencyOperators", []string{})
+	l.mustInvoke("SetEmergencyOperators", []string{"admin1"})
+
+	l.mustFailWith("not an emergency operator", "Pause", PauseAll, "incident")
+	l.asOfficer("admin1").mustInvoke("Pause", PauseAll, "incident")
+
+	l.asCentralBank().mustFailWith("IssueTokens is paused (all)", "IssueTokens", 10.0)
+	l.mustFailWith("TransferToCB is paused (all)", "TransferToCB", "bank1", 10.0)
+	l.expectBalance("central-bank", 100)
+
+	l.asOfficer("admin1").mustInvoke("Unpause", PauseAll, "resolved")
+	l.asCentralBank().mustInvoke("TransferToCB", "bank1", 10.0)
+}
+
+func TestPauseCoversDisputesQueueAndInterest(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 20.0)
+	paymentTxID := l.lastTxID()
+	var queued QueuedPayment
+	l.mustQuery(&queued, "QueuePayment", "Transfer", "bob", 500.0, "Normal")
+
+	l.asCentralBank().mustInvoke("Pause", PauseRetail, "incident")
+	l.mustInvoke("Pause", PauseIssuance, "incident")
+	l.asUser("alice").mustFailWith("OpenDispute is paused", "OpenDispute", paymentTxID, 20.0, "not delivered", true)
+	l.mustFailWith("CancelQueuedPayment is paused", "CancelQueuedPayment", queued.ID)
+	l.asCentralBank().mustFailWith("AccrueInterest is paused", "AccrueInterest", "", 10)
+}
+
+func TestEveryTransactionIsClassifiedForPause(t *testing.T) {
+	embedded := reflect.TypeOf(&contractapi.Contract{})
+	for _, contract := range []interface{}{&SmartContract{}, &TokenContract{}} {
+		contractType := reflect.TypeOf(contract)
+		for i := 0; i < contractType.NumMethod(); i++ {
+			name := contractType.Method(i).Name
+			if _, ok := embedded.MethodByName(name); ok {
+				continue
+			}
+
+			classified := 0
+			if pauseExempt[name] || strings.HasPrefix(name, "Get") {
+				classified++
+			}
+			if pauseAllOnly[name] {
+				classified++
+			}
+			for scope := range pauseScopes {
+				if (&SmartContract{}).scopeCovers(scope, name) {
+					classified++
+				}
+			}
+			if classified != 1 {
+				t.Errorf("%s is in %d pause classes, expected exactly one", name, classified)
+			}
+		}
+	}
+}
+
+func TestPauseAllHaltsGovernanceButNotTokenQueries(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("InitLedger")
+	l.fund("bank1", "alice", 100)
+	l.asCentralBank().mustInvoke("Pause", PauseAll, "incident")
+
+	l.mustFailWith("SetApprovalPolicy is paused (all)", "SetApprovalPolicy", []string{"admin1", "admin2"}, 2, 100.0, int64(3600))
+	l.asUser("alice").mustFailWith("Approve is paused (all)", "Approve", "shop", 10.0, int64(0))
+	var balance float64
+	l.mustQuery(&balance, "token:BalanceOf", "alice")
+	if balance != 100 {
+		t.Fatalf("token balance is %.2f during the pause, expected 100", balance)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PaymentRequest represents a merchant invoice that a payer settles by ID
+type PaymentRequest struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	ID            string  `json:"id"`
+	MerchantID    string  `json:"merchantId"`
+	Amount        float64 `json:"amount"`
+	PaidAmount    float64 `json:"paidAmount"`
+	Reference     string  `json:"reference"`
+	ExpiresAt     int64   `json:"expiresAt"` // Unix seconds, 0 means no expiry
+	AllowPartial  bool    `json:"allowPartial"`
+	Status        string  `json:"status"` // Open, PartiallyPaid, Paid, Cancelled
+	CreatedAt     int64   `json:"createdAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// PaymentRequestEvent is emitted whenever a payment request changes status
+type PaymentRequestEvent struct {
+	RequestID  string  `json:"requestId"`
+	MerchantID string  `json:"merchantId"`
+	PayerID    string  `json:"payerId,omitempty"`
+	Amount     float64 `json:"amount"`
+	PaidAmount float64 `json:"paidAmount"`
+	Status     string  `json:"status"`
+}
+
+// CreatePaymentRequest creates a payment request payable to the caller
+func (s *SmartContract) CreatePaymentRequest(ctx contractapi.TransactionContextInterface, amount float64, reference string, expiresAt int64, allowPartial bool) (*PaymentRequest, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	merchantID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt != 0 && expiresAt <= now {
+		return nil, fmt.Errorf("expiry must be in the future")
+	}
+
+	request := &PaymentRequest{
+		DocType:      "paymentRequest",
+		ID:           ctx.GetStub().GetTxID(),
+		MerchantID:   merchantID,
+		Amount:       amount,
+		PaidAmount:   0,
+		Reference:    reference,
+		ExpiresAt:    expiresAt,
+		AllowPartial: allowPartial,
+		Status:       "Open",
+		CreatedAt:    now,
+		ModifiedAt:   now,
+	}
+
+	err = s.putPaymentRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// PayRequest settles the outstanding amount of a payment request from the caller's account
+func (s *SmartContract) PayRequest(ctx contractapi.TransactionContextInterface, requestID string) (*PaymentRequest, error) {
+	request, err := s.getPaymentRequest(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	return s.payRequest(ctx, request, request.Amount-request.PaidAmount)
+}
+
+// PayRequestPartial pays part of a payment request that allows partial payment
+func (s *SmartContract) PayRequestPartial(ctx contractapi.TransactionContextInterface, requestID string, amount float64) (*PaymentRequest, error) {
+	request, err := s.getPaymentRequest(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if !request.AllowPartial {
+		return nil, fmt.Errorf("payment request %s does not allow partial payment", requestID)
+	}
+	return s.payRequest(ctx, request, amount)
+}
+
+// CancelPaymentRequest cancels an open payment request (merchant only)
+func (s *SmartContract) CancelPaymentRequest(ctx contractapi.TransactionContextInterface, requestID string) error {
+	request, err := s.getPaymentRequest(ctx, requestID)
+	if err != nil {
+		return err
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != request.MerchantID {
+		return fmt.Errorf("caller not authorized to cancel this payment request")
+	}
+
+	if request.Status != "Open" && request.Status != "PartiallyPaid" {
+		return fmt.Errorf("payment request %s cannot be cancelled in status %s", requestID, request.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	request.Status = "Cancelled"
+	request.ModifiedAt = now
+
+	err = s.putPaymentRequest(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	return s.emitPaymentRequestEvent(ctx, request, "")
+}
+
+// GetPaymentRequest returns a payment request by ID
+func (s *SmartContract) GetPaymentRequest(ctx contractapi.TransactionContextInterface, requestID string) (*PaymentRequest, error) {
+	return s.getPaymentRequest(ctx, requestID)
+}
+
+func (s *SmartContract) payRequest(ctx contractapi.TransactionContextInterface, request *PaymentRequest, amount float64) (*PaymentRequest, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	if request.Status != "Open" && request.Status != "PartiallyPaid" {
+		return nil, fmt.Errorf("payment request %s is %s", request.ID, request.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if request.ExpiresAt != 0 && now > request.ExpiresAt {
+		return nil, fmt.Errorf("payment request %s has expired", request.ID)
+	}
+
+	outstanding := request.Amount - request.PaidAmount
+	if amount > outstanding {
+		return nil, fmt.Errorf("amount exceeds outstanding %.2f on payment request %s", outstanding, request.ID)
+	}
+
+	payerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.moveFunds(ctx, payerID, request.MerchantID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	request.PaidAmount += amount
+	if request.PaidAmount >= request.Amount {
+		request.Status = "Paid"
+	} else {
+		request.Status = "PartiallyPaid"
+	}
+	request.ModifiedAt = now
+
+	err = s.putPaymentRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := s.chargeUserFee(ctx, "PaymentRequest", payerID, request.MerchantID, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.recordTransactionWithFee(ctx, payerID, request.MerchantID, amount, "PaymentRequest", fee)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.emitPaymentRequestEvent(ctx, request, payerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+func (s *SmartContract) getPaymentRequestKey(requestID string) string {
+	return "payreq_" + requestID
+}
+
+func (s *SmartContract) getPaymentRequest(ctx contractapi.TransactionContextInterface, requestID string) (*PaymentRequest, error) {
+	requestBytes, err := ctx.GetStub().GetState(s.getPaymentRequestKey(requestID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read payment request: %v", err)
+	}
+	if requestBytes == nil {
+		return nil, fmt.Errorf("payment request %s does not exist", requestID)
+	}
+
+	var request PaymentRequest
+	err = s.decodeDocument("paymentRequest", requestBytes, &request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payment request: %v", err)
+	}
+
+	return &request, nil
+}
+
+func (s *SmartContract) putPaymentRequest(ctx contractapi.TransactionContextInterface, request *PaymentRequest) error {
+	request.SchemaVersion = s.currentSchemaVersion("paymentRequest")
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment request: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getPaymentRequestKey(request.ID), requestJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put payment request state: %v", err)
+	}
+	return nil
+}
+
+func (s *SmartContract) emitPaymentRequestEvent(ctx contractapi.TransactionContextInterface, request *PaymentRequest, payerID string) error {
+	event := PaymentRequestEvent{
+		RequestID:  request.ID,
+		MerchantID: request.MerchantID,
+		PayerID:    payerID,
+		Amount:     request.Amount,
+		PaidAmount: request.PaidAmount,
+		Status:     request.Status,
+	}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payment request event: %v", err)
+	}
+	err = ctx.GetStub().SetEvent("PaymentRequestStatusChanged", eventJSON)
+	if err != nil {
+		return fmt.Errorf("failed to set payment request event: %v", err)
+	}
+	return nil
+}
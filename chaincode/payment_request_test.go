@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPaymentRequestPaidInFull(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	var request PaymentRequest
+	l.asUser("shop").mustQuery(&request, "CreatePaymentRequest", 40.0, "invoice 1", int64(0), false)
+	if request.MerchantID != "shop" || request.Status != "Open" || request.ID != l.lastTxID() || request.CreatedAt != testStartTime {
+		t.Fatalf("unexpected payment request %+v", request)
+	}
+
+	l.asUser("alice").mustFailWith("does not allow partial payment", "PayRequestPartial", request.ID, 10.0)
+	l.now += 60
+	l.mustInvoke("PayRequest", request.ID)
+	if payment := l.transaction(l.lastTxID()); payment.Type != "PaymentRequest" || payment.Amount != 40 || payment.Timestamp != testStartTime+60 {
+		t.Fatalf("unexpected payment record %+v", payment)
+	}
+	l.mustFail("PayRequest", request.ID)
+
+	l.expectBalance("alice", 60)
+	l.expectBalance("shop", 40)
+
+	var paid PaymentRequest
+	l.mustQuery(&paid, "GetPaymentRequest", request.ID)
+	if paid.Status != "Paid" || paid.PaidAmount != 40 || paid.ModifiedAt != testStartTime+60 {
+		t.Fatalf("unexpected payment request after payment %+v", paid)
+	}
+	l.mustFailWith("does not exist", "PayRequest", "missing")
+}
+
+func TestPaymentRequestPartialPayments(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	var request PaymentRequest
+	l.asUser("shop").mustQuery(&request, "CreatePaymentRequest", 50.0, "invoice 2", int64(0), true)
+
+	l.asUser("alice").mustInvoke("PayRequestPartial", request.ID, 20.0)
+	var event PaymentRequestEvent
+	err := json.Unmarshal(l.events["PaymentRequestStatusChanged"], &event)
+	if err != nil || event.Status != "PartiallyPaid" || event.PayerID != "alice" || event.PaidAmount != 20 {
+		t.Fatalf("unexpected payment request event %+v (%v)", event, err)
+	}
+
+	l.mustFailWith("exceeds outstanding", "PayRequestPartial", request.ID, 40.0)
+	l.mustFail("PayRequestPartial", request.ID, 0.0)
+	l.mustInvoke("PayRequest", request.ID)
+
+	l.expectBalance("alice", 50)
+	l.expectBalance("shop", 50)
+}
+
+func TestPaymentRequestCancelAndExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("shop").mustFailWith("expiry must be in the future", "CreatePaymentRequest", 10.0, "late", int64(testStartTime), false)
+
+	var request PaymentRequest
+	l.mustQuery(&request, "CreatePaymentRequest", 10.0, "expiring", int64(testStartTime+60), false)
+	l.now += 61
+	l.asUser("alice").mustFailWith("has expired", "PayRequest", request.ID)
+
+	var cancelled PaymentRequest
+	l.asUser("shop").mustQuery(&cancelled, "CreatePaymentRequest", 10.0, "cancelled", int64(0), false)
+	l.asUser("alice").mustFailWith("not authorized", "CancelPaymentRequest", cancelled.ID)
+	l.asUser("shop").mustInvoke("CancelPaymentRequest", cancelled.ID)
+	l.mustFail("CancelPaymentRequest", cancelled.ID)
+	l.asUser("alice").mustFailWith("is Cancelled", "PayRequest", cancelled.ID)
+
+	l.expectBalance("alice", 100)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// FXOffer is a payment-versus-payment offer to sell one currency for another at a quoted rate.
+// The sell leg is locked on the maker's account until the offer is settled, cancelled or expires.
+type FXOffer struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	ID            string  `json:"id"`
+	MakerID       string  `json:"makerId"`
+	TakerID       string  `json:"takerId"` // Empty allows any counterparty
+	SellCurrency  string  `json:"sellCurrency"`
+	SellAmount    float64 `json:"sellAmount"`
+	BuyCurrency   string  `json:"buyCurrency"`
+	BuyAmount     float64 `json:"buyAmount"`
+	Rate          float64 `json:"rate"` // Units of BuyCurrency per unit of SellCurrency
+	ExpiresAt     int64   `json:"expiresAt"`
+	Status        string  `json:"status"` // Open, Settled, Cancelled, Expired
+	SettledBy     string  `json:"settledBy"`
+	SettlementTx  string  `json:"settlementTx"`
+	CreatedAt     int64   `json:"createdAt"`
+	ModifiedAt    int64   `json:"modifiedAt"`
+}
+
+// CreateFXOffer locks sellAmount of sellCurrency on the caller's account and offers it for
+// buyCurrency at rate until expiresAt. A non-empty takerID restricts who can accept.
+func (s *SmartContract) CreateFXOffer(ctx contractapi.TransactionContextInterface, sellCurrency string, sellAmount float64, buyCurrency string, rate float64, takerID string, expiresAt int64) (*FXOffer, error) {
+	if sellCurrency == buyCurrency {
+		return nil, fmt.Errorf("sell and buy currencies must differ")
+	}
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive")
+	}
+
+	sell, err := s.getCurrency(ctx, sellCurrency)
+	if err != nil {
+		return nil, err
+	}
+	buy, err := s.getCurrency(ctx, buyCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if sell.Status != "Active" || buy.Status != "Active" {
+		return nil, fmt.Errorf("both currencies must be Active")
+	}
+
+	err = s.validateCurrencyAmount(sell, sellAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Quote the buy leg in the buy currency's precision
+	scale := math.Pow10(buy.Decimals)
+	buyAmount := math.Round(sellAmount*rate*scale) / scale
+	if buyAmount <= 0 {
+		return nil, fmt.Errorf("buy amount rounds to zero at this rate")
+	}
+
+	makerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if takerID == makerID {
+		return nil, fmt.Errorf("maker cannot be the taker")
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt <= now {
+		return nil, fmt.Errorf("expiry must be in the future")
+	}
+
+	makerBalance, err := s.getCurrencyBalance(ctx, makerID, sellCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get maker balance: %v", err)
+	}
+	err = s.adjustHold(ctx, makerBalance, sellAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &FXOffer{
+		DocType:      "fxOffer",
+		ID:           ctx.GetStub().GetTxID(),
+		MakerID:      makerID,
+		TakerID:      takerID,
+		SellCurrency: sellCurrency,
+		SellAmount:   sellAmount,
+		BuyCurrency:  buyCurrency,
+		BuyAmount:    buyAmount,
+		Rate:         rate,
+		ExpiresAt:    expiresAt,
+		Status:       "Open",
+		CreatedAt:    time.Now().Unix(),
+		ModifiedAt:   time.Now().Unix(),
+	}
+
+	err = s.putFXOffer(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+// AcceptFXOffer settles both legs of an open offer atomically: the caller pays the buy leg to
+// the maker and receives the maker's locked sell leg
+func (s *SmartContract) AcceptFXOffer(ctx contractapi.TransactionContextInterface, offerID string) (*FXOffer, error) {
+	offer, err := s.getFXOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if offer.Status != "Open" {
+		return nil, fmt.Errorf("FX offer %s is %s", offerID, offer.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if now > offer.ExpiresAt {
+		return nil, fmt.Errorf("FX offer %s has expired", offerID)
+	}
+
+	takerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if takerID == offer.MakerID {
+		return nil, fmt.Errorf("maker cannot accept its own offer")
+	}
+	if offer.TakerID != "" && offer.TakerID != takerID {
+		return nil, fmt.Errorf("FX offer %s is reserved for %s", offerID, offer.TakerID)
+	}
+
+	buy, err := s.getCurrency(ctx, offer.BuyCurrency)
+	if err != nil {
+		return nil, err
+	}
+	sell, err := s.getCurrency(ctx, offer.SellCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if sell.Status != "Active" || buy.Status != "Active" {
+		return nil, fmt.Errorf("both currencies must be Active")
+	}
+
+	// Buy leg: taker pays the maker
+	err = s.moveCurrencyFunds(ctx, takerID, offer.MakerID, offer.BuyCurrency, offer.BuyAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sell leg: release the maker's lock and pay the taker
+	err = s.releaseFXLock(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+	err = s.moveCurrencyFunds(ctx, offer.MakerID, takerID, offer.SellCurrency, offer.SellAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	offer.Status = "Settled"
+	offer.SettledBy = takerID
+	offer.SettlementTx = ctx.GetStub().GetTxID()
+	offer.ModifiedAt = time.Now().Unix()
+
+	err = s.putFXOffer(ctx, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	sellLeg := s.newTransaction(ctx, offer.MakerID, takerID, offer.SellAmount, "FXSettlement")
+	sellLeg.Currency = offer.SellCurrency
+	sellLeg.Reference = offer.ID
+	err = s.putTransaction(ctx, sellLeg)
+	if err != nil {
+		return nil, err
+	}
+
+	buyLeg := s.newTransaction(ctx, takerID, offer.MakerID, offer.BuyAmount, "FXSettlement")
+	buyLeg.Currency = offer.BuyCurrency
+	buyLeg.Reference = offer.ID
+	buyLeg.Leg, err = s.nextTransactionLeg(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.putTransaction(ctx, buyLeg)
+	if err != nil {
+		return nil, err
+	}
+
+	return offer, nil
+}
+
+// CancelFXOffer withdraws an open offer and unlocks the sell leg (maker only)
+func (s *SmartContract) CancelFXOffer(ctx contractapi.TransactionContextInterface, offerID string) error {
+	offer, err := s.getFXOffer(ctx, offerID)
+	if err != nil {
+		return err
+	}
+	if offer.Status != "Open" {
+		return fmt.Errorf("FX offer %s is %s", offerID, offer.Status)
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != offer.MakerID {
+		return fmt.Errorf("caller not authorized to cancel this FX offer")
+	}
+
+	return s.closeFXOffer(ctx, offer, "Cancelled")
+}
+
+// ExpireFXOffer unlocks the sell leg of an offer whose expiry has passed (any caller)
+func (s *SmartContract) ExpireFXOffer(ctx contractapi.TransactionContextInterface, offerID string) error {
+	offer, err := s.getFXOffer(ctx, offerID)
+	if err != nil {
+		return err
+	}
+	if offer.Status != "Open" {
+		return fmt.Errorf("FX offer %s is %s", offerID, offer.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if now <= offer.ExpiresAt {
+		return fmt.Errorf("FX offer %s has not expired", offerID)
+	}
+
+	return s.closeFXOffer(ctx, offer, "Expired")
+}
+
+// GetFXOffer returns an FX offer by ID
+func (s *SmartContract) GetFXOffer(ctx contractapi.TransactionContextInterface, offerID string) (*FXOffer, error) {
+	return s.getFXOffer(ctx, offerID)
+}
+
+func (s *SmartContract) closeFXOffer(ctx contractapi.TransactionContextInterface, offer *FXOffer, status string) error {
+	err := s.releaseFXLock(ctx, offer)
+	if err != nil {
+		return err
+	}
+
+	offer.Status = status
+	offer.ModifiedAt = time.Now().Unix()
+
+	return s.putFXOffer(ctx, offer)
+}
+
+func (s *SmartContract) releaseFXLock(ctx contractapi.TransactionContextInterface, offer *FXOffer) error {
+	makerBalance, err := s.getCurrencyBalance(ctx, offer.MakerID, offer.SellCurrency)
+	if err != nil {
+		return fmt.Errorf("failed to get maker balance: %v", err)
+	}
+	return s.adjustHold(ctx, makerBalance, -offer.SellAmount)
+}
+
+func (s *SmartContract) getFXOfferKey(offerID string) string {
+	return "fxoffer_" + offerID
+}
+
+func (s *SmartContract) getFXOffer(ctx contractapi.TransactionContextInterface, offerID string) (*FXOffer, error) {
+	offerBytes, err := ctx.GetStub().GetState(s.getFXOfferKey(offerID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FX offer: %v", err)
+	}
+	if offerBytes == nil {
+		return nil, fmt.Errorf("FX offer %s does not exist", offerID)
+	}
+
+	var offer FXOffer
+	err = s.decodeDocument("fxOffer", offerBytes, &offer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal FX offer: %v", err)
+	}
+
+	return &offer, nil
+}
+
+func (s *SmartContract) putFXOffer(ctx contractapi.TransactionContextInterface, offer *FXOffer) error {
+	offer.SchemaVersion = s.currentSchemaVersion("fxOffer")
+	offerJSON, err := json.Marshal(offer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal FX offer: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getFXOfferKey(offer.ID), offerJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put FX offer state: %v", err)
+	}
+	return nil
+}
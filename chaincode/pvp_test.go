@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestFXOfferSettlesBothLegs(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.registerTestCurrency(1000)
+	l.mustInvoke("DistributeCurrency", "EURC", "bank1", 300.0)
+
+	l.asBank("bank1").mustFailWith("must differ", "CreateFXOffer", "EURC", 100.0, "EURC", 1.0, "", int64(testStartTime+60))
+	l.mustFailWith("expiry must be in the future", "CreateFXOffer", "EURC", 100.0, defaultCurrency, 0.5, "", int64(testStartTime))
+	l.mustFailWith("insufficient available balance", "CreateFXOffer", "EURC", 400.0, defaultCurrency, 0.5, "", int64(testStartTime+60))
+
+	var offer FXOffer
+	l.mustQuery(&offer, "CreateFXOffer", "EURC", 100.0, defaultCurrency, 0.5, "alice", int64(testStartTime+60))
+	if offer.BuyAmount != 50 || offer.Status != "Open" {
+		t.Fatalf("unexpected FX offer %+v", offer)
+	}
+
+	var locked AccountBalance
+	l.mustQuery(&locked, "GetCurrencyBalance", "bank1", "EURC")
+	if locked.Balance != 200 || locked.HeldBalance != 100 {
+		t.Fatalf("unexpected maker balance with the sell leg locked %+v", locked)
+	}
+
+	l.mustFailWith("maker cannot accept", "AcceptFXOffer", offer.ID)
+	l.asUser("bob").mustFailWith("reserved for alice", "AcceptFXOffer", offer.ID)
+	l.asUser("alice").mustInvoke("AcceptFXOffer", offer.ID)
+	l.mustFailWith("is Settled", "AcceptFXOffer", offer.ID)
+
+	var euros AccountBalance
+	l.mustQuery(&euros, "GetCurrencyBalance", "alice", "EURC")
+	if euros.Balance != 100 {
+		t.Fatalf("alice holds %.2f EURC, expected 100", euros.Balance)
+	}
+	var settled AccountBalance
+	l.mustQuery(&settled, "GetCurrencyBalance", "bank1", "EURC")
+	if settled.Balance != 200 || settled.HeldBalance != 0 {
+		t.Fatalf("unexpected maker balance after settlement %+v", settled)
+	}
+	l.expectBalance("alice", 50)
+	l.expectBalance("bank1", 50)
+}
+
+func TestFXOfferCancelAndExpiry(t *testing.T) {
+	l := newTestLedger(t)
+	l.registerTestCurrency(1000)
+	l.mustInvoke("DistributeCurrency", "EURC", "bank1", 300.0)
+
+	var cancelled, expiring FXOffer
+	l.asBank("bank1").mustQuery(&cancelled, "CreateFXOffer", "EURC", 100.0, defaultCurrency, 0.5, "", int64(testStartTime+60))
+	l.mustQuery(&expiring, "CreateFXOffer", "EURC", 200.0, defaultCurrency, 0.5, "", int64(testStartTime+60))
+
+	l.asUser("alice").mustFailWith("not authorized", "CancelFXOffer", cancelled.ID)
+	l.asBank("bank1").mustInvoke("CancelFXOffer", cancelled.ID)
+	l.mustFailWith("has not expired", "ExpireFXOffer", expiring.ID)
+
+	l.now += 61
+	l.asUser("alice").mustFailWith("has expired", "AcceptFXOffer", expiring.ID)
+	l.mustInvoke("ExpireFXOffer", expiring.ID)
+
+	var balance AccountBalance
+	l.mustQuery(&balance, "GetCurrencyBalance", "bank1", "EURC")
+	if balance.Balance != 300 || balance.HeldBalance != 0 {
+		t.Fatalf("unexpected maker balance after the offers closed %+v", balance)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/66571660/fabric-samples/cbdc/chaincode2/qrpay"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GetPaymentRequestQR returns the QR payload a merchant displays for an open payment request
+func (s *SmartContract) GetPaymentRequestQR(ctx contractapi.TransactionContextInterface, requestID string) (string, error) {
+	request, err := s.getPaymentRequest(ctx, requestID)
+	if err != nil {
+		return "", err
+	}
+	if request.Status != "Open" && request.Status != "PartiallyPaid" {
+		return "", fmt.Errorf("payment request %s is %s", request.ID, request.Status)
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return "", err
+	}
+	if request.ExpiresAt != 0 && now > request.ExpiresAt {
+		return "", fmt.Errorf("payment request %s has expired", request.ID)
+	}
+
+	currency, err := s.getCurrency(ctx, defaultCurrency)
+	if err != nil {
+		return "", err
+	}
+
+	return qrpay.Encode(&qrpay.Payload{
+		MerchantAccountID: request.MerchantID,
+		Amount:            request.Amount - request.PaidAmount,
+		Currency:          currency.NumericCode,
+		Reference:         request.ID,
+		ExpiresAt:         request.ExpiresAt,
+	})
+}
+
+// PayQR pays a merchant from a scanned QR payload. merchantID is the payee the wallet showed
+// the payer, and must match the QR merchant. The amount is only used for static codes that
+// carry no amount; pass 0 for dynamic codes. The payment is recorded with the QR reference.
+func (s *SmartContract) PayQR(ctx contractapi.TransactionContextInterface, payload string, merchantID string, amount float64) error {
+	decoded, err := qrpay.Decode(payload)
+	if err != nil {
+		return fmt.Errorf("invalid QR payload: %v", err)
+	}
+
+	if decoded.Amount > 0 && amount == 0 {
+		amount = decoded.Amount
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	currency, err := s.getCurrency(ctx, defaultCurrency)
+	if err != nil {
+		return err
+	}
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	err = decoded.Matches(merchantID, amount, currency.NumericCode, now)
+	if err != nil {
+		return err
+	}
+
+	// A reference that names one of the merchant's payment requests settles that request
+	if decoded.Reference != "" {
+		request, err := s.getPaymentRequest(ctx, decoded.Reference)
+		if err == nil && request.MerchantID == decoded.MerchantAccountID {
+			if amount != request.Amount-request.PaidAmount && !request.AllowPartial {
+				return fmt.Errorf("payment request %s does not allow partial payment", request.ID)
+			}
+			_, err = s.payRequest(ctx, request, amount)
+			if err != nil {
+				return err
+			}
+
+			transaction, err := s.getTransaction(ctx, ctx.GetStub().GetTxID())
+			if err != nil {
+				return err
+			}
+			transaction.Reference = request.ID
+			return s.putTransaction(ctx, transaction)
+		}
+	}
+
+	payerID, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.moveFunds(ctx, payerID, decoded.MerchantAccountID, amount)
+	if err != nil {
+		return err
+	}
+
+	fee, err := s.chargeUserFee(ctx, "QRPayment", payerID, decoded.MerchantAccountID, amount)
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, payerID, decoded.MerchantAccountID, amount, "QRPayment")
+	transaction.Reference = decoded.Reference
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
+	}
+
+	return s.putTransaction(ctx, transaction)
+}
+
+// VerifyQRPayment checks that a recorded transaction is payerID's QR payment of the given
+// payload. A transaction that does not match returns the mismatch as an error.
+func (s *SmartContract) VerifyQRPayment(ctx contractapi.TransactionContextInterface, payload string, txID string, payerID string) (bool, error) {
+	decoded, err := qrpay.Decode(payload)
+	if err != nil {
+		return false, fmt.Errorf("invalid QR payload: %v", err)
+	}
+
+	transaction, err := s.getTransaction(ctx, txID)
+	if err != nil {
+		return false, err
+	}
+	if transaction.FromID != payerID {
+		return false, fmt.Errorf("transaction %s was not paid by %s", txID, payerID)
+	}
+	if transaction.Type != "QRPayment" && transaction.Type != "PaymentRequest" {
+		return false, fmt.Errorf("transaction %s is a %s, not a QR payment", txID, transaction.Type)
+	}
+	if transaction.Reference != decoded.Reference {
+		return false, fmt.Errorf("transaction %s has reference %q, QR reference is %q", txID, transaction.Reference, decoded.Reference)
+	}
+
+	currency, err := s.getCurrency(ctx, defaultCurrency)
+	if err != nil {
+		return false, err
+	}
+
+	// Expiry is judged against the time the payment was made, not now
+	err = decoded.Matches(transaction.ToID, transaction.Amount, currency.NumericCode, transaction.Timestamp)
+	if err != nil {
+		return false, fmt.Errorf("transaction %s does not match the QR payload: %v", txID, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/66571660/fabric-samples/cbdc/chaincode2/qrpay"
+)
+
+func TestPayQRSettlesPaymentRequest(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	var request PaymentRequest
+	l.asUser("shop").mustQuery(&request, "CreatePaymentRequest", 30.0, "invoice", int64(0), true)
+	payload := l.mustInvoke("GetPaymentRequestQR", request.ID)
+
+	decoded, err := qrpay.Decode(payload)
+	if err != nil {
+		t.Fatalf("GetPaymentRequestQR returned an invalid payload: %v", err)
+	}
+	if decoded.MerchantAccountID != "shop" || decoded.Amount != 30 || decoded.Reference != request.ID {
+		t.Fatalf("unexpected QR payload %+v", decoded)
+	}
+
+	// The wallet passes the payee it showed, so a swapped QR code fails
+	l.asUser("alice").mustFailWith("payee mallory does not match QR merchant shop", "PayQR", payload, "mallory", 0.0)
+	l.mustInvoke("PayQR", payload, "shop", 0.0)
+	payTxID := l.lastTxID()
+	l.mustFail("PayQR", payload, "shop", 0.0)
+
+	var paid PaymentRequest
+	l.mustQuery(&paid, "GetPaymentRequest", request.ID)
+	if paid.Status != "Paid" {
+		t.Fatalf("payment request is %s after paying its QR code", paid.Status)
+	}
+	l.expectBalance("alice", 70)
+	l.expectBalance("shop", 30)
+
+	if verified := l.mustInvoke("VerifyQRPayment", payload, payTxID, "alice"); verified != "true" {
+		t.Fatalf("VerifyQRPayment returned %s for the paying transaction", verified)
+	}
+	l.mustFailWith("was not paid by bob", "VerifyQRPayment", payload, payTxID, "bob")
+
+	// A settled request no longer gets a QR code
+	l.asUser("shop").mustFailWith("is Paid", "GetPaymentRequestQR", request.ID)
+	l.mustQuery(&request, "CreatePaymentRequest", 10.0, "invoice", int64(0), false)
+	l.mustInvoke("CancelPaymentRequest", request.ID)
+	l.mustFailWith("is Cancelled", "GetPaymentRequestQR", request.ID)
+	l.mustQuery(&request, "CreatePaymentRequest", 10.0, "invoice", l.now+10, false)
+	l.now += 20
+	l.mustFailWith("has expired", "GetPaymentRequestQR", request.ID)
+}
+
+func TestPayQRStaticCode(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	payload, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "kiosk", Currency: defaultNumericCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.asUser("alice").mustFailWith("amount must be positive", "PayQR", payload, "kiosk", 0.0)
+	l.mustInvoke("PayQR", payload, "kiosk", 12.5)
+	l.expectBalance("kiosk", 12.5)
+
+	foreign, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "kiosk", Currency: "978"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.mustFailWith("currency", "PayQR", foreign, "kiosk", 1.0)
+
+	expired, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "kiosk", Currency: defaultNumericCode, ExpiresAt: testStartTime - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.mustFailWith("expired", "PayQR", expired, "kiosk", 1.0)
+	l.mustFailWith("invalid QR payload", "PayQR", payload[:len(payload)-1]+"0", "kiosk", 1.0)
+}
+
+func TestVerifyQRPaymentRejectsOtherTransactions(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	payload, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "kiosk", Amount: 5, Currency: defaultNumericCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.asUser("alice").mustInvoke("PayQR", payload, "kiosk", 0.0)
+	payTxID := l.lastTxID()
+	l.mustInvoke("TransferTokens", "alice", "bob", 5.0)
+	l.mustFailWith("not a QR payment", "VerifyQRPayment", payload, l.lastTxID(), "alice")
+
+	// A plain transfer of the same amount to the merchant is not the QR payment
+	l.mustInvoke("TransferTokens", "alice", "kiosk", 5.0)
+	l.mustFailWith("not a QR payment", "VerifyQRPayment", payload, l.lastTxID(), "alice")
+
+	// Nor is a QR payment of another code, or one to another merchant
+	referenced, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "kiosk", Amount: 5, Currency: defaultNumericCode, Reference: "order-7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.mustFailWith(`has reference ""`, "VerifyQRPayment", referenced, payTxID, "alice")
+	l.mustInvoke("PayQR", referenced, "kiosk", 0.0)
+	l.mustInvoke("VerifyQRPayment", referenced, l.lastTxID(), "alice")
+	other, err := qrpay.Encode(&qrpay.Payload{MerchantAccountID: "stall", Amount: 5, Currency: defaultNumericCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.mustInvoke("PayQR", other, "stall", 0.0)
+	l.mustFailWith("does not match the QR payload", "VerifyQRPayment", payload, l.lastTxID(), "alice")
+
+	l.mustFailWith("does not exist", "VerifyQRPayment", payload, "missing", "alice")
+}
@@ -0,0 +1,300 @@
+// Package qrpay encodes and decodes merchant-presented QR payment payloads.
+//
+// Payloads follow the EMVCo merchant-presented QR layout: a flat sequence of
+// TLV data objects, each made of a two-digit ID, a two-digit length and the
+// value, terminated by a CRC-16/CCITT-FALSE checksum in data object 63.
+package qrpay
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Top-level data object IDs
+const (
+	idPayloadFormat     = "00"
+	idInitiationMethod  = "01"
+	idMerchantAccount   = "26"
+	idCurrency          = "53"
+	idAmount            = "54"
+	idCountryCode       = "58"
+	idAdditionalData    = "62"
+	idCRC               = "63"
+	idExpiryTemplate    = "80"
+	payloadFormatValue  = "01"
+	staticInitiation    = "11"
+	dynamicInitiation   = "12"
+	subIDGloballyUnique = "00"
+	subIDAccount        = "01"
+	subIDReference      = "05"
+	subIDExpiry         = "01"
+)
+
+// GloballyUniqueID identifies this ledger inside the merchant account and expiry templates
+const GloballyUniqueID = "org.cbdc.ledger"
+
+// Payload is the decoded content of a merchant QR code
+type Payload struct {
+	MerchantAccountID string  `json:"merchantAccountId"`
+	Amount            float64 `json:"amount"`   // 0 for a static code where the payer enters the amount
+	Currency          string  `json:"currency"` // ISO 4217 numeric code
+	CountryCode       string  `json:"countryCode,omitempty"`
+	Reference         string  `json:"reference,omitempty"`
+	ExpiresAt         int64   `json:"expiresAt,omitempty"` // Unix seconds, 0 means no expiry
+}
+
+// Encode serialises the payload into its QR string form, including the CRC
+func Encode(p *Payload) (string, error) {
+	if p.MerchantAccountID == "" {
+		return "", fmt.Errorf("merchant account ID is required")
+	}
+	if !isNumeric(p.Currency, 3) {
+		return "", fmt.Errorf("currency must be a 3-digit ISO 4217 numeric code")
+	}
+	if p.Amount < 0 {
+		return "", fmt.Errorf("amount must not be negative")
+	}
+
+	var b strings.Builder
+	initiation := staticInitiation
+	if p.Amount > 0 {
+		initiation = dynamicInitiation
+	}
+
+	if err := writeTLV(&b, idPayloadFormat, payloadFormatValue); err != nil {
+		return "", err
+	}
+	if err := writeTLV(&b, idInitiationMethod, initiation); err != nil {
+		return "", err
+	}
+
+	account, err := template(map[string]string{
+		subIDGloballyUnique: GloballyUniqueID,
+		subIDAccount:        p.MerchantAccountID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := writeTLV(&b, idMerchantAccount, account); err != nil {
+		return "", err
+	}
+
+	if err := writeTLV(&b, idCurrency, p.Currency); err != nil {
+		return "", err
+	}
+	if p.Amount > 0 {
+		if err := writeTLV(&b, idAmount, strconv.FormatFloat(p.Amount, 'f', 2, 64)); err != nil {
+			return "", err
+		}
+	}
+	if p.CountryCode != "" {
+		if err := writeTLV(&b, idCountryCode, p.CountryCode); err != nil {
+			return "", err
+		}
+	}
+	if p.Reference != "" {
+		additional, err := template(map[string]string{subIDReference: p.Reference})
+		if err != nil {
+			return "", err
+		}
+		if err := writeTLV(&b, idAdditionalData, additional); err != nil {
+			return "", err
+		}
+	}
+	if p.ExpiresAt != 0 {
+		expiry, err := template(map[string]string{
+			subIDGloballyUnique: GloballyUniqueID,
+			subIDExpiry:         strconv.FormatInt(p.ExpiresAt, 10),
+		})
+		if err != nil {
+			return "", err
+		}
+		if err := writeTLV(&b, idExpiryTemplate, expiry); err != nil {
+			return "", err
+		}
+	}
+
+	// The CRC covers everything up to and including its own ID and length
+	b.WriteString(idCRC + "04")
+	b.WriteString(fmt.Sprintf("%04X", crc16(b.String())))
+
+	return b.String(), nil
+}
+
+// Decode parses a QR string, verifying its CRC and required fields
+func Decode(s string) (*Payload, error) {
+	if len(s) < 8 {
+		return nil, fmt.Errorf("payload too short")
+	}
+
+	body, checksum := s[:len(s)-4], s[len(s)-4:]
+	if !strings.HasSuffix(body, idCRC+"04") {
+		return nil, fmt.Errorf("payload must end with the CRC data object")
+	}
+	expected := fmt.Sprintf("%04X", crc16(body))
+	if !strings.EqualFold(checksum, expected) {
+		return nil, fmt.Errorf("CRC mismatch: got %s, expected %s", checksum, expected)
+	}
+
+	fields, err := parseTLV(body[:len(body)-4])
+	if err != nil {
+		return nil, err
+	}
+
+	if fields[idPayloadFormat] != payloadFormatValue {
+		return nil, fmt.Errorf("unsupported payload format indicator %q", fields[idPayloadFormat])
+	}
+
+	p := &Payload{
+		Currency:    fields[idCurrency],
+		CountryCode: fields[idCountryCode],
+	}
+
+	account, ok := fields[idMerchantAccount]
+	if !ok {
+		return nil, fmt.Errorf("merchant account information missing")
+	}
+	accountFields, err := parseTLV(account)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merchant account information: %v", err)
+	}
+	if accountFields[subIDGloballyUnique] != GloballyUniqueID {
+		return nil, fmt.Errorf("merchant account belongs to unknown scheme %q", accountFields[subIDGloballyUnique])
+	}
+	p.MerchantAccountID = accountFields[subIDAccount]
+	if p.MerchantAccountID == "" {
+		return nil, fmt.Errorf("merchant account ID missing")
+	}
+
+	if !isNumeric(p.Currency, 3) {
+		return nil, fmt.Errorf("invalid currency code %q", p.Currency)
+	}
+
+	if amount, ok := fields[idAmount]; ok {
+		p.Amount, err = strconv.ParseFloat(amount, 64)
+		if err != nil || p.Amount <= 0 {
+			return nil, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+
+	if additional, ok := fields[idAdditionalData]; ok {
+		additionalFields, err := parseTLV(additional)
+		if err != nil {
+			return nil, fmt.Errorf("invalid additional data: %v", err)
+		}
+		p.Reference = additionalFields[subIDReference]
+	}
+
+	if expiry, ok := fields[idExpiryTemplate]; ok {
+		expiryFields, err := parseTLV(expiry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry template: %v", err)
+		}
+		if expiryFields[subIDGloballyUnique] == GloballyUniqueID {
+			p.ExpiresAt, err = strconv.ParseInt(expiryFields[subIDExpiry], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiry %q", expiryFields[subIDExpiry])
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Matches checks that a payment of amount to merchantID settles this payload at time now
+func (p *Payload) Matches(merchantID string, amount float64, currency string, now int64) error {
+	if p.MerchantAccountID != merchantID {
+		return fmt.Errorf("payee %s does not match QR merchant %s", merchantID, p.MerchantAccountID)
+	}
+	if p.Currency != currency {
+		return fmt.Errorf("currency %s does not match QR currency %s", currency, p.Currency)
+	}
+	if p.Amount > 0 && p.Amount != amount {
+		return fmt.Errorf("amount %.2f does not match QR amount %.2f", amount, p.Amount)
+	}
+	if p.ExpiresAt != 0 && now > p.ExpiresAt {
+		return fmt.Errorf("QR payload expired at %d", p.ExpiresAt)
+	}
+	return nil
+}
+
+func writeTLV(b *strings.Builder, id string, value string) error {
+	if len(value) == 0 || len(value) > 99 {
+		return fmt.Errorf("data object %s must be 1-99 characters, got %d", id, len(value))
+	}
+	b.WriteString(id)
+	b.WriteString(fmt.Sprintf("%02d", len(value)))
+	b.WriteString(value)
+	return nil
+}
+
+// template encodes nested data objects in ascending ID order
+func template(values map[string]string) (string, error) {
+	ids := make([]string, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		if err := writeTLV(&b, id, values[id]); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func parseTLV(s string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for i := 0; i < len(s); {
+		if i+4 > len(s) {
+			return nil, fmt.Errorf("truncated data object at offset %d", i)
+		}
+		id := s[i : i+2]
+		if !isNumeric(id, 2) || !isNumeric(s[i+2:i+4], 2) {
+			return nil, fmt.Errorf("malformed data object header at offset %d", i)
+		}
+		length, _ := strconv.Atoi(s[i+2 : i+4])
+		start := i + 4
+		if start+length > len(s) {
+			return nil, fmt.Errorf("data object %s overruns payload", id)
+		}
+		if _, dup := fields[id]; dup {
+			return nil, fmt.Errorf("duplicate data object %s", id)
+		}
+		fields[id] = s[start : start+length]
+		i = start + length
+	}
+	return fields, nil
+}
+
+// crc16 computes CRC-16/CCITT-FALSE (polynomial 0x1021, initial value 0xFFFF)
+func crc16(s string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func isNumeric(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
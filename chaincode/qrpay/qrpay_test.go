@@ -0,0 +1,142 @@
+package qrpay
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	payloads := []*Payload{
+		{MerchantAccountID: "shop", Currency: "999"},
+		{
+			MerchantAccountID: "shop",
+			Amount:            12.5,
+			Currency:          "999",
+			CountryCode:       "SG",
+			Reference:         "inv-42",
+			ExpiresAt:         1700000000,
+		},
+	}
+
+	for _, want := range payloads {
+		encoded, err := Encode(want)
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", want, err)
+		}
+		got, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", encoded, err)
+		}
+		if *got != *want {
+			t.Errorf("round trip of %q: got %+v, want %+v", encoded, got, want)
+		}
+	}
+}
+
+func TestEncodeInitiationMethod(t *testing.T) {
+	static, err := Encode(&Payload{MerchantAccountID: "shop", Currency: "999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(static, "000201010211") {
+		t.Errorf("static code %q should use initiation method 11", static)
+	}
+
+	dynamic, err := Encode(&Payload{MerchantAccountID: "shop", Amount: 1, Currency: "999"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dynamic, "000201010212") {
+		t.Errorf("dynamic code %q should use initiation method 12", dynamic)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// Check value of CRC-16/CCITT-FALSE
+	if got := crc16("123456789"); got != 0x29B1 {
+		t.Errorf("crc16(\"123456789\") = %04X, want 29B1", got)
+	}
+}
+
+func TestEncodeRejectsInvalidPayload(t *testing.T) {
+	payloads := []*Payload{
+		{Currency: "999"},
+		{MerchantAccountID: "shop", Currency: "CBDC"},
+		{MerchantAccountID: "shop", Currency: "999", Amount: -1},
+		{MerchantAccountID: strings.Repeat("x", 100), Currency: "999"},
+	}
+
+	for _, p := range payloads {
+		if _, err := Encode(p); err == nil {
+			t.Errorf("Encode(%+v) succeeded, want error", p)
+		}
+	}
+}
+
+func TestDecodeRejectsMalformedPayload(t *testing.T) {
+	valid, err := Encode(&Payload{MerchantAccountID: "shop", Amount: 10, Currency: "999", Reference: "inv-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account := "2627" + "0015" + GloballyUniqueID + "0104shop"
+	cases := map[string]string{
+		"empty":            "",
+		"too short":        "6304",
+		"truncated":        valid[:len(valid)-10],
+		"bad checksum":     valid[:len(valid)-4] + "0000",
+		"missing CRC":      valid[:len(valid)-8],
+		"truncated header": withCRC("00020101021226"),
+		"overrun":          withCRC("0002010102122699ab"),
+		"bad header":       withCRC("000201xx0212"),
+		"duplicate":        withCRC("00020100020101021226"),
+		"no format":        withCRC("010212"),
+		"no account":       withCRC("000201010212530399"),
+		"unknown scheme":   withCRC("00020101021226140005other0101x5303999"),
+		"bad currency":     withCRC("000201010212" + account + "5303ABC"),
+		"bad amount":       withCRC("000201010212" + account + "53039995402-1"),
+	}
+
+	for name, payload := range cases {
+		if _, err := Decode(payload); err == nil {
+			t.Errorf("%s: Decode(%q) succeeded, want error", name, payload)
+		}
+	}
+}
+
+func TestDecodeMinimalPayload(t *testing.T) {
+	p, err := Decode(withCRC("000201010211" + "2627" + "0015" + GloballyUniqueID + "0104shop" + "5303999"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MerchantAccountID != "shop" || p.Currency != "999" || p.Amount != 0 {
+		t.Errorf("unexpected payload %+v", p)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	p := &Payload{MerchantAccountID: "shop", Amount: 10, Currency: "999", ExpiresAt: 100}
+
+	if err := p.Matches("shop", 10, "999", 100); err != nil {
+		t.Errorf("matching payment rejected: %v", err)
+	}
+	if err := p.Matches("mallory", 10, "999", 100); err == nil {
+		t.Error("payment to another merchant accepted")
+	}
+	if err := p.Matches("shop", 9, "999", 100); err == nil {
+		t.Error("payment of another amount accepted")
+	}
+	if err := p.Matches("shop", 10, "702", 100); err == nil {
+		t.Error("payment in another currency accepted")
+	}
+	if err := p.Matches("shop", 10, "999", 101); err == nil {
+		t.Error("payment after expiry accepted")
+	}
+}
+
+// withCRC appends a valid CRC data object to body
+func withCRC(body string) string {
+	body += idCRC + "04"
+	return body + fmt.Sprintf("%04X", crc16(body))
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// queuePriorities ranks the priority levels of queued payments, most urgent first
+var queuePriorities = map[string]int{"Urgent": 0, "High": 1, "Normal": 2}
+
+// queuedPaymentFunctions maps each queued payment type to the direct transaction it stands in
+// for. A queued payment is paused with that transaction's scope.
+var queuedPaymentFunctions = map[string]string{
+	"Transfer":         "TransferTokens",
+	"CBToCommercial":   "TransferToCB",
+	"CommercialToUser": "TransferToUser",
+}
+
+// QueuedPayment is a payment submitted in queued mode. It settles as soon as the payer can
+// cover it and is otherwise parked until funds arrive, it is cancelled or gridlock is resolved.
+type QueuedPayment struct {
+	DocType        string  `json:"docType"`
+	SchemaVersion  int     `json:"schemaVersion"`
+	ID             string  `json:"id"`
+	PaymentType    string  `json:"paymentType"` // Transfer, CBToCommercial, CommercialToUser
+	FromID         string  `json:"fromId"`
+	ToID           string  `json:"toId"`
+	Amount         float64 `json:"amount"`
+	Priority       string  `json:"priority"` // Urgent, High, Normal
+	Sequence       int64   `json:"sequence"` // Proposal time in nanoseconds, orders payments of equal priority
+	Status         string  `json:"status"`   // Queued, Settled, Cancelled
+	QueuedAt       int64   `json:"queuedAt"`
+	SettledAt      int64   `json:"settledAt"`
+	SettlementTxID string  `json:"settlementTxId"`
+	ModifiedAt     int64   `json:"modifiedAt"`
+}
+
+// GridlockResult reports the outcome of a gridlock resolution pass
+type GridlockResult struct {
+	Settled    []string `json:"settled"`
+	Remaining  int      `json:"remaining"`
+	TotalValue float64  `json:"totalValue"`
+}
+
+// QueuePayment submits a payment in queued mode: instead of failing on insufficient funds it
+// is parked in the payer's queue. The payer is the caller, or the central bank's account for
+// CBToCommercial, with the same permissions, approvals and pause scope as TransferTokens,
+// TransferToCB and TransferToUser. A bank's distribution to a user waits in the queue while it
+// would breach the bank's reserve requirement.
+func (s *SmartContract) QueuePayment(ctx contractapi.TransactionContextInterface, paymentType string, toID string, amount float64, priority string) (*QueuedPayment, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if _, ok := queuePriorities[priority]; !ok {
+		return nil, fmt.Errorf("priority must be Urgent, High or Normal")
+	}
+
+	fromID, err := s.getQueuePayer(ctx, paymentType, toID, amount)
+	if err != nil {
+		return nil, err
+	}
+	if fromID == toID {
+		return nil, fmt.Errorf("cannot transfer to the same account")
+	}
+
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	payment := &QueuedPayment{
+		DocType:     "queuedPayment",
+		ID:          ctx.GetStub().GetTxID(),
+		PaymentType: paymentType,
+		FromID:      fromID,
+		ToID:        toID,
+		Amount:      amount,
+		Priority:    priority,
+		Sequence:    ts.GetSeconds()*int64(time.Second) + int64(ts.GetNanos()),
+		Status:      "Queued",
+		QueuedAt:    ts.GetSeconds(),
+		ModifiedAt:  time.Now().Unix(),
+	}
+
+	// Payments already queued at the same or a higher priority go first
+	queue, err := s.getPayerQueue(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+	blocked := false
+	for _, queued := range queue {
+		if queuePriorities[queued.Priority] <= queuePriorities[priority] {
+			blocked = true
+			break
+		}
+	}
+
+	if !blocked {
+		covered, err := s.canCoverQueuedPayment(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		if covered {
+			err = s.settleQueuedPayment(ctx, payment)
+			if err != nil {
+				return nil, err
+			}
+			err = s.releaseQueuedPayments(ctx, toID)
+			if err != nil {
+				return nil, err
+			}
+			return payment, nil
+		}
+	}
+
+	err = s.putQueuedPayment(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().PutState(s.getPaymentQueueKey(payment), []byte(payment.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue payment: %v", err)
+	}
+
+	return payment, nil
+}
+
+// CancelQueuedPayment removes a payment from the queue (payer only)
+func (s *SmartContract) CancelQueuedPayment(ctx contractapi.TransactionContextInterface, paymentID string) error {
+	payment, err := s.getQueuedPayment(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status != "Queued" {
+		return fmt.Errorf("payment %s is %s", paymentID, payment.Status)
+	}
+
+	if payment.FromID == s.getCentralBankID() {
+		err = s.validateCentralBank(ctx)
+	} else {
+		var caller string
+		caller, err = s.getCallerID(ctx)
+		if err == nil && caller != payment.FromID {
+			err = fmt.Errorf("caller is not the payer")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("caller not authorized to cancel this payment: %v", err)
+	}
+
+	payment.Status = "Cancelled"
+	payment.ModifiedAt = time.Now().Unix()
+
+	err = ctx.GetStub().DelState(s.getPaymentQueueKey(payment))
+	if err != nil {
+		return fmt.Errorf("failed to remove payment from queue: %v", err)
+	}
+	err = s.putQueuedPayment(ctx, payment)
+	if err != nil {
+		return err
+	}
+
+	// Cancelling the head of the queue may unblock the payments behind it
+	return s.releaseQueuedPayments(ctx, payment.FromID)
+}
+
+// ReleaseQueuedPayments settles the queued payments of an account, in priority and FIFO order,
+// for as long as its balance covers them. Credits from TransferTokens, TransferToCB and
+// TransferToUser release the payee's queue automatically; any caller can trigger other cases.
+func (s *SmartContract) ReleaseQueuedPayments(ctx contractapi.TransactionContextInterface, accountID string) error {
+	return s.releaseQueuedPayments(ctx, accountID)
+}
+
+// ResolveGridlock settles the largest set of queued payments that can be settled simultaneously,
+// offsetting each account's incoming against its outgoing payments (Central Bank only). Payments
+// are dropped from the end of the queue of any account that cannot cover its net outflow.
+func (s *SmartContract) ResolveGridlock(ctx contractapi.TransactionContextInterface) (*GridlockResult, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can resolve gridlock: %v", err)
+	}
+
+	pending, err := s.getPayerQueue(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Payments that are paused or that a bank's reserve cannot support are left queued
+	queue := []*QueuedPayment{}
+	for _, payment := range pending {
+		blocked, err := s.queuedPaymentBlocked(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		if !blocked {
+			queue = append(queue, payment)
+		}
+	}
+
+	fees := map[string]*FeeCharge{}
+	balances := map[string]float64{}
+	for _, payment := range queue {
+		fee, err := s.quoteQueuedPaymentFee(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		fees[payment.ID] = fee
+
+		for _, accountID := range []string{payment.FromID, payment.ToID} {
+			balance, err := s.getAccountBalance(ctx, accountID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get balance of %s: %v", accountID, err)
+			}
+			balances[accountID] = balance.Balance
+		}
+	}
+
+	// Shrink each payer's queue from the back until every account covers its net position
+	included := queue
+	for {
+		positions := map[string]float64{}
+		for accountID, balance := range balances {
+			positions[accountID] = balance
+		}
+		for _, payment := range included {
+			positions[payment.FromID] -= payment.Amount
+			positions[payment.ToID] += payment.Amount
+			if fee := fees[payment.ID]; fee != nil {
+				if fee.ChargedTo == "Payee" {
+					positions[payment.ToID] -= fee.Amount
+				} else {
+					positions[payment.FromID] -= fee.Amount
+				}
+			}
+		}
+
+		short := map[string]bool{}
+		for accountID, position := range positions {
+			if position < 0 {
+				short[accountID] = true
+			}
+		}
+		if len(short) == 0 {
+			break
+		}
+
+		// The queue is ordered, so the last included payment of a short payer is its least urgent
+		dropped := map[string]bool{}
+		remaining := []*QueuedPayment{}
+		for i := len(included) - 1; i >= 0; i-- {
+			payment := included[i]
+			if short[payment.FromID] && !dropped[payment.FromID] {
+				dropped[payment.FromID] = true
+				continue
+			}
+			remaining = append([]*QueuedPayment{payment}, remaining...)
+		}
+		if len(dropped) == 0 {
+			// Only payees that owe fees are short; settle nothing rather than overdraw them
+			included = []*QueuedPayment{}
+			break
+		}
+		included = remaining
+	}
+
+	// Credit every payee before debiting any payer so that offsetting payments settle together
+	for _, payment := range included {
+		err = s.changeBalance(ctx, payment.ToID, payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &GridlockResult{Settled: []string{}}
+	for _, payment := range included {
+		err = s.changeBalance(ctx, payment.FromID, -payment.Amount)
+		if err != nil {
+			return nil, err
+		}
+		err = s.completeQueuedPayment(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		result.Settled = append(result.Settled, payment.ID)
+		result.TotalValue += payment.Amount
+	}
+	result.Remaining = len(pending) - len(included)
+
+	return result, nil
+}
+
+// GetQueuedPayment returns a queued payment by ID
+func (s *SmartContract) GetQueuedPayment(ctx contractapi.TransactionContextInterface, paymentID string) (*QueuedPayment, error) {
+	return s.getQueuedPayment(ctx, paymentID)
+}
+
+// GetPaymentQueue returns the payments queued by an account in settlement order; an empty
+// account ID returns every queued payment
+func (s *SmartContract) GetPaymentQueue(ctx contractapi.TransactionContextInterface, accountID string) ([]*QueuedPayment, error) {
+	return s.getPayerQueue(ctx, accountID)
+}
+
+// getQueuePayer checks the caller may submit a payment of this type and returns the paying account
+func (s *SmartContract) getQueuePayer(ctx contractapi.TransactionContextInterface, paymentType string, toID string, amount float64) (string, error) {
+	function, ok := queuedPaymentFunctions[paymentType]
+	if !ok {
+		return "", fmt.Errorf("payment type must be Transfer, CBToCommercial or CommercialToUser")
+	}
+	err := s.checkNotPaused(ctx, function)
+	if err != nil {
+		return "", err
+	}
+
+	switch paymentType {
+	case "CBToCommercial":
+		err = s.validateCentralBank(ctx)
+		if err != nil {
+			return "", fmt.Errorf("only central bank can transfer to commercial banks: %v", err)
+		}
+		// Large transfers follow maker-checker as with TransferToCB
+		err = s.requireTransferApproval(ctx, amount)
+		if err != nil {
+			return "", err
+		}
+		err = s.validateCommercialBank(ctx, toID)
+		if err != nil {
+			return "", fmt.Errorf("invalid commercial bank ID: %v", err)
+		}
+		return s.getCentralBankID(), nil
+	case "CommercialToUser":
+		err = s.validateCallerIsCommercialBank(ctx)
+		if err != nil {
+			return "", fmt.Errorf("only commercial banks can transfer to users: %v", err)
+		}
+		return s.getCallerID(ctx)
+	default:
+		return s.getCallerID(ctx)
+	}
+}
+
+// queuedPaymentBlocked reports whether a queued payment must stay queued whatever the payer's
+// balance: its scope is paused, or it is a distribution the bank's reserve cannot support
+func (s *SmartContract) queuedPaymentBlocked(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) (bool, error) {
+	paused, err := s.pausedScope(ctx, queuedPaymentFunctions[payment.PaymentType])
+	if err != nil || paused != nil {
+		return paused != nil, err
+	}
+
+	if payment.PaymentType == "CommercialToUser" {
+		shortfall, err := s.distributionShortfall(ctx, payment.FromID, payment.Amount)
+		if err != nil {
+			return false, err
+		}
+		return shortfall > 0, nil
+	}
+	return false, nil
+}
+
+// releaseQueuedPayments settles the account's queue in order until a payment cannot be covered.
+// Each settlement credits a payee, whose own queue is then released in turn.
+func (s *SmartContract) releaseQueuedPayments(ctx contractapi.TransactionContextInterface, accountID string) error {
+	pending := []string{accountID}
+	for len(pending) > 0 {
+		payerID := pending[0]
+		pending = pending[1:]
+
+		queue, err := s.getPayerQueue(ctx, payerID)
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range queue {
+			covered, err := s.canCoverQueuedPayment(ctx, payment)
+			if err != nil {
+				return err
+			}
+			if !covered {
+				break
+			}
+
+			err = s.settleQueuedPayment(ctx, payment)
+			if err != nil {
+				return err
+			}
+			pending = append(pending, payment.ToID)
+		}
+	}
+
+	return nil
+}
+
+// canCoverQueuedPayment reports whether the payer's balance and credit headroom cover the payment and any fee it pays
+func (s *SmartContract) canCoverQueuedPayment(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) (bool, error) {
+	blocked, err := s.queuedPaymentBlocked(ctx, payment)
+	if err != nil || blocked {
+		return false, err
+	}
+
+	balance, err := s.getAccountBalance(ctx, payment.FromID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get payer balance: %v", err)
+	}
+
+	required := payment.Amount
+	fee, err := s.quoteQueuedPaymentFee(ctx, payment)
+	if err != nil {
+		return false, err
+	}
+	if fee != nil && fee.ChargedTo != "Payee" {
+		required += fee.Amount
+	}
+
+	credit, err := s.availableCredit(ctx, payment.FromID)
+	if err != nil {
+		return false, err
+	}
+
+	return balance.Balance+credit >= required, nil
+}
+
+func (s *SmartContract) settleQueuedPayment(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) error {
+	err := s.moveFunds(ctx, payment.FromID, payment.ToID, payment.Amount)
+	if err != nil {
+		return err
+	}
+	return s.completeQueuedPayment(ctx, payment)
+}
+
+// completeQueuedPayment charges the fee, records the history and takes a payment whose funds
+// have moved off the queue. History is keyed by the payment ID, which is unique even when one
+// transaction settles many payments.
+func (s *SmartContract) completeQueuedPayment(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) error {
+	var fee *FeeCharge
+	var err error
+	switch payment.PaymentType {
+	case "Transfer":
+		fee, err = s.chargeUserFee(ctx, "Transfer", payment.FromID, payment.ToID, payment.Amount)
+	case "CommercialToUser":
+		fee, err = s.chargeFee(ctx, "CommercialToUser", payment.FromID, payment.FromID, payment.ToID, payment.Amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, payment.FromID, payment.ToID, payment.Amount, payment.PaymentType)
+	transaction.TxID = payment.ID
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
+	}
+	err = s.putTransaction(ctx, transaction)
+	if err != nil {
+		return err
+	}
+
+	if payment.PaymentType == "CommercialToUser" {
+		err = s.recordDistribution(ctx, payment.FromID, payment.ToID, payment.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	payment.Status = "Settled"
+	payment.SettledAt = now
+	payment.SettlementTxID = ctx.GetStub().GetTxID()
+	payment.ModifiedAt = time.Now().Unix()
+
+	err = ctx.GetStub().DelState(s.getPaymentQueueKey(payment))
+	if err != nil {
+		return fmt.Errorf("failed to remove payment from queue: %v", err)
+	}
+	return s.putQueuedPayment(ctx, payment)
+}
+
+func (s *SmartContract) quoteQueuedPaymentFee(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) (*FeeCharge, error) {
+	switch payment.PaymentType {
+	case "Transfer":
+		return s.quoteUserFee(ctx, "Transfer", payment.FromID, payment.ToID, payment.Amount)
+	case "CommercialToUser":
+		return s.quoteFee(ctx, "CommercialToUser", payment.FromID, payment.FromID, payment.ToID, payment.Amount)
+	}
+	return nil, nil
+}
+
+// changeBalance adds delta to an account's default-currency balance, refusing to overdraw it
+func (s *SmartContract) changeBalance(ctx contractapi.TransactionContextInterface, accountID string, delta float64) error {
+	balance, err := s.getAccountBalance(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", accountID, err)
+	}
+	if balance.Balance+delta < 0 {
+		return fmt.Errorf("Insufficient balance for %s. Available: %.2f, Required: %.2f", accountID, balance.Balance, -delta)
+	}
+
+	balance.Balance += delta
+	balance.ModifiedAt = time.Now().Unix()
+	return s.putAccountBalance(ctx, balance)
+}
+
+func (s *SmartContract) getQueuedPaymentKey(paymentID string) string {
+	return "qpayment_" + paymentID
+}
+
+// getPaymentQueueKey orders a payer's queue by priority, then submission time
+func (s *SmartContract) getPaymentQueueKey(payment *QueuedPayment) string {
+	return fmt.Sprintf("payqueue_%s|%d|%020d|%s", payment.FromID, queuePriorities[payment.Priority], payment.Sequence, payment.ID)
+}
+
+// getPayerQueue returns the still-queued payments of a payer, or of all payers when payerID is empty.
+// Range reads only see committed state, so each entry is re-read to skip payments settled or
+// cancelled earlier in this transaction.
+func (s *SmartContract) getPayerQueue(ctx contractapi.TransactionContextInterface, payerID string) ([]*QueuedPayment, error) {
+	startKey, endKey := "payqueue_", "payqueue`"
+	if payerID != "" {
+		startKey = "payqueue_" + payerID + "|"
+		endKey = startKey + "~"
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment queue: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	paymentIDs := []string{}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next queued payment: %v", err)
+		}
+		paymentIDs = append(paymentIDs, string(queryResult.Value))
+	}
+
+	queue := []*QueuedPayment{}
+	for _, paymentID := range paymentIDs {
+		payment, err := s.getQueuedPayment(ctx, paymentID)
+		if err != nil {
+			return nil, err
+		}
+		if payment.Status == "Queued" {
+			queue = append(queue, payment)
+		}
+	}
+
+	return queue, nil
+}
+
+func (s *SmartContract) getQueuedPayment(ctx contractapi.TransactionContextInterface, paymentID string) (*QueuedPayment, error) {
+	paymentBytes, err := ctx.GetStub().GetState(s.getQueuedPaymentKey(paymentID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queued payment: %v", err)
+	}
+	if paymentBytes == nil {
+		return nil, fmt.Errorf("queued payment %s does not exist", paymentID)
+	}
+
+	var payment QueuedPayment
+	err = s.decodeDocument("queuedPayment", paymentBytes, &payment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal queued payment: %v", err)
+	}
+
+	return &payment, nil
+}
+
+func (s *SmartContract) putQueuedPayment(ctx contractapi.TransactionContextInterface, payment *QueuedPayment) error {
+	payment.SchemaVersion = s.currentSchemaVersion("queuedPayment")
+	paymentJSON, err := json.Marshal(payment)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued payment: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getQueuedPaymentKey(payment.ID), paymentJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put queued payment state: %v", err)
+	}
+	return nil
+}
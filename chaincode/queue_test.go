@@ -0,0 +1,135 @@
+package main
+
+import "testing"
+
+func TestQueuedPaymentReleasedOnCredit(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asUser("alice").mustFailWith("priority must be", "QueuePayment", "Transfer", "bob", 30.0, "Low")
+	l.mustFailWith("payment type must be", "QueuePayment", "Cheque", "bob", 30.0, "Normal")
+
+	var queued QueuedPayment
+	l.mustQuery(&queued, "QueuePayment", "Transfer", "bob", 30.0, "Normal")
+	if queued.Status != "Queued" || queued.FromID != "alice" {
+		t.Fatalf("unexpected queued payment %+v", queued)
+	}
+	l.mustInvoke("QueuePayment", "Transfer", "carol", 40.0, "Normal")
+
+	// Funding alice settles the first payment; the second cannot be covered yet
+	l.fund("bank1", "alice", 50)
+	l.expectBalance("bob", 30)
+	l.expectBalance("alice", 20)
+
+	var queue []*QueuedPayment
+	l.mustQuery(&queue, "GetPaymentQueue", "alice")
+	if len(queue) != 1 || queue[0].ToID != "carol" {
+		t.Fatalf("unexpected queue %+v", queue)
+	}
+
+	// A more urgent payment is not blocked by a less urgent one
+	l.asUser("alice").mustInvoke("QueuePayment", "Transfer", "dave", 10.0, "Urgent")
+	l.expectBalance("dave", 10)
+
+	l.asUser("bob").mustFailWith("not authorized", "CancelQueuedPayment", queue[0].ID)
+	l.asUser("alice").mustInvoke("CancelQueuedPayment", queue[0].ID)
+	l.mustFailWith("is Cancelled", "CancelQueuedPayment", queue[0].ID)
+
+	var settled QueuedPayment
+	l.mustQuery(&settled, "GetQueuedPayment", queued.ID)
+	if settled.Status != "Settled" || settled.SettlementTxID == "" {
+		t.Fatalf("unexpected settled payment %+v", settled)
+	}
+}
+
+func TestQueuedPaymentPriorityOrder(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asUser("alice").mustInvoke("QueuePayment", "Transfer", "bob", 30.0, "Normal")
+	l.mustInvoke("QueuePayment", "Transfer", "carol", 30.0, "Urgent")
+
+	l.fund("bank1", "alice", 30)
+	l.expectBalance("carol", 30)
+	l.expectBalance("bob", 0)
+}
+
+func TestResolveGridlock(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 10)
+
+	l.asUser("alice").mustInvoke("QueuePayment", "Transfer", "bob", 50.0, "Normal")
+	l.asUser("bob").mustInvoke("QueuePayment", "Transfer", "alice", 45.0, "Normal")
+	l.asUser("carol").mustInvoke("QueuePayment", "Transfer", "alice", 20.0, "Normal")
+
+	l.asBank("bank1").mustFailWith("only central bank", "ResolveGridlock")
+	var result GridlockResult
+	l.asCentralBank().mustQuery(&result, "ResolveGridlock")
+	if len(result.Settled) != 2 || result.Remaining != 1 || result.TotalValue != 95 {
+		t.Fatalf("unexpected gridlock result %+v", result)
+	}
+
+	l.expectBalance("alice", 5)
+	l.expectBalance("bob", 5)
+	l.expectBalance("carol", 0)
+}
+
+func TestQueuedTransferCoversPayeeBankFee(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "shop", 10)
+	l.link("bank1", "shop")
+	l.asCentralBank().mustInvoke("SetFeeSchedule", "Transfer", "", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "fees")
+	l.asBank("bank1").mustInvoke("SetFeeSchedule", "Transfer", "bank1", "Flat", 1.0, 0.0, []FeeTier{}, 0.0, "Payer", "bank1")
+
+	l.asUser("alice").mustInvoke("QueuePayment", "Transfer", "shop", 20.0, "Normal")
+
+	// The payment waits until alice can also cover the fee of shop's bank
+	l.fund("bank2", "alice", 20)
+	l.expectBalance("shop", 10)
+	l.fund("bank2", "alice", 1)
+	l.expectBalance("shop", 30)
+	l.expectBalance("alice", 0)
+	l.expectBalance("bank1", 1)
+}
+
+func TestQueuedCentralBankTransferKeepsItsControls(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.setTestApprovalPolicy()
+
+	// Queueing does not get round the large transfer approval of TransferToCB
+	l.asBank("bank1").mustFailWith("only central bank", "QueuePayment", "CBToCommercial", "bank1", 50.0, "Normal")
+	l.asCentralBank().mustFailWith("requires 2 of 3 approvals", "QueuePayment", "CBToCommercial", "bank1", 150.0, "Normal")
+
+	// Nor the interbank pause, which also holds back payments already queued
+	l.mustInvoke("QueuePayment", "CBToCommercial", "bank1", 80.0, "Normal")
+	l.mustInvoke("QueuePayment", "CBToCommercial", "bank2", 50.0, "Normal")
+	l.mustInvoke("Pause", PauseInterbank, "incident")
+	l.mustFailWith("TransferToCB is paused (interbank)", "QueuePayment", "CBToCommercial", "bank1", 10.0, "Normal")
+	l.mustApprove("IssueTokens", `[100]`)
+	l.asCentralBank().mustInvoke("ReleaseQueuedPayments", "central-bank")
+	l.expectBalance("bank2", 0)
+
+	l.now += 60
+	l.mustInvoke("Unpause", PauseInterbank, "resolved")
+	l.mustInvoke("ReleaseQueuedPayments", "central-bank")
+	l.expectBalance("bank1", 80)
+	l.expectBalance("bank2", 50)
+}
+
+func TestQueuedDistributionChecksReserveAtRelease(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 300.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+	l.mustInvoke("SetReserveRequirement", 0.5, true)
+
+	// bank1 could pay 80, but would then hold 20 against the 40 it must keep for alice
+	var queued QueuedPayment
+	l.asBank("bank1").mustQuery(&queued, "QueuePayment", "CommercialToUser", "alice", 80.0, "Normal")
+	if queued.Status != "Queued" {
+		t.Fatalf("distribution beyond the reserve settled at once %+v", queued)
+	}
+	l.expectBalance("alice", 0)
+
+	l.asCentralBank().mustInvoke("TransferToCB", "bank1", 100.0)
+	l.expectBalance("alice", 80)
+	l.expectBalance("bank1", 120)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// RefundPayment returns up to the original amount of a payment to its payer (payee only)
+func (s *SmartContract) RefundPayment(ctx contractapi.TransactionContextInterface, originalTxID string, amount float64) error {
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	original, err := s.getRefundableTransaction(ctx, originalTxID)
+	if err != nil {
+		return err
+	}
+
+	caller, err := s.getCallerID(ctx)
+	if err != nil {
+		return err
+	}
+	if caller != original.ToID {
+		return fmt.Errorf("only the payee of %s can refund it", originalTxID)
+	}
+
+	return s.unwindPayment(ctx, original, amount, "Refund", "")
+}
+
+// ReversePayment reverses the unrefunded remainder of an erroneous payment (central bank
+// administrators or the linked servicing bank of the payer or payee only)
+func (s *SmartContract) ReversePayment(ctx contractapi.TransactionContextInterface, originalTxID string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("a reason is required to reverse a payment")
+	}
+
+	original, err := s.getRefundableTransaction(ctx, originalTxID)
+	if err != nil {
+		return err
+	}
+
+	if s.validateCentralBankAdmin(ctx) != nil {
+		_, payerBankErr := s.validateServicingBank(ctx, original.FromID)
+		_, payeeBankErr := s.validateServicingBank(ctx, original.ToID)
+		if payerBankErr != nil && payeeBankErr != nil {
+			return fmt.Errorf("only the central bank or the servicing bank of the payer or payee can reverse %s", originalTxID)
+		}
+	}
+
+	return s.unwindPayment(ctx, original, original.Amount-original.RefundedAmount, "Reversal", reason)
+}
+
+// refundableTypes are the retail payments that can be refunded, reversed or disputed
+var refundableTypes = map[string]bool{
+	"Transfer":       true,
+	"PaymentRequest": true,
+	"QRPayment":      true,
+	"TransferFrom":   true,
+	"OfflinePayment": true,
+	"Cheque":         true,
+}
+
+// getRefundableTransaction loads a retail payment that moved funds between two accounts
+func (s *SmartContract) getRefundableTransaction(ctx contractapi.TransactionContextInterface, txID string) (*TransactionHistory, error) {
+	original, err := s.getTransaction(ctx, txID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !refundableTypes[original.Type] {
+		return nil, fmt.Errorf("%s transactions cannot be refunded or reversed", original.Type)
+	}
+	if original.FromID == "" || original.ToID == "" {
+		return nil, fmt.Errorf("transaction %s has no payer and payee to unwind", txID)
+	}
+
+	return original, nil
+}
+
+// unwindPayment moves funds from the original payee back to the payer and links both history rows
+func (s *SmartContract) unwindPayment(ctx contractapi.TransactionContextInterface, original *TransactionHistory, amount float64, txType string, reason string) error {
+	remaining := original.Amount - original.RefundedAmount
+	if remaining <= 0 {
+		return fmt.Errorf("transaction %s has already been fully refunded", original.TxID)
+	}
+	if amount > remaining {
+		return fmt.Errorf("amount exceeds refundable remainder %.2f of transaction %s", remaining, original.TxID)
+	}
+
+	err := s.moveCurrencyFunds(ctx, original.ToID, original.FromID, original.Currency, amount)
+	if err != nil {
+		return err
+	}
+
+	original.RefundedAmount += amount
+	err = s.putTransaction(ctx, original)
+	if err != nil {
+		return err
+	}
+
+	return s.putTransaction(ctx, &TransactionHistory{
+		DocType:      "transaction",
+		TxID:         ctx.GetStub().GetTxID(),
+		FromID:       original.ToID,
+		ToID:         original.FromID,
+		Amount:       amount,
+		Currency:     original.Currency,
+		Type:         txType,
+		Timestamp:    time.Now().Unix(),
+		OriginalTxID: original.TxID,
+		Reason:       reason,
+	})
+}
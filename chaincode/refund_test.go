@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestRefundPayment(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 40.0)
+	paymentTxID := l.lastTxID()
+
+	l.mustFailWith("only the payee", "RefundPayment", paymentTxID, 10.0)
+	l.asUser("shop").mustInvoke("RefundPayment", paymentTxID, 15.0)
+	refundTxID := l.lastTxID()
+	l.mustFailWith("exceeds refundable remainder", "RefundPayment", paymentTxID, 30.0)
+	l.mustFail("RefundPayment", paymentTxID, 0.0)
+
+	l.expectBalance("alice", 75)
+	l.expectBalance("shop", 25)
+
+	refund := l.transaction(refundTxID)
+	if refund.Type != "Refund" || refund.OriginalTxID != paymentTxID || refund.FromID != "shop" || refund.ToID != "alice" {
+		t.Fatalf("unexpected refund record %+v", refund)
+	}
+
+	if original := l.transaction(paymentTxID); original.RefundedAmount != 15 {
+		t.Fatalf("original payment shows %.2f refunded, expected 15", original.RefundedAmount)
+	}
+	l.mustFailWith("cannot be refunded", "RefundPayment", refundTxID, 1.0)
+}
+
+func TestReversePayment(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 40.0)
+	paymentTxID := l.lastTxID()
+	l.asUser("shop").mustInvoke("RefundPayment", paymentTxID, 10.0)
+
+	l.as("Org3MSP", "mallory@org3.example.com").mustFailWith("only the central bank or the servicing bank", "ReversePayment", paymentTxID, "sent in error")
+	l.asBank("bank2").mustFailWith("only the central bank or the servicing bank", "ReversePayment", paymentTxID, "sent in error")
+	l.asCentralBank().mustFailWith("a reason is required", "ReversePayment", paymentTxID, "")
+	l.mustInvoke("ReversePayment", paymentTxID, "sent in error")
+	l.mustFailWith("already been fully refunded", "ReversePayment", paymentTxID, "again")
+
+	l.expectBalance("alice", 100)
+	l.expectBalance("shop", 0)
+}
+
+func TestServicingBankReversesRetailPayments(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 100.0)
+	fundingTxID := l.lastTxID()
+	l.link("bank1", "alice")
+
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 40.0)
+	paymentTxID := l.lastTxID()
+	l.asBank("bank1").mustInvoke("ReversePayment", paymentTxID, "sent in error")
+	l.expectBalance("alice", 100)
+
+	// Only retail payments can be unwound, not the bank's own funding of the user
+	l.mustFailWith("CommercialToUser transactions cannot be refunded or reversed", "ReversePayment", fundingTxID, "clawback")
+}
+
+func TestFundingBankCannotReversePayments(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.link("bank1", "alice")
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "shop", 40.0)
+	paymentTxID := l.lastTxID()
+
+	// Sending the payer or payee a token amount does not make bank2 their servicing bank
+	l.fund("bank2", "alice", 0.01)
+	l.fund("bank2", "shop", 0.01)
+	l.asBank("bank2").mustFailWith("only the central bank or the servicing bank", "ReversePayment", paymentTxID, "sent in error")
+	l.expectBalance("shop", 40.01)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ReserveRequirement is the central bank's reserve ratio for commercial banks
+type ReserveRequirement struct {
+	DocType       string  `json:"docType"`
+	SchemaVersion int     `json:"schemaVersion"`
+	RequiredRatio float64 `json:"requiredRatio"` // Reserve held per unit distributed, e.g. 0.1
+	Enforced      bool    `json:"enforced"`      // Block distribution that would leave a bank short
+	SetBy         string  `json:"setBy"`
+	EffectiveFrom int64   `json:"effectiveFrom"`
+}
+
+// BankReserve tracks the CBDC a commercial bank has distributed to its customers
+type BankReserve struct {
+	DocType          string  `json:"docType"`
+	SchemaVersion    int     `json:"schemaVersion"`
+	BankID           string  `json:"bankId"`
+	Distributed      float64 `json:"distributed"` // Distributed and not yet redeemed
+	TotalDistributed float64 `json:"totalDistributed"`
+	TotalRedeemed    float64 `json:"totalRedeemed"`
+	TotalReturned    float64 `json:"totalReturned"` // Returned by the bank to the central bank
+	ModifiedAt       int64   `json:"modifiedAt"`
+}
+
+// ReserveStatus is one bank's line in a reserve compliance report
+type ReserveStatus struct {
+	BankID      string  `json:"bankId"`
+	Distributed float64 `json:"distributed"`
+	Held        float64 `json:"held"`
+	Required    float64 `json:"required"`
+	Shortfall   float64 `json:"shortfall"`
+	Compliant   bool    `json:"compliant"`
+}
+
+// ReserveComplianceReport lists every bank's reserve position against the requirement
+type ReserveComplianceReport struct {
+	RequiredRatio float64          `json:"requiredRatio"`
+	Enforced      bool             `json:"enforced"`
+	CheckedAt     int64            `json:"checkedAt"`
+	Banks         []*ReserveStatus `json:"banks"`
+	Shortfalls    []*ReserveStatus `json:"shortfalls"`
+}
+
+// SetReserveRequirement sets the reserve ratio and whether it blocks distribution (Central Bank only)
+func (s *SmartContract) SetReserveRequirement(ctx contractapi.TransactionContextInterface, requiredRatio float64, enforced bool) (*ReserveRequirement, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can set reserve requirements: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetReserveRequirement")
+	if err != nil {
+		return nil, err
+	}
+
+	if requiredRatio < 0 || requiredRatio > 1 {
+		return nil, fmt.Errorf("required ratio must be between 0 and 1")
+	}
+
+	setBy, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	requirement := &ReserveRequirement{
+		DocType:       "reserveRequirement",
+		RequiredRatio: requiredRatio,
+		Enforced:      enforced,
+		SetBy:         setBy,
+		EffectiveFrom: now,
+	}
+
+	requirement.SchemaVersion = s.currentSchemaVersion("reserveRequirement")
+	requirementJSON, err := json.Marshal(requirement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal reserve requirement: %v", err)
+	}
+	err = ctx.GetStub().PutState("reserve_requirement", requirementJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put reserve requirement state: %v", err)
+	}
+
+	return requirement, nil
+}
+
+// GetReserveRequirement returns the current reserve requirement
+func (s *SmartContract) GetReserveRequirement(ctx contractapi.TransactionContextInterface) (*ReserveRequirement, error) {
+	requirement, err := s.getReserveRequirement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if requirement == nil {
+		return nil, fmt.Errorf("no reserve requirement has been set")
+	}
+	return requirement, nil
+}
+
+// GetBankReserve returns the distribution tracking record of a commercial bank
+func (s *SmartContract) GetBankReserve(ctx contractapi.TransactionContextInterface, bankID string) (*BankReserve, error) {
+	return s.getBankReserve(ctx, bankID)
+}
+
+// CheckReserveCompliance reports each commercial bank's reserve against the requirement and
+// lists the banks that fall short
+func (s *SmartContract) CheckReserveCompliance(ctx contractapi.TransactionContextInterface) (*ReserveComplianceReport, error) {
+	requirement, err := s.GetReserveRequirement(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(s.getBankReserveKey(""), "bankreserve`")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bank reserves: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReserveComplianceReport{
+		RequiredRatio: requirement.RequiredRatio,
+		Enforced:      requirement.Enforced,
+		CheckedAt:     now,
+		Banks:         []*ReserveStatus{},
+		Shortfalls:    []*ReserveStatus{},
+	}
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next bank reserve: %v", err)
+		}
+
+		var reserve BankReserve
+		err = s.decodeDocument("bankReserve", queryResult.Value, &reserve)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bank reserve: %v", err)
+		}
+
+		balance, err := s.getAccountBalance(ctx, reserve.BankID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get balance of %s: %v", reserve.BankID, err)
+		}
+
+		status := s.reserveStatus(requirement, &reserve, balance.Balance)
+		report.Banks = append(report.Banks, status)
+		if !status.Compliant {
+			report.Shortfalls = append(report.Shortfalls, status)
+		}
+	}
+
+	return report, nil
+}
+
+// checkDistributionAllowed rejects a distribution that would leave the bank below its reserve
+// requirement while the requirement is enforced
+func (s *SmartContract) checkDistributionAllowed(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	shortfall, err := s.distributionShortfall(ctx, bankID, amount)
+	if err != nil {
+		return err
+	}
+	if shortfall > 0 {
+		return fmt.Errorf("distribution would leave %s short of its reserve requirement by %.2f", bankID, shortfall)
+	}
+	return nil
+}
+
+// distributionShortfall returns how far a distribution of amount would leave the bank below its
+// reserve requirement, or 0 if it would not or the requirement is not enforced
+func (s *SmartContract) distributionShortfall(ctx contractapi.TransactionContextInterface, bankID string, amount float64) (float64, error) {
+	requirement, err := s.getReserveRequirement(ctx)
+	if err != nil || requirement == nil || !requirement.Enforced {
+		return 0, err
+	}
+
+	reserve, err := s.getBankReserve(ctx, bankID)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := s.getAccountBalance(ctx, bankID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get balance of %s: %v", bankID, err)
+	}
+
+	reserve.Distributed += amount
+	return s.reserveStatus(requirement, reserve, balance.Balance-amount).Shortfall, nil
+}
+
+// checkReturnAllowed rejects a return of amount to the central bank that would leave the bank
+// below its reserve requirement while the requirement is enforced
+func (s *SmartContract) checkReturnAllowed(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	requirement, err := s.getReserveRequirement(ctx)
+	if err != nil || requirement == nil || !requirement.Enforced {
+		return err
+	}
+
+	reserve, err := s.getBankReserve(ctx, bankID)
+	if err != nil {
+		return err
+	}
+	balance, err := s.getAccountBalance(ctx, bankID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", bankID, err)
+	}
+
+	status := s.reserveStatus(requirement, reserve, balance.Balance-amount)
+	if !status.Compliant {
+		return fmt.Errorf("return would leave %s short of its reserve requirement by %.2f", bankID, status.Shortfall)
+	}
+	return nil
+}
+
+// recordReturn counts amount as returned by bankID to the central bank
+func (s *SmartContract) recordReturn(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	reserve, err := s.getBankReserve(ctx, bankID)
+	if err != nil {
+		return err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	reserve.TotalReturned += amount
+	reserve.ModifiedAt = now
+
+	return s.putBankReserve(ctx, reserve)
+}
+
+// recordDistribution counts amount as distributed by bankID and remembers it as the bank whose
+// distribution the user's later redemptions reduce. This is reserve bookkeeping only; the bank
+// that services the user is its ServicingLink.
+func (s *SmartContract) recordDistribution(ctx contractapi.TransactionContextInterface, bankID string, userID string, amount float64) error {
+	reserve, err := s.getBankReserve(ctx, bankID)
+	if err != nil {
+		return err
+	}
+
+	reserve.Distributed += amount
+	reserve.TotalDistributed += amount
+	reserve.ModifiedAt = time.Now().Unix()
+
+	err = s.putBankReserve(ctx, reserve)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.GetStub().PutState(s.getUserBankKey(userID), []byte(bankID))
+	if err != nil {
+		return fmt.Errorf("failed to put user bank: %v", err)
+	}
+	return nil
+}
+
+// recordUserRedemption reduces the amount distributed by the bank that last funded userID
+func (s *SmartContract) recordUserRedemption(ctx contractapi.TransactionContextInterface, userID string, amount float64) error {
+	bankBytes, err := ctx.GetStub().GetState(s.getUserBankKey(userID))
+	if err != nil {
+		return fmt.Errorf("failed to read user bank: %v", err)
+	}
+	if bankBytes == nil {
+		return nil
+	}
+
+	return s.recordBankRedemption(ctx, string(bankBytes), amount)
+}
+
+// recordBankRedemption reduces the amount distributed by bankID when CBDC comes back to it
+// from its customers
+func (s *SmartContract) recordBankRedemption(ctx contractapi.TransactionContextInterface, bankID string, amount float64) error {
+	reserve, err := s.getBankReserve(ctx, bankID)
+	if err != nil {
+		return err
+	}
+
+	redeemed := math.Min(amount, reserve.Distributed)
+	reserve.Distributed -= redeemed
+	reserve.TotalRedeemed += redeemed
+	reserve.ModifiedAt = time.Now().Unix()
+
+	return s.putBankReserve(ctx, reserve)
+}
+
+func (s *SmartContract) reserveStatus(requirement *ReserveRequirement, reserve *BankReserve, held float64) *ReserveStatus {
+	required := reserve.Distributed * requirement.RequiredRatio
+	shortfall := math.Max(required-held, 0)
+	return &ReserveStatus{
+		BankID:      reserve.BankID,
+		Distributed: reserve.Distributed,
+		Held:        held,
+		Required:    required,
+		Shortfall:   shortfall,
+		Compliant:   shortfall == 0,
+	}
+}
+
+func (s *SmartContract) getBankReserveKey(bankID string) string {
+	return "bankreserve_" + bankID
+}
+
+func (s *SmartContract) getUserBankKey(userID string) string {
+	return "userbank_" + userID
+}
+
+func (s *SmartContract) getReserveRequirement(ctx contractapi.TransactionContextInterface) (*ReserveRequirement, error) {
+	requirementBytes, err := ctx.GetStub().GetState("reserve_requirement")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reserve requirement: %v", err)
+	}
+	if requirementBytes == nil {
+		return nil, nil
+	}
+
+	var requirement ReserveRequirement
+	err = s.decodeDocument("reserveRequirement", requirementBytes, &requirement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reserve requirement: %v", err)
+	}
+
+	return &requirement, nil
+}
+
+// getBankReserve returns an empty record for a bank that has not distributed yet
+func (s *SmartContract) getBankReserve(ctx contractapi.TransactionContextInterface, bankID string) (*BankReserve, error) {
+	reserveBytes, err := ctx.GetStub().GetState(s.getBankReserveKey(bankID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bank reserve: %v", err)
+	}
+
+	reserve := BankReserve{DocType: "bankReserve", BankID: bankID}
+	if reserveBytes != nil {
+		err = s.decodeDocument("bankReserve", reserveBytes, &reserve)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bank reserve: %v", err)
+		}
+	}
+
+	return &reserve, nil
+}
+
+func (s *SmartContract) putBankReserve(ctx contractapi.TransactionContextInterface, reserve *BankReserve) error {
+	reserve.SchemaVersion = s.currentSchemaVersion("bankReserve")
+	reserveJSON, err := json.Marshal(reserve)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bank reserve: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getBankReserveKey(reserve.BankID), reserveJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put bank reserve state: %v", err)
+	}
+	return nil
+}
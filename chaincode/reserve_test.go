@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestReserveRequirementBlocksDistribution(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+
+	l.asBank("bank1").mustFailWith("only central bank", "SetReserveRequirement", 0.2, true)
+	l.asCentralBank().mustFailWith("between 0 and 1", "SetReserveRequirement", 1.5, true)
+	l.mustInvoke("SetReserveRequirement", 0.2, true)
+
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 80.0)
+	l.mustFailWith("short of its reserve requirement", "TransferToUser", "alice", 10.0)
+
+	// Redemptions reduce what the user's bank has distributed
+	l.asUser("alice").mustInvoke("RedeemTokens", "alice", 30.0)
+	var reserve BankReserve
+	l.mustQuery(&reserve, "GetBankReserve", "bank1")
+	if reserve.Distributed != 50 || reserve.TotalDistributed != 80 || reserve.TotalRedeemed != 30 {
+		t.Fatalf("unexpected bank reserve %+v", reserve)
+	}
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 5.0)
+}
+
+func TestCheckReserveCompliance(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asCentralBank().mustInvoke("IssueTokens", 10.0)
+	l.mustInvoke("TransferToCB", "bank2", 10.0)
+	l.asBank("bank2").mustInvoke("TransferToUser", "bob", 5.0)
+
+	l.mustFailWith("no reserve requirement", "CheckReserveCompliance")
+	l.asCentralBank().mustInvoke("SetReserveRequirement", 0.5, false)
+
+	var report ReserveComplianceReport
+	l.mustQuery(&report, "CheckReserveCompliance")
+	if len(report.Banks) != 2 || len(report.Shortfalls) != 1 {
+		t.Fatalf("unexpected compliance report %+v", report)
+	}
+	shortfall := report.Shortfalls[0]
+	if shortfall.BankID != "bank1" || shortfall.Required != 50 || shortfall.Shortfall != 50 {
+		t.Fatalf("unexpected shortfall %+v", shortfall)
+	}
+
+	// An unenforced requirement only reports
+	l.fund("bank1", "carol", 10)
+}
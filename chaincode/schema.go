@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// schemaMigration upgrades a decoded document by one schema version in place
+type schemaMigration func(doc map[string]interface{}) error
+
+// schemaMigrations is the migration registry. Entry i of a docType's list upgrades version i+1
+// to i+2, so the current version of a docType is one more than its number of migrations.
+// Documents written before versioning carry no schemaVersion and count as version 1. Every stored
+// docType is listed, so a new migration only needs appending to its list.
+var schemaMigrations = map[string][]schemaMigration{
+	"balance": {
+		// 1 -> 2: balances written before currencies were introduced are in the default currency
+		setDefaultCurrency,
+	},
+	"transaction": {
+		// 1 -> 2: history written before currencies were introduced is in the default currency
+		setDefaultCurrency,
+	},
+	// Document types still at version 1
+	"accountAlias":        {},
+	"accountIdentities":   {},
+	"accountSettings":     {},
+	"allowance":           {},
+	"approvalPolicy":      {},
+	"bankReserve":         {},
+	"cheque":              {},
+	"chequeKey":           {},
+	"collateralPledge":    {},
+	"creditDrawdown":      {},
+	"creditLine":          {},
+	"currency":            {},
+	"dispute":             {},
+	"emergencyRole":       {},
+	"feeRevenue":          {},
+	"feeSchedule":         {},
+	"fxOffer":             {},
+	"interbankLimit":      {},
+	"interbankObligation": {},
+	"interbankUsage":      {},
+	"issuancePeriod":      {},
+	"issuancePolicy":      {},
+	"offlineVoucher":      {},
+	"offlineWallet":       {},
+	"overnightLoan":       {},
+	"pauseEvent":          {},
+	"pauseState":          {},
+	"paymentRequest":      {},
+	"proposal":            {},
+	"queuedPayment":       {},
+	"rateSchedule":        {},
+	"reserveRequirement":  {},
+	"servicingLink":       {},
+	"settlementCycle":     {},
+	"supply":              {},
+	"token":               {},
+	"tokenMetadata":       {},
+}
+
+// schemaKeyPrefixes lists the key prefixes each docType with migrations is stored under, so that
+// MigrateState scans only that docType's records. Prefixes are in key order. A docType needs an
+// entry once it gains a migration.
+var schemaKeyPrefixes = map[string][]string{
+	"balance":     {"balance_", "cbalance_"},
+	"transaction": {"tx_"},
+}
+
+// MigrationBatchResult reports one page of a MigrateState run
+type MigrationBatchResult struct {
+	DocType  string `json:"docType"`
+	Scanned  int    `json:"scanned"`
+	Migrated int    `json:"migrated"`
+	Bookmark string `json:"bookmark"` // Empty when every record of the docType has been covered
+}
+
+// MigrateState upgrades up to pageSize records of docType to its current schema version, starting
+// from bookmark (central bank administrators only). Only the docType's own keys are read. Pass
+// the returned bookmark to continue; an empty one starts over.
+func (s *SmartContract) MigrateState(ctx contractapi.TransactionContextInterface, docType string, bookmark string, pageSize int) (*MigrationBatchResult, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can migrate state: %v", err)
+	}
+
+	prefixes, ok := schemaKeyPrefixes[docType]
+	if !ok {
+		return nil, fmt.Errorf("%s has no migrations to run", docType)
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive")
+	}
+
+	result := &MigrationBatchResult{DocType: docType}
+	for _, prefix := range prefixes {
+		startKey, endKey := prefix, prefixRangeEnd(prefix)
+		if bookmark >= endKey {
+			continue
+		}
+		if bookmark > startKey {
+			startKey = bookmark
+		}
+
+		done, err := s.migrateRange(ctx, docType, startKey, endKey, pageSize, result)
+		if err != nil {
+			return nil, err
+		}
+		if !done {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// migrateRange migrates the records of docType from startKey up to endKey until the page is full.
+// It reports whether it reached endKey; otherwise result.Bookmark is where the next page starts.
+func (s *SmartContract) migrateRange(ctx contractapi.TransactionContextInterface, docType string, startKey string, endKey string, pageSize int, result *MigrationBatchResult) (bool, error) {
+	// Range pagination is not allowed in update transactions, so page by hand
+	resultsIterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s records: %v", docType, err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failed to get next record: %v", err)
+		}
+
+		if result.Scanned == pageSize {
+			result.Bookmark = queryResult.Key
+			return false, nil
+		}
+		result.Scanned++
+
+		var header documentHeader
+		if json.Unmarshal(queryResult.Value, &header) != nil || header.DocType != docType {
+			continue
+		}
+		if header.version() >= s.currentSchemaVersion(docType) {
+			continue
+		}
+
+		migrated, err := s.migrateDocument(docType, queryResult.Value)
+		if err != nil {
+			return false, fmt.Errorf("failed to migrate %s: %v", queryResult.Key, err)
+		}
+		err = ctx.GetStub().PutState(queryResult.Key, migrated)
+		if err != nil {
+			return false, fmt.Errorf("failed to put migrated %s: %v", queryResult.Key, err)
+		}
+		result.Migrated++
+	}
+
+	return true, nil
+}
+
+// prefixRangeEnd is the exclusive end key of a range scan over keys starting with prefix
+func prefixRangeEnd(prefix string) string {
+	return prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+}
+
+// documentHeader is the part of every document needed to pick its migrations
+type documentHeader struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+}
+
+func (h documentHeader) version() int {
+	if h.SchemaVersion == 0 {
+		return 1
+	}
+	return h.SchemaVersion
+}
+
+// currentSchemaVersion returns the version new documents of docType are written at
+func (s *SmartContract) currentSchemaVersion(docType string) int {
+	return len(schemaMigrations[docType]) + 1
+}
+
+// decodeDocument unmarshals data into v, first upgrading it if it was written at an older
+// schema version. Read paths use it so that both versions decode while MigrateState runs.
+func (s *SmartContract) decodeDocument(docType string, data []byte, v interface{}) error {
+	var header documentHeader
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return err
+	}
+
+	if header.version() < s.currentSchemaVersion(docType) {
+		data, err = s.migrateDocument(docType, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// migrateDocument applies the registered migrations from the document's version onwards and
+// stamps it with the current version
+func (s *SmartContract) migrateDocument(docType string, data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var header documentHeader
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+
+	current := s.currentSchemaVersion(docType)
+	if header.version() > current {
+		return nil, fmt.Errorf("%s schema version %d is newer than this chaincode's %d", docType, header.version(), current)
+	}
+	for version := header.version(); version < current; version++ {
+		err = schemaMigrations[docType][version-1](doc)
+		if err != nil {
+			return nil, fmt.Errorf("migration of %s from version %d failed: %v", docType, version, err)
+		}
+	}
+	doc["schemaVersion"] = current
+
+	return json.Marshal(doc)
+}
+
+func setDefaultCurrency(doc map[string]interface{}) error {
+	if currency, _ := doc["currency"].(string); currency == "" {
+		doc["currency"] = defaultCurrency
+	}
+	return nil
+}
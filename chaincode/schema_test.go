@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// putLegacyState writes a record the way a chaincode version before schema versioning did
+func (l *testLedger) putLegacyState(key string, value string) {
+	l.t.Helper()
+	l.stub.MockTransactionStart("legacy_" + key)
+	defer l.stub.MockTransactionEnd("legacy_" + key)
+	if err := l.stub.PutState(key, []byte(value)); err != nil {
+		l.t.Fatalf("failed to put legacy %s: %v", key, err)
+	}
+}
+
+func TestLegacyDocumentsDecode(t *testing.T) {
+	l := newTestLedger(t)
+	l.putLegacyState("balance_alice", `{"docType":"balance","accountId":"alice","balance":25,"modifiedAt":1}`)
+
+	var balance AccountBalance
+	l.asUser("alice").mustQuery(&balance, "GetBalance", "alice")
+	if balance.Balance != 25 || balance.Currency != defaultCurrency || balance.SchemaVersion != 2 {
+		t.Fatalf("legacy balance decoded as %+v", balance)
+	}
+
+	// Writes go out at the current version
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 5.0)
+	var header documentHeader
+	bobJSON, _ := l.stub.GetState("balance_bob")
+	if err := json.Unmarshal(bobJSON, &header); err != nil || header.SchemaVersion != 2 {
+		t.Fatalf("new balance written as %s", bobJSON)
+	}
+}
+
+func TestMigrateStateInPages(t *testing.T) {
+	l := newTestLedger(t)
+	l.putLegacyState("balance_alice", `{"docType":"balance","accountId":"alice","balance":25,"modifiedAt":1}`)
+	l.putLegacyState("balance_bob", `{"docType":"balance","accountId":"bob","balance":10,"modifiedAt":1}`)
+	l.putLegacyState("cbalance_EURC_carol", `{"docType":"balance","accountId":"carol","currency":"EURC","balance":5,"modifiedAt":1}`)
+	l.putLegacyState("tx_legacy", `{"docType":"transaction","txId":"legacy","from":"alice","to":"bob","amount":1}`)
+	l.putLegacyState("userbank_alice", "bank1")
+
+	l.asBank("bank1").mustFailWith("only central bank administrators", "MigrateState", "balance", "", 10)
+	l.asUser("mallory").mustFailWith("only central bank administrators", "MigrateState", "balance", "", 10)
+	l.asCentralBank().mustFailWith("page size must be positive", "MigrateState", "balance", "", 0)
+	l.mustFailWith("dispute has no migrations", "MigrateState", "dispute", "", 10)
+
+	migrated, scanned := 0, 0
+	bookmark, pages := "", 0
+	for {
+		var result MigrationBatchResult
+		l.mustQuery(&result, "MigrateState", "balance", bookmark, 2)
+		migrated += result.Migrated
+		scanned += result.Scanned
+		pages++
+		if result.Bookmark == "" {
+			break
+		}
+		bookmark = result.Bookmark
+	}
+	// Only balance records are read, across both balance key prefixes
+	if pages != 2 || migrated != 3 || scanned != 3 {
+		t.Fatalf("migrated %d of %d scanned balances in %d pages", migrated, scanned, pages)
+	}
+
+	aliceJSON, _ := l.stub.GetState("balance_alice")
+	var alice AccountBalance
+	if err := json.Unmarshal(aliceJSON, &alice); err != nil || alice.SchemaVersion != 2 || alice.Currency != defaultCurrency {
+		t.Fatalf("balance was not migrated in place: %s", aliceJSON)
+	}
+	carolJSON, _ := l.stub.GetState("cbalance_EURC_carol")
+	var carol AccountBalance
+	if err := json.Unmarshal(carolJSON, &carol); err != nil || carol.SchemaVersion != 2 || carol.Currency != "EURC" {
+		t.Fatalf("currency balance was not migrated in place: %s", carolJSON)
+	}
+
+	// A second run has nothing left to do, and history migrates separately
+	var rerun MigrationBatchResult
+	l.mustQuery(&rerun, "MigrateState", "balance", "", 10)
+	if rerun.Migrated != 0 || rerun.Scanned != 3 {
+		t.Fatalf("unexpected second migration run %+v", rerun)
+	}
+	var history MigrationBatchResult
+	l.mustQuery(&history, "MigrateState", "transaction", "", 10)
+	if history.Migrated != 1 || history.Scanned != 1 {
+		t.Fatalf("unexpected history migration %+v", history)
+	}
+}
+
+func TestEveryMigratedDocTypeHasKeyPrefixes(t *testing.T) {
+	for docType, migrations := range schemaMigrations {
+		if len(migrations) > 0 && len(schemaKeyPrefixes[docType]) == 0 {
+			t.Errorf("%s has migrations but no key prefixes for MigrateState", docType)
+		}
+	}
+}
+
+func TestBaselineTransfersWriteCurrentVersion(t *testing.T) {
+	l := newTestLedger(t)
+	l.fund("bank1", "alice", 100)
+	l.asUser("alice").mustInvoke("TransferTokens", "alice", "bob", 10.0)
+	l.mustInvoke("RedeemTokens", "alice", 10.0)
+
+	for _, key := range []string{"balance_central-bank", "balance_bank1", "balance_alice", "balance_bob"} {
+		var header documentHeader
+		data, _ := l.stub.GetState(key)
+		if err := json.Unmarshal(data, &header); err != nil || header.SchemaVersion != 2 {
+			t.Fatalf("%s was written as %s", key, data)
+		}
+	}
+}
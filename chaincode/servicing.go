@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ServicingLink records the commercial bank that services a user. The bank can act for the
+// user in recoveries, reversals and disputes, and receives its defunds and sweeps, so the link
+// is only ever set by the central bank or by the user accepting the bank's offer. Funding a
+// user does not change it.
+type ServicingLink struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	UserID        string `json:"userId"`
+	BankID        string `json:"bankId"`                                   // "" until a link is established
+	OfferedBy     string `json:"offeredBy,omitempty" metadata:",optional"` // Bank whose offer awaits the user's acceptance
+	LinkedBy      string `json:"linkedBy,omitempty" metadata:",optional"`  // The user, or the central bank administrator who assigned it
+	ModifiedAt    int64  `json:"modifiedAt"`
+}
+
+// OfferServicing offers to service a user (commercial banks only). The link takes effect once
+// the user accepts it.
+func (s *SmartContract) OfferServicing(ctx contractapi.TransactionContextInterface, userID string) (*ServicingLink, error) {
+	err := s.validateCallerIsCommercialBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only commercial banks can offer servicing: %v", err)
+	}
+
+	bankID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" || !s.isUserAccount(ctx, userID) {
+		return nil, fmt.Errorf("%s is not a user account", userID)
+	}
+
+	link, err := s.getServicingLink(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if link.BankID == bankID {
+		return nil, fmt.Errorf("%s already services %s", bankID, userID)
+	}
+	link.OfferedBy = bankID
+
+	err = s.putServicingLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// AcceptServicingBank links the caller to the bank that offered to service it, replacing any
+// earlier servicing bank
+func (s *SmartContract) AcceptServicingBank(ctx contractapi.TransactionContextInterface, bankID string) (*ServicingLink, error) {
+	userID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.getServicingLink(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if bankID == "" || link.OfferedBy != bankID {
+		return nil, fmt.Errorf("%s has not offered to service %s", bankID, userID)
+	}
+
+	link.BankID = bankID
+	link.OfferedBy = ""
+	link.LinkedBy = userID
+
+	err = s.putServicingLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// AssignServicingBank links a user to a commercial bank, or removes its link when bankID is
+// empty (central bank administrators only)
+func (s *SmartContract) AssignServicingBank(ctx contractapi.TransactionContextInterface, userID string, bankID string) (*ServicingLink, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can assign servicing banks: %v", err)
+	}
+
+	if userID == "" || !s.isUserAccount(ctx, userID) {
+		return nil, fmt.Errorf("%s is not a user account", userID)
+	}
+	if bankID != "" {
+		err = s.validateCommercialBank(ctx, bankID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	admin, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.getServicingLink(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	link.BankID = bankID
+	link.OfferedBy = ""
+	link.LinkedBy = admin
+
+	err = s.putServicingLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// GetServicingLink returns a user's servicing bank link and any pending offer
+func (s *SmartContract) GetServicingLink(ctx contractapi.TransactionContextInterface, userID string) (*ServicingLink, error) {
+	return s.getServicingLink(ctx, userID)
+}
+
+// getServicingBank returns the commercial bank linked to userID as its servicing bank, or "" if
+// it has none
+func (s *SmartContract) getServicingBank(ctx contractapi.TransactionContextInterface, userID string) (string, error) {
+	link, err := s.getServicingLink(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return link.BankID, nil
+}
+
+func (s *SmartContract) getServicingLinkKey(userID string) string {
+	return "servicingbank_" + userID
+}
+
+// getServicingLink returns an empty link for a user that has none
+func (s *SmartContract) getServicingLink(ctx contractapi.TransactionContextInterface, userID string) (*ServicingLink, error) {
+	linkBytes, err := ctx.GetStub().GetState(s.getServicingLinkKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read servicing link: %v", err)
+	}
+
+	link := ServicingLink{DocType: "servicingLink", UserID: userID}
+	if linkBytes != nil {
+		err = s.decodeDocument("servicingLink", linkBytes, &link)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal servicing link: %v", err)
+		}
+	}
+
+	return &link, nil
+}
+
+func (s *SmartContract) putServicingLink(ctx contractapi.TransactionContextInterface, link *ServicingLink) error {
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	link.ModifiedAt = now
+	link.SchemaVersion = s.currentSchemaVersion("servicingLink")
+
+	linkJSON, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("failed to marshal servicing link: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getServicingLinkKey(link.UserID), linkJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put servicing link state: %v", err)
+	}
+	return nil
+}
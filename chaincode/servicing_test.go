@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestServicingLinkNeedsUserConsent(t *testing.T) {
+	l := newTestLedger(t)
+
+	// Funding a user does not make the bank its servicing bank
+	l.fund("bank1", "alice", 50)
+	var link ServicingLink
+	l.mustQuery(&link, "GetServicingLink", "alice")
+	if link.BankID != "" {
+		t.Fatalf("funding linked alice to %s", link.BankID)
+	}
+
+	l.asUser("alice").mustFailWith("bank1 has not offered to service alice", "AcceptServicingBank", "bank1")
+	l.asUser("mallory").mustFailWith("only commercial banks", "OfferServicing", "alice")
+	l.asBank("bank1").mustFailWith("bank2 is not a user account", "OfferServicing", "bank2")
+	l.mustInvoke("OfferServicing", "alice")
+	l.asUser("alice").mustFailWith("bank2 has not offered", "AcceptServicingBank", "bank2")
+
+	var accepted ServicingLink
+	l.mustQuery(&accepted, "AcceptServicingBank", "bank1")
+	if accepted.BankID != "bank1" || accepted.OfferedBy != "" || accepted.LinkedBy != "alice" {
+		t.Fatalf("unexpected servicing link %+v", accepted)
+	}
+	l.asBank("bank1").mustFailWith("already services alice", "OfferServicing", "alice")
+
+	// A later funder's offer does nothing until alice accepts it
+	l.fund("bank2", "alice", 10)
+	l.asBank("bank2").mustInvoke("OfferServicing", "alice")
+	var offered ServicingLink
+	l.mustQuery(&offered, "GetServicingLink", "alice")
+	if offered.BankID != "bank1" || offered.OfferedBy != "bank2" {
+		t.Fatalf("unexpected servicing link with a pending offer %+v", offered)
+	}
+}
+
+func TestAssignServicingBank(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asUser("alice").mustFailWith("only central bank administrators", "AssignServicingBank", "alice", "bank1")
+	l.asCentralBank().mustFailWith("is not a user account", "AssignServicingBank", "bank2", "bank1")
+	l.mustFail("AssignServicingBank", "alice", "shop")
+
+	var link ServicingLink
+	l.mustQuery(&link, "AssignServicingBank", "alice", "bank1")
+	if link.BankID != "bank1" || link.LinkedBy != "admin" {
+		t.Fatalf("unexpected assigned link %+v", link)
+	}
+
+	var removed ServicingLink
+	l.mustQuery(&removed, "AssignServicingBank", "alice", "")
+	if removed.BankID != "" {
+		t.Fatalf("link was not removed %+v", removed)
+	}
+}
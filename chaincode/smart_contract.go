@@ -17,10 +17,12 @@ type SmartContract struct {
 // TokenAsset represents a CBDC token
 type TokenAsset struct {
 	DocType         string             `json:"docType"`
+	SchemaVersion   int                `json:"schemaVersion"`
 	ID              string             `json:"id"`
 	Owner           string             `json:"owner"`
 	Amount          float64            `json:"amount"`
 	IssuerID        string             `json:"issuerId"`
+	Currency        string             `json:"currency"`
 	Status          string             `json:"status"` // Active, Frozen, Burned
 	CreatedAt       int64              `json:"createdAt"`
 	ModifiedAt      int64              `json:"modifiedAt"`
@@ -30,27 +32,45 @@ type TokenAsset struct {
 
 // AccountBalance represents an account's balance
 type AccountBalance struct {
-	DocType    string  `json:"docType"`
-	AccountID  string  `json:"accountId"`
-	Balance    float64 `json:"balance"`
-	ModifiedAt int64   `json:"modifiedAt"`
+	DocType         string  `json:"docType"`
+	SchemaVersion   int     `json:"schemaVersion"`
+	AccountID       string  `json:"accountId"`
+	Currency        string  `json:"currency"`
+	Balance         float64 `json:"balance"`
+	HeldBalance     float64 `json:"heldBalance,omitempty" metadata:",optional"` // Funds on hold, not included in Balance
+	LastAccruedAt   int64   `json:"lastAccruedAt,omitempty" metadata:",optional"`
+	AccruedInterest float64 `json:"accruedInterest,omitempty" metadata:",optional"` // Net remuneration, negative under demurrage
+	ModifiedAt      int64   `json:"modifiedAt"`
+
+	pendingInterest float64 // Accrued on read, booked to supply when the balance is written
 }
 
 // TransactionHistory represents a transaction record
 type TransactionHistory struct {
-	DocType    string  `json:"docType"`
-	TxID       string  `json:"txId"`
-	FromID     string  `json:"fromId"`
-	ToID       string  `json:"toId"`
-	Amount     float64 `json:"amount"`
-	Type       string  `json:"type"` // Issue, Transfer, Redeem, CBToCommercial, CommercialToUser
-	Timestamp  int64   `json:"timestamp"`
+	DocType        string  `json:"docType"`
+	SchemaVersion  int     `json:"schemaVersion"`
+	TxID           string  `json:"txId"`
+	FromID         string  `json:"fromId"`
+	ToID           string  `json:"toId"`
+	Amount         float64 `json:"amount"`
+	Currency       string  `json:"currency"`
+	Type           string  `json:"type"` // Issue, Transfer, Redeem, CBToCommercial, CommercialToUser, InterbankTransfer, Defund, CommercialToCB, Waterfall, ReverseWaterfall, Refund, Reversal, Chargeback, FXSettlement, NetSettlement, TransferFrom, OfflinePayment, Cheque
+	Timestamp      int64   `json:"timestamp"`
+	OriginalTxID   string  `json:"originalTxId,omitempty" metadata:",optional"`   // Set on refunds and reversals
+	RefundedAmount float64 `json:"refundedAmount,omitempty" metadata:",optional"` // Total refunded or reversed against this transaction
+	Reason         string  `json:"reason,omitempty" metadata:",optional"`
+	Fee            float64 `json:"fee,omitempty" metadata:",optional"`
+	FeeAccountID   string  `json:"feeAccountId,omitempty" metadata:",optional"`
+	FeeChargedTo   string  `json:"feeChargedTo,omitempty" metadata:",optional"` // Payer or Payee
+	Leg            int     `json:"leg,omitempty" metadata:",optional"`          // Further records written by the same transaction
+	Reference      string  `json:"reference,omitempty" metadata:",optional"`    // Business reference such as an FX offer ID
+	PurposeCode    string  `json:"purposeCode,omitempty" metadata:",optional"`  // ISO 20022 purpose of an interbank transfer
 }
 
-// InitLedger initializes the chaincode
+// InitLedger initializes the chaincode. It records the token metadata served by the token
+// contract and can safely be invoked again.
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	// Nothing to initialize
-	return nil
+	return s.initTokenMetadata(ctx)
 }
 
 // IssueTokens mints new CBDC tokens (Central Bank only) - Will store in central bank's own account
@@ -61,25 +81,48 @@ func (s *SmartContract) IssueTokens(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("only central bank can issue tokens: %v", err)
 	}
 
+	// Issuance follows maker-checker once an approval policy is set
+	err = s.requireApproval(ctx, "IssueTokens")
+	if err != nil {
+		return err
+	}
+
+	currency, err := s.getCurrency(ctx, defaultCurrency)
+	if err != nil {
+		return err
+	}
+
+	return s.issueCurrency(ctx, currency, amount)
+}
+
+// issueCurrency mints tokens of a currency into its issuer's own account
+func (s *SmartContract) issueCurrency(ctx contractapi.TransactionContextInterface, currency *Currency, amount float64) error {
 	if amount <= 0 {
 		return fmt.Errorf("amount must be positive")
 	}
 
-	// Central Bank's own account ID
-	centralBankID := s.getCentralBankID()
+	if currency.Status != "Active" {
+		return fmt.Errorf("currency %s is %s", currency.Code, currency.Status)
+	}
 
-	// Get current balance of central bank
-	balance, err := s.getAccountBalance(ctx, centralBankID)
-	if err != nil {
-		balance = &AccountBalance{
-			DocType:    "balance",
-			AccountID:  centralBankID,
-			Balance:    0,
-			ModifiedAt: time.Now().Unix(),
+	// Enforce the issuance policy on the default currency
+	if currency.Code == defaultCurrency {
+		err := s.useIssuanceHeadroom(ctx, amount)
+		if err != nil {
+			return err
 		}
 	}
 
-	// Update central bank balance
+	// Issuer's own account ID
+	issuerAccountID := currency.IssuerAccountID
+
+	// Get current balance of the issuer
+	balance, err := s.getCurrencyBalance(ctx, issuerAccountID, currency.Code)
+	if err != nil {
+		return fmt.Errorf("failed to get issuer balance: %v", err)
+	}
+
+	// Update issuer balance
 	balance.Balance += amount
 	balance.ModifiedAt = time.Now().Unix()
 
@@ -96,7 +139,8 @@ func (s *SmartContract) IssueTokens(ctx contractapi.TransactionContextInterface,
 		ID:              tokenID,
 		Owner:           owner,
 		Amount:          amount,
-		IssuerID:        centralBankID,
+		IssuerID:        issuerAccountID,
+		Currency:        currency.Code,
 		Status:          "Active",
 		CreatedAt:       time.Now().Unix(),
 		ModifiedAt:      time.Now().Unix(),
@@ -104,6 +148,7 @@ func (s *SmartContract) IssueTokens(ctx contractapi.TransactionContextInterface,
 	}
 
 	// Save token
+	token.SchemaVersion = s.currentSchemaVersion("token")
 	tokenJSON, err := json.Marshal(token)
 	if err != nil {
 		return fmt.Errorf("failed to marshal token: %v", err)
@@ -114,19 +159,23 @@ func (s *SmartContract) IssueTokens(ctx contractapi.TransactionContextInterface,
 	}
 
 	// Save balance
-	balanceJSON, err := json.Marshal(balance)
+	err = s.putAccountBalance(ctx, balance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal balance: %v", err)
+		return err
 	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(centralBankID), balanceJSON)
+
+	// Update supply accounting
+	err = s.updateCurrencySupply(ctx, currency.Code, func(supply *SupplyRecord) {
+		supply.TotalIssued += amount
+	})
 	if err != nil {
-		return fmt.Errorf("failed to put balance state: %v", err)
+		return err
 	}
 
-	// Record transaction - issue to central bank's own account
-	s.recordTransaction(ctx, "", centralBankID, amount, "Issue")
-
-	return nil
+	// Record transaction - issue to the issuer's own account
+	transaction := s.newTransaction(ctx, "", issuerAccountID, amount, "Issue")
+	transaction.Currency = currency.Code
+	return s.putTransaction(ctx, transaction)
 }
 
 // TransferToCB transfers CBDC tokens from Central Bank to Commercial Bank
@@ -141,6 +190,12 @@ func (s *SmartContract) TransferToCB(ctx contractapi.TransactionContextInterface
 		return fmt.Errorf("amount must be positive")
 	}
 
+	// Large transfers follow maker-checker once an approval policy is set
+	err = s.requireTransferApproval(ctx, amount)
+	if err != nil {
+		return err
+	}
+
 	// Validate commercial bank ID (should be from Org2)
 	err = s.validateCommercialBank(ctx, commercialBankID)
 	if err != nil {
@@ -164,10 +219,11 @@ func (s *SmartContract) TransferToCB(ctx contractapi.TransactionContextInterface
 	commBalance, err := s.getAccountBalance(ctx, commercialBankID)
 	if err != nil {
 		commBalance = &AccountBalance{
-			DocType:    "balance",
-			AccountID:  commercialBankID,
-			Balance:    0,
-			ModifiedAt: time.Now().Unix(),
+			DocType:       "balance",
+			SchemaVersion: s.currentSchemaVersion("balance"),
+			AccountID:     commercialBankID,
+			Balance:       0,
+			ModifiedAt:    time.Now().Unix(),
 		}
 	}
 
@@ -179,29 +235,22 @@ func (s *SmartContract) TransferToCB(ctx contractapi.TransactionContextInterface
 	commBalance.ModifiedAt = currentTime
 
 	// Save central bank balance
-	cbBalanceJSON, err := json.Marshal(cbBalance)
+	err = s.putAccountBalance(ctx, cbBalance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal central bank balance: %v", err)
-	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(centralBankID), cbBalanceJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update central bank balance: %v", err)
+		return err
 	}
 
 	// Save commercial bank balance
-	commBalanceJSON, err := json.Marshal(commBalance)
+	err = s.putAccountBalance(ctx, commBalance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal commercial bank balance: %v", err)
-	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(commercialBankID), commBalanceJSON)
-	if err != nil {
-		return fmt.Errorf("failed to update commercial bank balance: %v", err)
+		return err
 	}
 
 	// Record transaction
 	s.recordTransaction(ctx, centralBankID, commercialBankID, amount, "CBToCommercial")
 
-	return nil
+	// Release any payments the commercial bank has queued
+	return s.releaseQueuedPayments(ctx, commercialBankID)
 }
 
 // TransferToUser transfers CBDC tokens from Commercial Bank to end user
@@ -222,6 +271,18 @@ func (s *SmartContract) TransferToUser(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("amount must be positive")
 	}
 
+	// Block distribution that would breach an enforced reserve requirement
+	err = s.checkDistributionAllowed(ctx, caller, amount)
+	if err != nil {
+		return err
+	}
+
+	// Draw on the bank's intraday credit line if its balance falls short
+	err = s.drawIntradayCredit(ctx, caller, amount)
+	if err != nil {
+		return err
+	}
+
 	// Get commercial bank balance
 	bankBalance, err := s.getAccountBalance(ctx, caller)
 	if err != nil {
@@ -237,10 +298,11 @@ func (s *SmartContract) TransferToUser(ctx contractapi.TransactionContextInterfa
 	userBalance, err := s.getAccountBalance(ctx, userID)
 	if err != nil {
 		userBalance = &AccountBalance{
-			DocType:    "balance",
-			AccountID:  userID,
-			Balance:    0,
-			ModifiedAt: time.Now().Unix(),
+			DocType:       "balance",
+			SchemaVersion: s.currentSchemaVersion("balance"),
+			AccountID:     userID,
+			Balance:       0,
+			ModifiedAt:    time.Now().Unix(),
 		}
 	}
 
@@ -252,29 +314,40 @@ func (s *SmartContract) TransferToUser(ctx contractapi.TransactionContextInterfa
 	userBalance.ModifiedAt = currentTime
 
 	// Save bank balance
-	bankBalanceJSON, err := json.Marshal(bankBalance)
-	if err != nil {
-		return fmt.Errorf("failed to marshal bank balance: %v", err)
-	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(caller), bankBalanceJSON)
+	err = s.putAccountBalance(ctx, bankBalance)
 	if err != nil {
-		return fmt.Errorf("failed to update bank balance: %v", err)
+		return err
 	}
 
 	// Save user balance
-	userBalanceJSON, err := json.Marshal(userBalance)
+	err = s.putAccountBalance(ctx, userBalance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal user balance: %v", err)
+		return err
 	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(userID), userBalanceJSON)
+
+	// Charge any distribution fee set by the bank
+	fee, err := s.chargeFee(ctx, "CommercialToUser", caller, caller, userID, amount)
 	if err != nil {
-		return fmt.Errorf("failed to update user balance: %v", err)
+		return err
 	}
 
 	// Record transaction
-	s.recordTransaction(ctx, caller, userID, amount, "CommercialToUser")
+	s.recordTransactionWithFee(ctx, caller, userID, amount, "CommercialToUser", fee)
 
-	return nil
+	// Track the distribution for reserve requirements
+	err = s.recordDistribution(ctx, caller, userID, amount)
+	if err != nil {
+		return err
+	}
+
+	// Sweep anything above the user's holding limit to its bank
+	err = s.applyWaterfall(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Release any payments the user has queued
+	return s.releaseQueuedPayments(ctx, userID)
 }
 
 // TransferTokens transfers CBDC tokens between accounts (user to user)
@@ -362,6 +435,16 @@ func (s *SmartContract) TransferTokens(ctx contractapi.TransactionContextInterfa
 		return fmt.Errorf("caller not authorized to transfer from this account")
 	}
 
+	// Draw on the sender's intraday credit line or linked bank if its balance falls short
+	err = s.drawIntradayCredit(ctx, fromID, amount)
+	if err != nil {
+		return err
+	}
+	err = s.applyReverseWaterfall(ctx, fromID, amount)
+	if err != nil {
+		return err
+	}
+
 	// Get sender's balance
 	senderBalance, err := s.getAccountBalance(ctx, fromID)
 	if err != nil {
@@ -387,29 +470,34 @@ func (s *SmartContract) TransferTokens(ctx contractapi.TransactionContextInterfa
 	receiverBalance.ModifiedAt = currentTime
 
 	// Save sender's balance
-	senderBalanceJSON, err := json.Marshal(senderBalance)
-	if err != nil {
-		return fmt.Errorf("failed to marshal sender balance: %v", err)
-	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(fromID), senderBalanceJSON)
+	err = s.putAccountBalance(ctx, senderBalance)
 	if err != nil {
-		return fmt.Errorf("failed to update sender balance: %v", err)
+		return err
 	}
 
 	// Save receiver's balance
-	receiverBalanceJSON, err := json.Marshal(receiverBalance)
+	err = s.putAccountBalance(ctx, receiverBalance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal receiver balance: %v", err)
+		return err
 	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(toID), receiverBalanceJSON)
+
+	// Charge any transfer fee
+	fee, err := s.chargeUserFee(ctx, "Transfer", fromID, toID, amount)
 	if err != nil {
-		return fmt.Errorf("failed to update receiver balance: %v", err)
+		return err
 	}
 
 	// Record transaction
-	s.recordTransaction(ctx, fromID, toID, amount, "Transfer")
+	s.recordTransactionWithFee(ctx, fromID, toID, amount, "Transfer", fee)
 
-	return nil
+	// Sweep anything above the receiver's holding limit to its bank
+	err = s.applyWaterfall(ctx, toID)
+	if err != nil {
+		return err
+	}
+
+	// Release any payments the receiver has queued
+	return s.releaseQueuedPayments(ctx, toID)
 }
 
 
@@ -444,13 +532,23 @@ func (s *SmartContract) RedeemTokens(ctx contractapi.TransactionContextInterface
 	balance.ModifiedAt = time.Now().Unix()
 
 	// Save balance
-	balanceJSON, err := json.Marshal(balance)
+	err = s.putAccountBalance(ctx, balance)
 	if err != nil {
-		return fmt.Errorf("failed to marshal balance: %v", err)
+		return err
 	}
-	err = ctx.GetStub().PutState(s.getBalanceKey(accountID), balanceJSON)
+
+	// Update supply accounting
+	err = s.updateSupply(ctx, func(supply *SupplyRecord) {
+		supply.TotalRedeemed += amount
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update balance: %v", err)
+		return err
+	}
+
+	// A user's redemption reduces what its bank has distributed
+	err = s.recordUserRedemption(ctx, accountID, amount)
+	if err != nil {
+		return err
 	}
 
 	// Record transaction
@@ -494,7 +592,7 @@ func (s *SmartContract) GetTransactionHistory(ctx contractapi.TransactionContext
 		}
 
 		var transaction TransactionHistory
-		err = json.Unmarshal(queryResult.Value, &transaction)
+		err = s.decodeDocument("transaction", queryResult.Value, &transaction)
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal transaction: %v", err)
 		}
@@ -518,6 +616,26 @@ func (s *SmartContract) validateCentralBank(ctx contractapi.TransactionContextIn
 	return nil
 }
 
+// validateCentralBankAdmin checks that the caller is a central bank administrator. End users
+// also enrol in Org1, so the MSP alone does not make a caller the central bank.
+func (s *SmartContract) validateCentralBankAdmin(ctx contractapi.TransactionContextInterface) error {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return err
+	}
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	// Fabric node OUs mark administrators with the admin OU
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if ou == "admin" {
+			return nil
+		}
+	}
+	return fmt.Errorf("caller is not a central bank administrator")
+}
+
 func (s *SmartContract) validateCommercialBank(ctx contractapi.TransactionContextInterface, bankID string) error {
 	// In a real implementation, this would check if the bankID corresponds to a registered commercial bank
 	// For now, we'll check if the ID starts with "bank" as a simple validation
@@ -552,8 +670,9 @@ func (s *SmartContract) getCallerID(ctx contractapi.TransactionContextInterface)
 		return "", fmt.Errorf("certificate common name not found")
 	}
 	
-	// Return just the username part (e.g., "user12211" from "user12211@org1.example.com")
-	return strings.Split(commonName, "@")[0], nil
+	// Pass just the username part (e.g., "user12211" from "user12211@org1.example.com") for
+	// accounts that predate canonical IDs
+	return s.resolveCallerAccount(ctx, cert, strings.Split(commonName, "@")[0])
 }
 
 func (s *SmartContract) getCentralBankID() string {
@@ -564,6 +683,19 @@ func (s *SmartContract) getBalanceKey(accountID string) string {
 	return "balance_" + accountID
 }
 
+// getCurrencyBalanceKey keeps default-currency balances under their original keys
+func (s *SmartContract) getCurrencyBalanceKey(accountID string, currency string) string {
+	if currency == "" || currency == defaultCurrency {
+		return s.getBalanceKey(accountID)
+	}
+	return "cbalance_" + currency + "_" + accountID
+}
+
+// getBalanceKeyRangeEnd is the exclusive end key for range scans over all balances ('`' sorts right after '_')
+func (s *SmartContract) getBalanceKeyRangeEnd() string {
+	return "balance`"
+}
+
 // func (s *SmartContract) getAccountBalance(ctx contractapi.TransactionContextInterface, accountID string) (*AccountBalance, error) {
 // 	balanceBytes, err := ctx.GetStub().GetState(s.getBalanceKey(accountID))
 // 	if err != nil {
@@ -582,52 +714,183 @@ func (s *SmartContract) getBalanceKey(accountID string) string {
 // 	return &balance, nil
 // }
 
-// getAccountBalance retrieves the balance of the specified account.
+// getAccountBalance retrieves the balance of the specified account in the default currency.
 func (s *SmartContract) getAccountBalance(ctx contractapi.TransactionContextInterface, accountID string) (*AccountBalance, error) {
-	accountKey := s.getBalanceKey(accountID)
+	return s.getCurrencyBalance(ctx, accountID, defaultCurrency)
+}
+
+// getCurrencyBalance retrieves the balance of the specified account in the given currency.
+func (s *SmartContract) getCurrencyBalance(ctx contractapi.TransactionContextInterface, accountID string, currency string) (*AccountBalance, error) {
+	accountKey := s.getCurrencyBalanceKey(accountID, currency)
 	accountBytes, err := ctx.GetStub().GetState(accountKey)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
 
-	// If balance is not found, return a zero-balance account
-	if accountBytes == nil {
-		return &AccountBalance{
-			DocType:    "balance",
-			AccountID:  accountID,
-			Balance:    0,
-			ModifiedAt: time.Now().Unix(),
-		}, nil
+	// If balance is not found, start from a zero-balance account
+	accountBalance := AccountBalance{
+		DocType:       "balance",
+		SchemaVersion: s.currentSchemaVersion("balance"),
+		AccountID:     accountID,
+		Balance:       0,
+		ModifiedAt:    time.Now().Unix(),
+	}
+	if accountBytes != nil {
+		err = s.decodeDocument("balance", accountBytes, &accountBalance)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal account balance: %v", err)
+		}
 	}
+	// Balances written before currencies were introduced are in the default currency
+	accountBalance.Currency = currency
 
-	var accountBalance AccountBalance
-	err = json.Unmarshal(accountBytes, &accountBalance)
+	// Bring remuneration up to date before the balance is used. Nothing is written here, so
+	// queries stay read-only; the accrual is kept if the caller writes the balance back.
+	err = s.accrueInterest(ctx, &accountBalance)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal account balance: %v", err)
+		return nil, err
 	}
 
 	return &accountBalance, nil
 }
 
+// putAccountBalance writes the given balance back to the world state.
+func (s *SmartContract) putAccountBalance(ctx contractapi.TransactionContextInterface, balance *AccountBalance) error {
+	balance.SchemaVersion = s.currentSchemaVersion("balance")
+	balanceJSON, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("failed to marshal balance: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getCurrencyBalanceKey(balance.AccountID, balance.Currency), balanceJSON)
+	if err != nil {
+		return fmt.Errorf("failed to update balance of %s: %v", balance.AccountID, err)
+	}
+
+	// Interest accrued when the balance was read enters the supply with the balance
+	interest := balance.pendingInterest
+	if interest == 0 {
+		return nil
+	}
+	balance.pendingInterest = 0
+	return s.updateSupply(ctx, func(supply *SupplyRecord) {
+		if interest > 0 {
+			supply.InterestPaid += interest
+		} else {
+			supply.DemurrageCharged -= interest
+		}
+	})
+}
+
+// moveFunds debits fromID and credits toID in the default currency, failing if fromID cannot cover the amount.
+func (s *SmartContract) moveFunds(ctx contractapi.TransactionContextInterface, fromID string, toID string, amount float64) error {
+	return s.moveCurrencyFunds(ctx, fromID, toID, defaultCurrency, amount)
+}
+
+// moveCurrencyFunds debits fromID and credits toID in the given currency, failing if fromID cannot cover the amount.
+func (s *SmartContract) moveCurrencyFunds(ctx contractapi.TransactionContextInterface, fromID string, toID string, currency string, amount float64) error {
+	if fromID == toID {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+
+	// Commercial banks draw on their intraday credit line and users on their linked bank rather than fail
+	if currency == defaultCurrency {
+		err := s.drawIntradayCredit(ctx, fromID, amount)
+		if err != nil {
+			return err
+		}
+		err = s.applyReverseWaterfall(ctx, fromID, amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	senderBalance, err := s.getCurrencyBalance(ctx, fromID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to get sender balance: %v", err)
+	}
+	if senderBalance.Balance < amount {
+		return fmt.Errorf("Insufficient balance for %s. Available: %.2f, Required: %.2f", fromID, senderBalance.Balance, amount)
+	}
+
+	receiverBalance, err := s.getCurrencyBalance(ctx, toID, currency)
+	if err != nil {
+		return fmt.Errorf("failed to get receiver balance: %v", err)
+	}
+
+	senderBalance.Balance -= amount
+	receiverBalance.Balance += amount
+	currentTime := time.Now().Unix()
+	senderBalance.ModifiedAt = currentTime
+	receiverBalance.ModifiedAt = currentTime
+
+	if err := s.putAccountBalance(ctx, senderBalance); err != nil {
+		return err
+	}
+	if err := s.putAccountBalance(ctx, receiverBalance); err != nil {
+		return err
+	}
+
+	// Sweep anything above the receiver's holding limit to its bank
+	if currency == defaultCurrency {
+		return s.applyWaterfall(ctx, toID)
+	}
+	return nil
+}
+
+// getTxTimestamp returns the proposal timestamp in Unix seconds, which is identical on every endorser.
+func (s *SmartContract) getTxTimestamp(ctx contractapi.TransactionContextInterface) (int64, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return ts.GetSeconds(), nil
+}
+
 
 func (s *SmartContract) recordTransaction(ctx contractapi.TransactionContextInterface, fromID string, toID string, amount float64, txType string) error {
-	transaction := TransactionHistory{
-		DocType:   "transaction",
-		TxID:      ctx.GetStub().GetTxID(),
-		FromID:    fromID,
-		ToID:      toID,
-		Amount:    amount,
-		Type:      txType,
-		Timestamp: time.Now().Unix(),
+	return s.putTransaction(ctx, s.newTransaction(ctx, fromID, toID, amount, txType))
+}
+
+// newTransaction builds a default-currency history record for the current transaction, stamped
+// with the transaction timestamp so that every endorser writes the same record.
+func (s *SmartContract) newTransaction(ctx contractapi.TransactionContextInterface, fromID string, toID string, amount float64, txType string) *TransactionHistory {
+	transaction := &TransactionHistory{
+		DocType:  "transaction",
+		TxID:     ctx.GetStub().GetTxID(),
+		FromID:   fromID,
+		ToID:     toID,
+		Amount:   amount,
+		Currency: defaultCurrency,
+		Type:     txType,
+	}
+	if ts, err := ctx.GetStub().GetTxTimestamp(); err == nil {
+		transaction.Timestamp = ts.GetSeconds()
+	}
+	return transaction
+}
+
+// recordTransactionWithFee records a transaction together with the fee charged on it, if any.
+func (s *SmartContract) recordTransactionWithFee(ctx contractapi.TransactionContextInterface, fromID string, toID string, amount float64, txType string, fee *FeeCharge) error {
+	transaction := s.newTransaction(ctx, fromID, toID, amount, txType)
+	if fee != nil {
+		transaction.Fee = fee.Amount
+		transaction.FeeAccountID = fee.AccountID
+		transaction.FeeChargedTo = fee.ChargedTo
 	}
 
+	return s.putTransaction(ctx, transaction)
+}
+
+// putTransaction writes a transaction history record keyed by its transaction ID and leg.
+func (s *SmartContract) putTransaction(ctx contractapi.TransactionContextInterface, transaction *TransactionHistory) error {
+	transaction.SchemaVersion = s.currentSchemaVersion("transaction")
 	transactionJSON, err := json.Marshal(transaction)
 	if err != nil {
 		return fmt.Errorf("failed to marshal transaction: %v", err)
 	}
 
-	err = ctx.GetStub().PutState("tx_"+transaction.TxID, transactionJSON)
+	err = ctx.GetStub().PutState(s.getTransactionKey(transaction.TxID, transaction.Leg), transactionJSON)
 	if err != nil {
 		return fmt.Errorf("failed to record transaction: %v", err)
 	}
@@ -635,8 +898,64 @@ func (s *SmartContract) recordTransaction(ctx contractapi.TransactionContextInte
 	return nil
 }
 
+// nextTransactionLeg returns the first unused leg after the first one, for records such as
+// waterfall sweeps that are added to whatever the transaction itself records
+func (s *SmartContract) nextTransactionLeg(ctx contractapi.TransactionContextInterface) (int, error) {
+	txID := ctx.GetStub().GetTxID()
+	for leg := 1; ; leg++ {
+		transactionBytes, err := ctx.GetStub().GetState(s.getTransactionKey(txID, leg))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read transaction: %v", err)
+		}
+		if transactionBytes == nil {
+			return leg, nil
+		}
+	}
+}
+
+// getTransactionKey keeps the first leg of a transaction under the plain "tx_" key.
+func (s *SmartContract) getTransactionKey(txID string, leg int) string {
+	if leg == 0 {
+		return "tx_" + txID
+	}
+	return fmt.Sprintf("tx_%s_%d", txID, leg)
+}
+
+// getTransaction reads the first history record of a transaction by its transaction ID.
+func (s *SmartContract) getTransaction(ctx contractapi.TransactionContextInterface, txID string) (*TransactionHistory, error) {
+	transactionBytes, err := ctx.GetStub().GetState(s.getTransactionKey(txID, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transaction: %v", err)
+	}
+	if transactionBytes == nil {
+		return nil, fmt.Errorf("transaction %s does not exist", txID)
+	}
+
+	var transaction TransactionHistory
+	err = s.decodeDocument("transaction", transactionBytes, &transaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transaction: %v", err)
+	}
+
+	return &transaction, nil
+}
+
+// newChaincode assembles the CBDC chaincode from its contracts
+func newChaincode() (*contractapi.ContractChaincode, error) {
+	smartContract := &SmartContract{}
+	smartContract.TransactionContextHandler = new(TransactionContext)
+	smartContract.BeforeTransaction = smartContract.beforeTransaction
+
+	tokenContract := &TokenContract{}
+	tokenContract.Contract.Name = tokenContractName
+	tokenContract.TransactionContextHandler = new(TransactionContext)
+	tokenContract.BeforeTransaction = smartContract.beforeTransaction
+
+	return contractapi.NewChaincode(smartContract, tokenContract)
+}
+
 func main() {
-	chaincode, err := contractapi.NewChaincode(&SmartContract{})
+	chaincode, err := newChaincode()
 	if err != nil {
 		fmt.Printf("Error creating CBDC chaincode: %v", err)
 		return
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SupplyRecord tracks the amount of a currency in circulation
+type SupplyRecord struct {
+	DocType          string  `json:"docType"`
+	SchemaVersion    int     `json:"schemaVersion"`
+	Currency         string  `json:"currency"`
+	TotalSupply      float64 `json:"totalSupply"`
+	SeededSupply     float64 `json:"seededSupply,omitempty" metadata:",optional"` // Held in balances before supply was tracked
+	SeededAt         int64   `json:"seededAt,omitempty" metadata:",optional"`
+	TotalIssued      float64 `json:"totalIssued"`
+	TotalRedeemed    float64 `json:"totalRedeemed"`
+	InterestPaid     float64 `json:"interestPaid"`
+	DemurrageCharged float64 `json:"demurrageCharged"`
+	ModifiedAt       int64   `json:"modifiedAt"`
+}
+
+// GetSupply returns the supply accounting record of the default currency
+func (s *SmartContract) GetSupply(ctx contractapi.TransactionContextInterface) (*SupplyRecord, error) {
+	return s.getCurrencySupply(ctx, defaultCurrency)
+}
+
+// GetCurrencySupply returns the supply accounting record of a currency
+func (s *SmartContract) GetCurrencySupply(ctx contractapi.TransactionContextInterface, currency string) (*SupplyRecord, error) {
+	return s.getCurrencySupply(ctx, currency)
+}
+
+// SeedSupply adds the default currency held before supply accounting was introduced to the
+// supply record (central bank administrators only). It reads every default-currency balance in
+// one transaction, so that transfers cannot slip between pages, and can only run once; run it
+// once after upgrading a ledger that already holds balances.
+func (s *SmartContract) SeedSupply(ctx contractapi.TransactionContextInterface) (*SupplyRecord, error) {
+	err := s.validateCentralBankAdmin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank administrators can seed the supply: %v", err)
+	}
+
+	supply, err := s.getCurrencySupply(ctx, defaultCurrency)
+	if err != nil {
+		return nil, err
+	}
+	if supply.SeededAt != 0 {
+		return nil, fmt.Errorf("supply has already been seeded")
+	}
+
+	held, err := s.sumStoredBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.updateSupply(ctx, func(supply *SupplyRecord) {
+		supply.SeededSupply = held - supply.TotalSupply
+		supply.SeededAt = now
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getCurrencySupply(ctx, defaultCurrency)
+}
+
+// sumStoredBalances totals every default-currency balance, including held funds, as stored.
+// Balances are not accrued, so the total matches the supply tracked so far.
+func (s *SmartContract) sumStoredBalances(ctx contractapi.TransactionContextInterface) (float64, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(s.getBalanceKey(""), s.getBalanceKeyRangeEnd())
+	if err != nil {
+		return 0, fmt.Errorf("failed to read balances: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	held := 0.0
+	for resultsIterator.HasNext() {
+		queryResult, err := resultsIterator.Next()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get next balance: %v", err)
+		}
+
+		var balance AccountBalance
+		err = s.decodeDocument("balance", queryResult.Value, &balance)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal account balance: %v", err)
+		}
+		held += balance.Balance + balance.HeldBalance
+	}
+
+	return held, nil
+}
+
+func (s *SmartContract) getSupplyKey(currency string) string {
+	if currency == defaultCurrency {
+		return "supply"
+	}
+	return "supply_" + currency
+}
+
+func (s *SmartContract) getCurrencySupply(ctx contractapi.TransactionContextInterface, currency string) (*SupplyRecord, error) {
+	supplyBytes, err := ctx.GetStub().GetState(s.getSupplyKey(currency))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read supply: %v", err)
+	}
+
+	supply := SupplyRecord{DocType: "supply"}
+	if supplyBytes != nil {
+		err = s.decodeDocument("supply", supplyBytes, &supply)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal supply: %v", err)
+		}
+	}
+	supply.Currency = currency
+
+	return &supply, nil
+}
+
+// updateSupply applies change to the default currency's supply record
+func (s *SmartContract) updateSupply(ctx contractapi.TransactionContextInterface, change func(supply *SupplyRecord)) error {
+	return s.updateCurrencySupply(ctx, defaultCurrency, change)
+}
+
+// updateCurrencySupply applies change to a currency's supply record and recomputes the total in circulation
+func (s *SmartContract) updateCurrencySupply(ctx contractapi.TransactionContextInterface, currency string, change func(supply *SupplyRecord)) error {
+	supply, err := s.getCurrencySupply(ctx, currency)
+	if err != nil {
+		return err
+	}
+
+	now, err := s.getTxTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	change(supply)
+	supply.TotalSupply = supply.SeededSupply + supply.TotalIssued - supply.TotalRedeemed + supply.InterestPaid - supply.DemurrageCharged
+	supply.ModifiedAt = now
+
+	supply.SchemaVersion = s.currentSchemaVersion("supply")
+	supplyJSON, err := json.Marshal(supply)
+	if err != nil {
+		return fmt.Errorf("failed to marshal supply: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getSupplyKey(currency), supplyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put supply state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// tokenContractName is the name wallets use to reach TokenContract, e.g. token:BalanceOf
+const tokenContractName = "token"
+
+// TokenMetadata describes the default currency to token standard clients
+type TokenMetadata struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Name          string `json:"name"`
+	Symbol        string `json:"symbol"`
+	Decimals      int    `json:"decimals"`
+	CreatedAt     int64  `json:"createdAt"`
+}
+
+// TokenContract exposes the default currency through the query surface of the fabric-samples
+// ERC-20 token contract, alongside SmartContract in the same chaincode
+type TokenContract struct {
+	contractapi.Contract
+	cbdc SmartContract
+}
+
+// Name returns the token's name
+func (t *TokenContract) Name(ctx contractapi.TransactionContextInterface) (string, error) {
+	metadata, err := t.getTokenMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Name, nil
+}
+
+// Symbol returns the token's symbol
+func (t *TokenContract) Symbol(ctx contractapi.TransactionContextInterface) (string, error) {
+	metadata, err := t.getTokenMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Symbol, nil
+}
+
+// Decimals returns the number of decimal places amounts are expressed in
+func (t *TokenContract) Decimals(ctx contractapi.TransactionContextInterface) (int, error) {
+	metadata, err := t.getTokenMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return metadata.Decimals, nil
+}
+
+// TotalSupply returns the amount of the default currency in circulation. Until SeedSupply has
+// counted the balances held before supply was tracked, it totals the balances instead.
+func (t *TokenContract) TotalSupply(ctx contractapi.TransactionContextInterface) (float64, error) {
+	_, err := t.getTokenMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	supply, err := t.cbdc.getCurrencySupply(ctx, defaultCurrency)
+	if err != nil {
+		return 0, err
+	}
+	if supply.SeededAt == 0 {
+		return t.cbdc.sumStoredBalances(ctx)
+	}
+	return supply.TotalSupply, nil
+}
+
+// BalanceOf returns an account's available balance, excluding funds on hold, with interest
+// accrued up to now. It writes nothing.
+func (t *TokenContract) BalanceOf(ctx contractapi.TransactionContextInterface, accountID string) (float64, error) {
+	_, err := t.getTokenMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	balance, err := t.cbdc.getAccountBalance(ctx, accountID)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Balance, nil
+}
+
+// ClientAccountID returns the account ID the caller acts for
+func (t *TokenContract) ClientAccountID(ctx contractapi.TransactionContextInterface) (string, error) {
+	return t.cbdc.getCallerID(ctx)
+}
+
+// initTokenMetadata records the token metadata of the default currency once
+func (s *SmartContract) initTokenMetadata(ctx contractapi.TransactionContextInterface) error {
+	metadataBytes, err := ctx.GetStub().GetState("token_metadata")
+	if err != nil {
+		return fmt.Errorf("failed to read token metadata: %v", err)
+	}
+	if metadataBytes != nil {
+		return nil
+	}
+
+	currency := s.getDefaultCurrency()
+	metadata := &TokenMetadata{
+		DocType:   "tokenMetadata",
+		Name:      currency.Name,
+		Symbol:    currency.Code,
+		Decimals:  currency.Decimals,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	metadata.SchemaVersion = s.currentSchemaVersion("tokenMetadata")
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token metadata: %v", err)
+	}
+	err = ctx.GetStub().PutState("token_metadata", metadataJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put token metadata: %v", err)
+	}
+	return nil
+}
+
+func (t *TokenContract) getTokenMetadata(ctx contractapi.TransactionContextInterface) (*TokenMetadata, error) {
+	metadataBytes, err := ctx.GetStub().GetState("token_metadata")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token metadata: %v", err)
+	}
+	if metadataBytes == nil {
+		return nil, fmt.Errorf("token is not initialised; invoke InitLedger first")
+	}
+
+	var metadata TokenMetadata
+	err = t.cbdc.decodeDocument("tokenMetadata", metadataBytes, &metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal token metadata: %v", err)
+	}
+
+	return &metadata, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestTokenContractQueries(t *testing.T) {
+	l := newTestLedger(t)
+	l.asUser("alice").mustFailWith("invoke InitLedger first", "token:Name")
+
+	l.asCentralBank().mustInvoke("InitLedger")
+	l.mustInvoke("InitLedger")
+	l.fund("bank1", "alice", 100)
+
+	if name := l.asUser("alice").mustInvoke("token:Name"); name != "Central Bank Digital Currency" {
+		t.Fatalf("unexpected token name %q", name)
+	}
+	if symbol := l.mustInvoke("token:Symbol"); symbol != defaultCurrency {
+		t.Fatalf("unexpected token symbol %q", symbol)
+	}
+	if decimals := l.mustInvoke("token:Decimals"); decimals != "2" {
+		t.Fatalf("unexpected token decimals %q", decimals)
+	}
+	if account := l.mustInvoke("token:ClientAccountID"); account != "alice" {
+		t.Fatalf("unexpected client account %q", account)
+	}
+
+	var balance, supply float64
+	l.mustQuery(&balance, "token:BalanceOf", "alice")
+	l.mustQuery(&supply, "token:TotalSupply")
+	if balance != 100 || supply != 100 {
+		t.Fatalf("token contract reports balance %.2f and supply %.2f, expected 100 each", balance, supply)
+	}
+}
+
+func TestTokenQueriesArePureReads(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("InitLedger")
+	l.fund("bank1", "alice", 100)
+	l.putLegacyState("balance_carol", `{"docType":"balance","accountId":"carol","balance":40,"modifiedAt":1}`)
+	l.asCentralBank().mustInvoke("SetRateSchedule", 1000.0, 0.5, 0.0)
+	l.now += secondsPerYear
+
+	before := map[string]string{}
+	for key, value := range l.stub.State {
+		before[key] = string(value)
+	}
+
+	// Balances held before supply was tracked count before the supply is seeded
+	var supply, balance float64
+	l.asUser("alice").mustQuery(&supply, "token:TotalSupply")
+	l.mustQuery(&balance, "token:BalanceOf", "alice")
+	if supply != 140 || balance != 150 {
+		t.Fatalf("token contract reports supply %.2f and balance %.2f, expected 140 and 150", supply, balance)
+	}
+	for key, value := range l.stub.State {
+		if before[key] != string(value) {
+			t.Fatalf("a token query wrote %s", key)
+		}
+	}
+
+	l.asCentralBank().mustInvoke("SeedSupply")
+	l.mustQuery(&supply, "token:TotalSupply")
+	if supply != 140 {
+		t.Fatalf("seeded supply is %.2f, expected 140", supply)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransactionContext is the context used by the CBDC contracts. The peer's stub only
+// returns committed state from GetState, so a record written twice in one transaction
+// (for example a balance touched by both a transfer and a fee) would lose the first
+// write; this context serves those pending writes back instead. It also carries the
+// action of an approved proposal while that proposal executes.
+type TransactionContext struct {
+	contractapi.TransactionContext
+	approvedAction string
+}
+
+// SetStub wraps the transaction's stub so that it reads its own writes
+func (ctx *TransactionContext) SetStub(stub shim.ChaincodeStubInterface) {
+	ctx.TransactionContext.SetStub(&pendingWritesStub{
+		ChaincodeStubInterface: stub,
+		writes:                 make(map[string][]byte),
+	})
+}
+
+// pendingWritesStub records PutState and DelState calls so GetState can see them.
+// Range and rich queries still only see committed state.
+type pendingWritesStub struct {
+	shim.ChaincodeStubInterface
+	writes map[string][]byte // A nil value marks a deleted key
+}
+
+func (s *pendingWritesStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *pendingWritesStub) PutState(key string, value []byte) error {
+	err := s.ChaincodeStubInterface.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *pendingWritesStub) DelState(key string) error {
+	err := s.ChaincodeStubInterface.DelState(key)
+	if err != nil {
+		return err
+	}
+	s.writes[key] = nil
+	return nil
+}
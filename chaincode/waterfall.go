@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// AccountSettings holds a user account's holding limit and linked commercial bank. The
+// record with an empty AccountID carries the default holding limit for users.
+type AccountSettings struct {
+	DocType          string  `json:"docType"`
+	SchemaVersion    int     `json:"schemaVersion"`
+	AccountID        string  `json:"accountId"`
+	HoldingLimit     float64 `json:"holdingLimit"`     // 0 falls back to the default limit
+	LinkedBankID     string  `json:"linkedBankId"`     // Receives the excess above the holding limit
+	ReverseWaterfall bool    `json:"reverseWaterfall"` // Pull shortfalls on outgoing payments from the linked bank
+	ModifiedAt       int64   `json:"modifiedAt"`
+}
+
+// SetHoldingLimit sets a user's holding limit, or the default limit for all users when
+// accountID is empty (Central Bank only). A limit of 0 removes it.
+func (s *SmartContract) SetHoldingLimit(ctx contractapi.TransactionContextInterface, accountID string, limit float64) (*AccountSettings, error) {
+	err := s.validateCentralBank(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("only central bank can set holding limits: %v", err)
+	}
+
+	err = s.requireApproval(ctx, "SetHoldingLimit")
+	if err != nil {
+		return nil, err
+	}
+
+	if limit < 0 {
+		return nil, fmt.Errorf("holding limit must not be negative")
+	}
+
+	settings, err := s.getAccountSettings(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	settings.HoldingLimit = limit
+	settings.ModifiedAt = time.Now().Unix()
+
+	err = s.putAccountSettings(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// SetLinkedBank links the caller's account to its servicing bank for waterfall sweeps and
+// opts in or out of the reverse waterfall
+func (s *SmartContract) SetLinkedBank(ctx contractapi.TransactionContextInterface, bankID string, reverseWaterfall bool) (*AccountSettings, error) {
+	accountID, err := s.getCallerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the bank that serves the account may be linked, as the reverse waterfall draws on it
+	if bankID != "" {
+		servicingBankID, err := s.getServicingBank(ctx, accountID)
+		if err != nil {
+			return nil, err
+		}
+		if bankID != servicingBankID {
+			return nil, fmt.Errorf("%s is not the servicing bank of %s", bankID, accountID)
+		}
+	}
+	if bankID == "" && reverseWaterfall {
+		return nil, fmt.Errorf("the reverse waterfall needs a linked bank")
+	}
+
+	settings, err := s.getAccountSettings(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	settings.LinkedBankID = bankID
+	settings.ReverseWaterfall = reverseWaterfall
+	settings.ModifiedAt = time.Now().Unix()
+
+	err = s.putAccountSettings(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// GetAccountSettings returns an account's holding limit and linked bank settings
+func (s *SmartContract) GetAccountSettings(ctx contractapi.TransactionContextInterface, accountID string) (*AccountSettings, error) {
+	return s.getAccountSettings(ctx, accountID)
+}
+
+// applyWaterfall sweeps whatever a user holds above its holding limit to the bank it linked. The
+// sweep is recorded as a further leg of the transaction.
+func (s *SmartContract) applyWaterfall(ctx contractapi.TransactionContextInterface, accountID string) error {
+	if !s.isUserAccount(ctx, accountID) {
+		return nil
+	}
+
+	settings, err := s.getAccountSettings(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	limit, err := s.getHoldingLimit(ctx, settings)
+	if err != nil || limit <= 0 {
+		return err
+	}
+
+	balance, err := s.getAccountBalance(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", accountID, err)
+	}
+	excess := balance.Balance - limit
+	if excess <= 0 {
+		return nil
+	}
+
+	bankID := settings.LinkedBankID
+	if bankID == "" {
+		return fmt.Errorf("payment would take %s above its holding limit of %.2f and it has no linked bank", accountID, limit)
+	}
+
+	err = s.changeBalance(ctx, accountID, -excess)
+	if err != nil {
+		return err
+	}
+	err = s.changeBalance(ctx, bankID, excess)
+	if err != nil {
+		return err
+	}
+
+	// Swept funds are back with the bank, like a defund
+	err = s.recordBankRedemption(ctx, bankID, excess)
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, accountID, bankID, excess, "Waterfall")
+	transaction.Leg, err = s.nextTransactionLeg(ctx)
+	if err != nil {
+		return err
+	}
+	return s.putTransaction(ctx, transaction)
+}
+
+// applyReverseWaterfall pulls the part of an outgoing payment the user cannot cover from its
+// linked bank, if the user opted in and the bank holds enough. Otherwise it does nothing and
+// the payment fails as before.
+func (s *SmartContract) applyReverseWaterfall(ctx contractapi.TransactionContextInterface, accountID string, amount float64) error {
+	if !s.isUserAccount(ctx, accountID) {
+		return nil
+	}
+
+	settings, err := s.getAccountSettings(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if !settings.ReverseWaterfall || settings.LinkedBankID == "" {
+		return nil
+	}
+
+	// A link is only honoured while the bank still serves the account
+	servicingBankID, err := s.getServicingBank(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	if settings.LinkedBankID != servicingBankID {
+		return nil
+	}
+
+	balance, err := s.getAccountBalance(ctx, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", accountID, err)
+	}
+	shortfall := amount - balance.Balance
+	if shortfall <= 0 {
+		return nil
+	}
+
+	bankBalance, err := s.getAccountBalance(ctx, settings.LinkedBankID)
+	if err != nil {
+		return fmt.Errorf("failed to get balance of %s: %v", settings.LinkedBankID, err)
+	}
+	if bankBalance.Balance < shortfall {
+		return nil
+	}
+
+	err = s.changeBalance(ctx, settings.LinkedBankID, -shortfall)
+	if err != nil {
+		return err
+	}
+	err = s.changeBalance(ctx, accountID, shortfall)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordDistribution(ctx, settings.LinkedBankID, accountID, shortfall)
+	if err != nil {
+		return err
+	}
+
+	transaction := s.newTransaction(ctx, settings.LinkedBankID, accountID, shortfall, "ReverseWaterfall")
+	transaction.Leg, err = s.nextTransactionLeg(ctx)
+	if err != nil {
+		return err
+	}
+	return s.putTransaction(ctx, transaction)
+}
+
+// isUserAccount reports whether holding limits apply to an account, i.e. it is neither the
+// central bank nor a commercial bank
+func (s *SmartContract) isUserAccount(ctx contractapi.TransactionContextInterface, accountID string) bool {
+	return accountID != s.getCentralBankID() && s.validateCommercialBank(ctx, accountID) != nil
+}
+
+func (s *SmartContract) getHoldingLimit(ctx contractapi.TransactionContextInterface, settings *AccountSettings) (float64, error) {
+	if settings.HoldingLimit > 0 || settings.AccountID == "" {
+		return settings.HoldingLimit, nil
+	}
+	defaults, err := s.getAccountSettings(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	return defaults.HoldingLimit, nil
+}
+
+func (s *SmartContract) getAccountSettingsKey(accountID string) string {
+	return "acctsettings_" + accountID
+}
+
+// getAccountSettings returns empty settings for an account that has none
+func (s *SmartContract) getAccountSettings(ctx contractapi.TransactionContextInterface, accountID string) (*AccountSettings, error) {
+	settingsBytes, err := ctx.GetStub().GetState(s.getAccountSettingsKey(accountID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account settings: %v", err)
+	}
+
+	settings := AccountSettings{DocType: "accountSettings", AccountID: accountID}
+	if settingsBytes != nil {
+		err = s.decodeDocument("accountSettings", settingsBytes, &settings)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal account settings: %v", err)
+		}
+	}
+
+	return &settings, nil
+}
+
+func (s *SmartContract) putAccountSettings(ctx contractapi.TransactionContextInterface, settings *AccountSettings) error {
+	settings.SchemaVersion = s.currentSchemaVersion("accountSettings")
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account settings: %v", err)
+	}
+	err = ctx.GetStub().PutState(s.getAccountSettingsKey(settings.AccountID), settingsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put account settings state: %v", err)
+	}
+	return nil
+}
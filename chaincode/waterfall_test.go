@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestWaterfallSweepsExcess(t *testing.T) {
+	l := newTestLedger(t)
+
+	l.asBank("bank1").mustFailWith("only central bank", "SetHoldingLimit", "", 50.0)
+	l.asCentralBank().mustInvoke("SetHoldingLimit", "", 50.0)
+
+	// Without a linked bank the excess has nowhere to go
+	l.asCentralBank().mustInvoke("IssueTokens", 80.0)
+	l.mustInvoke("TransferToCB", "bank1", 80.0)
+	l.asBank("bank1").mustFailWith("has no linked bank", "TransferToUser", "alice", 80.0)
+
+	// Only the bank serving the account can be linked
+	l.asUser("alice").mustFailWith("bank1 is not the servicing bank of alice", "SetLinkedBank", "bank1", false)
+	l.link("bank1", "alice")
+	l.asUser("alice").mustFailWith("bank2 is not the servicing bank of alice", "SetLinkedBank", "bank2", false)
+	l.mustFailWith("needs a linked bank", "SetLinkedBank", "", true)
+	l.mustInvoke("SetLinkedBank", "bank1", true)
+
+	l.asBank("bank1").mustInvoke("TransferToUser", "alice", 80.0)
+	l.expectBalance("alice", 50)
+	l.expectBalance("bank1", 30)
+
+	// Funding alice does not redirect her sweeps to bank2
+	l.fund("bank2", "alice", 5)
+	l.expectBalance("alice", 50)
+	l.expectBalance("bank1", 35)
+
+	l.fund("bank2", "bob", 40)
+	l.asUser("bob").mustInvoke("TransferTokens", "bob", "alice", 20.0)
+	sweepTxID := l.lastTxID()
+	l.expectBalance("alice", 50)
+	l.expectBalance("bank1", 55)
+
+	if sweep := l.transactionLeg(sweepTxID, 1); sweep.Type != "Waterfall" || sweep.FromID != "alice" || sweep.ToID != "bank1" || sweep.Amount != 20 {
+		t.Fatalf("unexpected sweep record %+v", sweep)
+	}
+
+	// A user with no bank at all cannot be pushed over its limit
+	l.asCentralBank().mustInvoke("SetHoldingLimit", "dave", 5.0)
+	l.asUser("bob").mustFailWith("has no linked bank", "TransferTokens", "bob", "dave", 10.0)
+	l.asCentralBank().mustInvoke("SetHoldingLimit", "dave", 100.0)
+	l.asUser("bob").mustInvoke("TransferTokens", "bob", "dave", 10.0)
+	l.expectBalance("dave", 10)
+}
+
+func TestReverseWaterfallCoversShortfall(t *testing.T) {
+	l := newTestLedger(t)
+	l.asCentralBank().mustInvoke("IssueTokens", 100.0)
+	l.mustInvoke("TransferToCB", "bank1", 100.0)
+	l.fund("bank1", "alice", 30)
+	l.link("bank1", "alice")
+
+	l.asUser("alice").mustInvoke("SetLinkedBank", "bank1", false)
+	l.mustFailWith("Insufficient balance", "TransferTokens", "alice", "carol", 50.0)
+
+	l.mustInvoke("SetLinkedBank", "bank1", true)
+	l.mustInvoke("TransferTokens", "alice", "carol", 50.0)
+	l.expectBalance("alice", 0)
+	l.expectBalance("carol", 50)
+	l.expectBalance("bank1", 80)
+
+	var settings AccountSettings
+	l.mustQuery(&settings, "GetAccountSettings", "alice")
+	if settings.LinkedBankID != "bank1" || !settings.ReverseWaterfall {
+		t.Fatalf("unexpected account settings %+v", settings)
+	}
+}